@@ -1,11 +1,21 @@
 package api
 
 import (
+	"auth-api/configs"
+	"auth-api/services/audit"
+	"auth-api/services/bff"
+	"auth-api/services/oauth"
+	"auth-api/services/organization"
+	"auth-api/services/policy"
+	"auth-api/services/rbac"
+	"auth-api/services/serviceaccount"
 	"auth-api/services/user"
 	"auth-api/utils"
 	"database/sql"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -20,17 +30,89 @@ func NewAPIServer(addr string, db *sql.DB) *APIServer {
 }
 
 func (s *APIServer) Run() error {
+	trustedProxies := strings.Split(configs.Envs.TrustedProxies, ",")
+	if configs.Envs.BFFEnabled && configs.Envs.BFFAuthURL == "" {
+		// The BFF calls this server over loopback and forwards the
+		// browser's address.
+		trustedProxies = append(trustedProxies, "127.0.0.1", "::1")
+	}
+	if err := utils.SetTrustedProxies(trustedProxies); err != nil {
+		return err
+	}
+
 	router := mux.NewRouter()
 
 	router.Use(utils.CORS)
 	router.Use(utils.LoggingMiddleware)
 	router.Use(utils.GzipMiddleware)
+	router.Use(utils.CSRFProtect)
 
 	subrouter := router.PathPrefix("/api/v1").Subrouter()
 
+	mailer, err := utils.NewMailer(configs.Envs)
+	if err != nil {
+		return err
+	}
+
 	userStore := user.NewStore(s.db)
-	userHandler := user.NewHandler(userStore)
+	utils.SetTokenRevocationChecker(userStore)
+
+	roleStore := rbac.NewStore(s.db)
+	utils.SetPermissionResolver(roleStore)
+
+	orgStore := organization.NewStore(s.db)
+
+	auditStore := audit.NewStore(s.db)
+	auditHandler := audit.NewHandler(auditStore)
+	auditHandler.RegisterRoutes(subrouter)
+	utils.SetImpersonationAuditor(audit.NewImpersonationAuditor(auditStore))
+
+	policies, err := policy.LoadDir(configs.Envs.PolicyDir)
+	if err != nil {
+		return err
+	}
+	policyEngine := policy.NewEngine(policies, configs.Envs.PolicyMode == configs.PolicyModeDryRun)
+	policyHandler := policy.NewHandler(policyEngine)
+	policyHandler.RegisterRoutes(subrouter)
+
+	userHandler := user.NewHandler(userStore, roleStore, orgStore, auditStore, policyEngine, mailer)
 	userHandler.RegisterRoutes(subrouter)
+	utils.SetPersonalAccessTokenResolver(userHandler)
+
+	rbacHandler := rbac.NewHandler(roleStore, userStore, auditStore)
+	rbacHandler.RegisterRoutes(subrouter)
+
+	orgHandler := organization.NewHandler(orgStore, userStore, auditStore, mailer)
+	orgHandler.RegisterRoutes(subrouter)
+
+	serviceAccountStore := serviceaccount.NewStore(s.db)
+	serviceAccountHandler := serviceaccount.NewHandler(serviceAccountStore, orgStore, auditStore)
+	serviceAccountHandler.RegisterRoutes(subrouter)
+	utils.SetAPIKeyResolver(serviceAccountHandler)
+
+	oauthHandler := oauth.NewHandler(auditStore)
+	oauthHandler.RegisterRoutes(subrouter)
+
+	user.StartPurgeWorker(userStore, configs.Envs.AccountPurgeInterval)
+
+	if configs.Envs.BFFEnabled {
+		authURL := configs.Envs.BFFAuthURL
+		if authURL == "" {
+			authURL = "http://127.0.0.1" + s.addr + "/api/v1"
+		}
+		sessionKey := configs.Envs.BFFSessionKey
+		if sessionKey == "" {
+			sessionKey = configs.Envs.JWTSecret
+		}
+
+		bffStore := bff.NewStore(s.db, sessionKey)
+		bffHandler, err := bff.NewHandler(bffStore, authURL, configs.Envs.BFFUpstreams)
+		if err != nil {
+			return err
+		}
+		bffHandler.RegisterRoutes(router)
+		bff.StartPruneWorker(bffStore, 1*time.Hour)
+	}
 
 	log.Println("Server listening on", s.addr)
 	return http.ListenAndServe(s.addr, router)
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS user_attribute_definitions;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS metadata,
+    DROP COLUMN IF EXISTS username_changed_at,
+    DROP COLUMN IF EXISTS avatar_url,
+    DROP COLUMN IF EXISTS timezone,
+    DROP COLUMN IF EXISTS locale,
+    DROP COLUMN IF EXISTS display_name;
\ No newline at end of file
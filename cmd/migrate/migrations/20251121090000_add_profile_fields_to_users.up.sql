@@ -0,0 +1,17 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS display_name TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS locale TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS timezone TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS avatar_url TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS username_changed_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}'::jsonb;
+
+CREATE TABLE IF NOT EXISTS user_attribute_definitions (
+    name TEXT PRIMARY KEY,
+    type TEXT NOT NULL,
+    required BOOLEAN NOT NULL DEFAULT FALSE,
+    max_length INT,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
\ No newline at end of file
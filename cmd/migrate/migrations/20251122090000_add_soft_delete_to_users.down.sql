@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS users_purge_after_idx;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS purge_after,
+    DROP COLUMN IF EXISTS deleted_at;
\ No newline at end of file
@@ -0,0 +1,5 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS purge_after TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS users_purge_after_idx ON users (purge_after) WHERE deleted_at IS NOT NULL;
\ No newline at end of file
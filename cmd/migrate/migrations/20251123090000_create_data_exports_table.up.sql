@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS data_exports (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    requested_by BIGINT REFERENCES users (id) ON DELETE SET NULL,
+    format TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    data BYTEA,
+    error TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ NOT NULL,
+    completed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
\ No newline at end of file
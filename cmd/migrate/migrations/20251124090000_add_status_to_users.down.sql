@@ -0,0 +1,5 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS tokens_invalid_before,
+    DROP COLUMN IF EXISTS status_expires_at,
+    DROP COLUMN IF EXISTS status_reason,
+    DROP COLUMN IF EXISTS status;
\ No newline at end of file
@@ -0,0 +1,5 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active',
+    ADD COLUMN IF NOT EXISTS status_reason TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS status_expires_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS tokens_invalid_before TIMESTAMPTZ;
\ No newline at end of file
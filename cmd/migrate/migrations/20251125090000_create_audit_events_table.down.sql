@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN IF EXISTS must_change_password;
+
+DROP TABLE IF EXISTS audit_events;
\ No newline at end of file
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS users_status_idx;
+DROP INDEX IF EXISTS users_role_idx;
+DROP INDEX IF EXISTS users_email_idx;
+DROP INDEX IF EXISTS users_username_idx;
+DROP INDEX IF EXISTS users_created_at_idx;
\ No newline at end of file
@@ -0,0 +1,5 @@
+CREATE INDEX IF NOT EXISTS users_created_at_idx ON users (created_at, id);
+CREATE INDEX IF NOT EXISTS users_username_idx ON users (username, id);
+CREATE INDEX IF NOT EXISTS users_email_idx ON users (email, id);
+CREATE INDEX IF NOT EXISTS users_role_idx ON users (role);
+CREATE INDEX IF NOT EXISTS users_status_idx ON users (status);
\ No newline at end of file
@@ -0,0 +1,54 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    system BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id BIGINT NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    permission_id BIGINT NOT NULL REFERENCES permissions (id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role_id BIGINT NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO roles (name, description, system) VALUES
+    ('user', 'Default role for every account', TRUE),
+    ('admin', 'Full administrative access', TRUE)
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO permissions (name, description) VALUES
+    ('users:read', 'List and view users'),
+    ('users:write', 'Create users and change their details, status and passwords'),
+    ('users:delete', 'Delete users'),
+    ('users:export', 'Export user data'),
+    ('users:attributes', 'Manage custom user attribute definitions'),
+    ('audit:read', 'Read the audit trail'),
+    ('roles:manage', 'Manage roles, permissions and role assignments')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+  FROM roles r
+ CROSS JOIN permissions p
+ WHERE r.name = 'admin'
+ON CONFLICT DO NOTHING;
+
+INSERT INTO user_roles (user_id, role_id)
+SELECT u.id, r.id
+  FROM users u
+  JOIN roles r ON r.name = u.role
+ON CONFLICT DO NOTHING;
\ No newline at end of file
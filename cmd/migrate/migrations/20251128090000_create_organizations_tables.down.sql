@@ -0,0 +1,9 @@
+DELETE FROM permissions WHERE name = 'platform:admin';
+
+DROP INDEX IF EXISTS idx_audit_events_org_id;
+ALTER TABLE audit_events DROP COLUMN IF EXISTS org_id;
+ALTER TABLE users DROP COLUMN IF EXISTS active_org_id;
+
+DROP TABLE IF EXISTS organization_invitations;
+DROP TABLE IF EXISTS memberships;
+DROP TABLE IF EXISTS organizations;
\ No newline at end of file
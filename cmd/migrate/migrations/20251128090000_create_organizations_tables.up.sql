@@ -0,0 +1,49 @@
+CREATE TABLE IF NOT EXISTS organizations (
+    id BIGSERIAL PRIMARY KEY,
+    slug TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS memberships (
+    org_id BIGINT NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role TEXT NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'admin', 'member')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (org_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_memberships_user_id ON memberships (user_id);
+
+CREATE TABLE IF NOT EXISTS organization_invitations (
+    id BIGSERIAL PRIMARY KEY,
+    org_id BIGINT NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    role TEXT NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'admin', 'member')),
+    token_hash TEXT NOT NULL UNIQUE,
+    invited_by BIGINT REFERENCES users (id) ON DELETE SET NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    accepted_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_organization_invitations_org_id ON organization_invitations (org_id);
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS active_org_id BIGINT REFERENCES organizations (id) ON DELETE SET NULL;
+
+ALTER TABLE audit_events
+    ADD COLUMN IF NOT EXISTS org_id BIGINT REFERENCES organizations (id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_org_id ON audit_events (org_id);
+
+INSERT INTO permissions (name, description) VALUES
+    ('platform:admin', 'Act across every organization and manage organizations')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+  FROM roles r
+  JOIN permissions p ON p.name = 'platform:admin'
+ WHERE r.name = 'admin'
+ON CONFLICT DO NOTHING;
\ No newline at end of file
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE name = 'service_accounts:manage';
+
+DROP TABLE IF EXISTS service_account_keys;
+DROP TABLE IF EXISTS service_accounts;
\ No newline at end of file
@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS service_accounts (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    org_id BIGINT REFERENCES organizations (id) ON DELETE CASCADE,
+    created_by BIGINT REFERENCES users (id) ON DELETE SET NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    ip_allowlist TEXT[] NOT NULL DEFAULT '{}',
+    disabled_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_service_accounts_org_id ON service_accounts (org_id);
+
+CREATE TABLE IF NOT EXISTS service_account_keys (
+    id BIGSERIAL PRIMARY KEY,
+    service_account_id BIGINT NOT NULL REFERENCES service_accounts (id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    key_prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    last_used_ip TEXT NOT NULL DEFAULT '',
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_service_account_keys_account_id ON service_account_keys (service_account_id);
+
+INSERT INTO permissions (name, description) VALUES
+    ('service_accounts:manage', 'Manage service accounts and their API keys')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+  FROM roles r
+  JOIN permissions p ON p.name = 'service_accounts:manage'
+ WHERE r.name = 'admin'
+ON CONFLICT DO NOTHING;
\ No newline at end of file
@@ -0,0 +1,10 @@
+INSERT INTO permissions (name, description) VALUES
+    ('users:impersonate', 'Act as another user with a short-lived token')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+  FROM roles r
+  JOIN permissions p ON p.name = 'users:impersonate'
+ WHERE r.name = 'admin'
+ON CONFLICT DO NOTHING;
\ No newline at end of file
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_refresh_session_id;
+ALTER TABLE refresh DROP COLUMN IF EXISTS session_id;
+
+DROP TABLE IF EXISTS sessions;
\ No newline at end of file
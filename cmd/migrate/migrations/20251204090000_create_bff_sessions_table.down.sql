@@ -0,0 +1 @@
+DROP TABLE IF EXISTS bff_sessions;
\ No newline at end of file
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS bff_sessions (
+    id BIGSERIAL PRIMARY KEY,
+    session_hash TEXT NOT NULL UNIQUE,
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    access_token TEXT NOT NULL,
+    refresh_token TEXT NOT NULL,
+    access_expires_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_bff_sessions_user_id ON bff_sessions (user_id);
+CREATE INDEX IF NOT EXISTS idx_bff_sessions_expires_at ON bff_sessions (expires_at);
\ No newline at end of file
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS login_lockouts (
+    id BIGSERIAL PRIMARY KEY,
+    kind TEXT NOT NULL,
+    key TEXT NOT NULL,
+    user_id BIGINT REFERENCES users (id) ON DELETE CASCADE,
+    failed_count INT NOT NULL DEFAULT 0,
+    last_failed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_failed_ip TEXT NOT NULL DEFAULT '',
+    retry_after TIMESTAMPTZ,
+    locked_until TIMESTAMPTZ,
+    unlock_token_hash TEXT UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (kind, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_lockouts_user_id ON login_lockouts (user_id);
+CREATE INDEX IF NOT EXISTS idx_login_lockouts_last_failed_at ON login_lockouts (last_failed_at);
\ No newline at end of file
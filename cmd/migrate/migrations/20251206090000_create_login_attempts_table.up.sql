@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT REFERENCES users (id) ON DELETE CASCADE,
+    identifier TEXT NOT NULL DEFAULT '',
+    method TEXT NOT NULL,
+    outcome TEXT NOT NULL,
+    ip TEXT NOT NULL DEFAULT '',
+    network TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    device_name TEXT NOT NULL DEFAULT '',
+    new_device BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_user_id_created_at ON login_attempts (user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_created_at ON login_attempts (created_at);
\ No newline at end of file
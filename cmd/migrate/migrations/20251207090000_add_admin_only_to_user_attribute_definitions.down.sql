@@ -0,0 +1,2 @@
+ALTER TABLE user_attribute_definitions
+    DROP COLUMN IF EXISTS admin_only;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE user_attribute_definitions
+    ADD COLUMN IF NOT EXISTS admin_only BOOLEAN NOT NULL DEFAULT FALSE;
\ No newline at end of file
@@ -0,0 +1,2 @@
+-- The cleared identifiers cannot be restored.
+SELECT 1;
\ No newline at end of file
@@ -0,0 +1,3 @@
+UPDATE login_attempts
+   SET identifier = ''
+ WHERE user_id IS NULL;
\ No newline at end of file
@@ -3,6 +3,8 @@ package configs
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -17,8 +19,87 @@ type Config struct {
 	DBName             string
 	JWTSecret          string
 	CORSAllowedOrigins string
+	TrustedProxies     string
+
+	AppURL                string
+	MailFrom              string
+	EmailVerificationMode string
+	EmailChangeRevokes    bool
+
+	UsernameChangeCooldown time.Duration
+
+	AccountDeletionGracePeriod time.Duration
+	AccountPurgeInterval       time.Duration
+
+	ExportSyncMaxRecords int
+	ExportRetention      time.Duration
+
+	MailDriver   string
+	MailDropDir  string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+
+	PolicyDir  string
+	PolicyMode string
+
+	APIKeyRotationOverlap time.Duration
+
+	ImpersonationTokenTTL time.Duration
+
+	TokenAudience          string
+	TokenExchangeAudiences string
+	TokenExchangeActors    string
+	TokenExchangeTTL       time.Duration
+
+	MaxSessionsPerUser      int
+	SessionLimitPolicy      string
+	SessionIdleTimeout      time.Duration
+	SessionAbsoluteLifetime time.Duration
+
+	CookieMode        bool
+	RefreshCookieName string
+	RefreshCookiePath string
+	CSRFCookieName    string
+	CookieDomain      string
+	CookieSecure      bool
+	CookieSameSite    string
+
+	BFFEnabled    bool
+	BFFAuthURL    string
+	BFFUpstreams  string
+	BFFCookieName string
+	BFFSessionKey string
+	BFFSessionTTL time.Duration
+
+	LoginBackoffAfter     int
+	LoginBackoffBase      time.Duration
+	LoginBackoffMax       time.Duration
+	LoginLockoutThreshold int
+	LoginLockoutDuration  time.Duration
+	LoginFailureWindow    time.Duration
+
+	LoginHistoryRetention time.Duration
+	NewDeviceAlerts       bool
 }
 
+const (
+	EmailVerificationOff      = "off"
+	EmailVerificationBlock    = "block"
+	EmailVerificationRestrict = "restrict"
+)
+
+const (
+	PolicyModeEnforce = "enforce"
+	PolicyModeDryRun  = "dry-run"
+)
+
+const (
+	SessionLimitEvictOldest = "evict-oldest"
+	SessionLimitRefuse      = "refuse"
+)
+
 var Envs Config
 
 func init() {
@@ -38,5 +119,128 @@ func init() {
 		DBName:             os.Getenv("DB_NAME"),
 		JWTSecret:          os.Getenv("JWT_SECRET"),
 		CORSAllowedOrigins: os.Getenv("CORS_ALLOWED_ORIGINS"),
+		TrustedProxies:     os.Getenv("TRUSTED_PROXIES"),
+
+		AppURL:                getEnv("APP_URL", "http://localhost:3000"),
+		MailFrom:              getEnv("MAIL_FROM", "no-reply@localhost"),
+		EmailVerificationMode: getEnv("EMAIL_VERIFICATION_MODE", EmailVerificationOff),
+		EmailChangeRevokes:    getEnvBool("EMAIL_CHANGE_REVOKE_SESSIONS", true),
+
+		UsernameChangeCooldown: getEnvDuration("USERNAME_CHANGE_COOLDOWN", 30*24*time.Hour),
+
+		AccountDeletionGracePeriod: getEnvDuration("ACCOUNT_DELETION_GRACE_PERIOD", 30*24*time.Hour),
+		AccountPurgeInterval:       getEnvInterval("ACCOUNT_PURGE_INTERVAL", 1*time.Hour),
+
+		ExportSyncMaxRecords: getEnvInt("EXPORT_SYNC_MAX_RECORDS", 1000),
+		ExportRetention:      getEnvDuration("EXPORT_RETENTION", 7*24*time.Hour),
+
+		MailDriver:   getEnv("MAIL_DRIVER", "log"),
+		MailDropDir:  getEnv("MAIL_DROP_DIR", "tmp/mail"),
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+
+		PolicyDir:  getEnv("POLICY_DIR", "policies"),
+		PolicyMode: getEnv("POLICY_MODE", PolicyModeEnforce),
+
+		APIKeyRotationOverlap: getEnvDuration("API_KEY_ROTATION_OVERLAP", 24*time.Hour),
+
+		ImpersonationTokenTTL: getEnvDuration("IMPERSONATION_TOKEN_TTL", 15*time.Minute),
+
+		TokenAudience:          getEnv("TOKEN_AUDIENCE", "auth-api"),
+		TokenExchangeAudiences: os.Getenv("TOKEN_EXCHANGE_AUDIENCES"),
+		TokenExchangeActors:    os.Getenv("TOKEN_EXCHANGE_ACTORS"),
+		TokenExchangeTTL:       getEnvDuration("TOKEN_EXCHANGE_TTL", 5*time.Minute),
+
+		MaxSessionsPerUser:      getEnvInt("MAX_SESSIONS_PER_USER", 10),
+		SessionLimitPolicy:      getEnv("SESSION_LIMIT_POLICY", SessionLimitEvictOldest),
+		SessionIdleTimeout:      getEnvDuration("SESSION_IDLE_TIMEOUT", 14*24*time.Hour),
+		SessionAbsoluteLifetime: getEnvDuration("SESSION_ABSOLUTE_LIFETIME", 90*24*time.Hour),
+
+		CookieMode:        getEnvBool("COOKIE_MODE", false),
+		RefreshCookieName: getEnv("REFRESH_COOKIE_NAME", "refresh_token"),
+		RefreshCookiePath: getEnv("REFRESH_COOKIE_PATH", "/api/v1/refresh"),
+		CSRFCookieName:    getEnv("CSRF_COOKIE_NAME", "csrf_token"),
+		CookieDomain:      os.Getenv("COOKIE_DOMAIN"),
+		CookieSecure:      getEnvBool("COOKIE_SECURE", true),
+		CookieSameSite:    getEnv("COOKIE_SAMESITE", "strict"),
+
+		BFFEnabled:    getEnvBool("BFF_ENABLED", false),
+		BFFAuthURL:    os.Getenv("BFF_AUTH_URL"),
+		BFFUpstreams:  os.Getenv("BFF_UPSTREAMS"),
+		BFFCookieName: getEnv("BFF_COOKIE_NAME", "bff_session"),
+		BFFSessionKey: os.Getenv("BFF_SESSION_KEY"),
+		BFFSessionTTL: getEnvDuration("BFF_SESSION_TTL", 8*time.Hour),
+
+		LoginBackoffAfter:     getEnvInt("LOGIN_BACKOFF_AFTER", 3),
+		LoginBackoffBase:      getEnvDuration("LOGIN_BACKOFF_BASE", 1*time.Second),
+		LoginBackoffMax:       getEnvDuration("LOGIN_BACKOFF_MAX", 1*time.Minute),
+		LoginLockoutThreshold: getEnvInt("LOGIN_LOCKOUT_THRESHOLD", 10),
+		LoginLockoutDuration:  getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		LoginFailureWindow:    getEnvDuration("LOGIN_FAILURE_WINDOW", 1*time.Hour),
+
+		LoginHistoryRetention: getEnvDuration("LOGIN_HISTORY_RETENTION", 90*24*time.Hour),
+		NewDeviceAlerts:       getEnvBool("NEW_DEVICE_ALERTS", true),
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return fallback
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Warning: invalid boolean for %s=%q, using %t", key, v, fallback)
+		return fallback
+	}
+	return b
+}
+
+func getEnvInt(key string, fallback int) int {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return fallback
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Warning: invalid integer for %s=%q, using %d", key, v, fallback)
+		return fallback
+	}
+	return i
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Warning: invalid duration for %s=%q, using %s", key, v, fallback)
+		return fallback
+	}
+	return d
+}
+
+// getEnvInterval is getEnvDuration for how often something runs, where
+// zero or a negative value cannot work and the fallback is used instead.
+func getEnvInterval(key string, fallback time.Duration) time.Duration {
+	d := getEnvDuration(key, fallback)
+	if d <= 0 {
+		log.Printf("Warning: %s must be positive, using %s", key, fallback)
+		return fallback
 	}
+	return d
 }
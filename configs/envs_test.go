@@ -0,0 +1,23 @@
+package configs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetEnvInterval(t *testing.T) {
+	tests := map[string]time.Duration{
+		"30m":  30 * time.Minute,
+		"0":    time.Hour,
+		"0s":   time.Hour,
+		"-5m":  time.Hour,
+		"soon": time.Hour,
+		"":     time.Hour,
+	}
+	for value, want := range tests {
+		t.Setenv("TEST_INTERVAL", value)
+		if got := getEnvInterval("TEST_INTERVAL", time.Hour); got != want {
+			t.Errorf("getEnvInterval(%q) = %s, want %s", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"auth-api/types"
+	"auth-api/utils"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type Handler struct {
+	store types.AuditStore
+}
+
+func NewHandler(store types.AuditStore) *Handler {
+	return &Handler{store: store}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.Handle("/admin/audit-events",
+		utils.AuthMiddleware(utils.RequirePermission(utils.PermAuditRead)(http.HandlerFunc(h.handleListEvents))),
+	).Methods("GET")
+}
+
+func (h *Handler) handleListEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	filter := types.AuditEventFilter{
+		TargetType: q.Get("targetType"),
+		TargetID:   q.Get("targetId"),
+		Action:     q.Get("action"),
+		Limit:      50,
+	}
+
+	if v := q.Get("actorId"); v != "" {
+		actorID, err := strconv.Atoi(v)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, errors.New("invalid actorId"))
+			return
+		}
+		filter.ActorID = &actorID
+	}
+
+	orgID, all, err := utils.OrganizationScope(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusForbidden, err)
+		return
+	}
+	if !all {
+		filter.OrgID = &orgID
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 200 {
+			utils.WriteError(w, http.StatusBadRequest, errors.New("limit must be between 1 and 200"))
+			return
+		}
+		filter.Limit = limit
+	}
+
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			utils.WriteError(w, http.StatusBadRequest, errors.New("invalid offset"))
+			return
+		}
+		filter.Offset = offset
+	}
+
+	events, err := h.store.ListEvents(filter)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, events)
+}
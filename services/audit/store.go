@@ -0,0 +1,112 @@
+package audit
+
+import (
+	"auth-api/types"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) RecordEvent(event types.AuditEvent) error {
+	metadata, err := json.Marshal(event.Metadata)
+	if err != nil {
+		return err
+	}
+	if event.Metadata == nil {
+		metadata = []byte("{}")
+	}
+
+	_, err = s.db.Exec(
+		`INSERT INTO audit_events (actor_id, action, target_type, target_id, metadata, ip, user_agent, org_id)
+         VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		event.ActorID,
+		event.Action,
+		event.TargetType,
+		event.TargetID,
+		metadata,
+		event.IP,
+		event.UserAgent,
+		event.OrgID,
+	)
+	return err
+}
+
+func (s *Store) ListEvents(filter types.AuditEventFilter) ([]types.AuditEvent, error) {
+	var where []string
+	var args []any
+
+	addFilter := func(column string, v any) {
+		args = append(args, v)
+		where = append(where, column+" = $"+strconv.Itoa(len(args)))
+	}
+
+	if filter.ActorID != nil {
+		addFilter("actor_id", *filter.ActorID)
+	}
+	if filter.TargetType != "" {
+		addFilter("target_type", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		addFilter("target_id", filter.TargetID)
+	}
+	if filter.Action != "" {
+		addFilter("action", filter.Action)
+	}
+	if filter.OrgID != nil {
+		addFilter("org_id", *filter.OrgID)
+	}
+
+	query := `SELECT id, actor_id, action, target_type, target_id, metadata, ip, user_agent, org_id, created_at
+                FROM audit_events`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+	query += " ORDER BY id DESC LIMIT $" + strconv.Itoa(len(args)-1) + " OFFSET $" + strconv.Itoa(len(args))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []types.AuditEvent{}
+	for rows.Next() {
+		var e types.AuditEvent
+		var metadata []byte
+		if err := rows.Scan(
+			&e.ID,
+			&e.ActorID,
+			&e.Action,
+			&e.TargetType,
+			&e.TargetID,
+			&metadata,
+			&e.IP,
+			&e.UserAgent,
+			&e.OrgID,
+			&e.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(metadata, &e.Metadata); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -0,0 +1,127 @@
+package bff
+
+import (
+	"auth-api/configs"
+	"auth-api/types"
+	"auth-api/utils"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// authClient calls the auth API's login, refresh and logout endpoints on
+// behalf of a browser. The browser's address and user agent are passed on
+// so sessions, device names and rate limits see the real client.
+type authClient struct {
+	baseURL string
+	http    *http.Client
+}
+
+func newAuthClient(baseURL string) *authClient {
+	return &authClient{
+		baseURL: baseURL,
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// authError is a non-2xx answer from the auth API.
+type authError struct {
+	status int
+	body   []byte
+}
+
+func (e *authError) Error() string {
+	return fmt.Sprintf("auth api responded with status %d", e.status)
+}
+
+// tokenPair is a login or refresh response. body holds the remaining
+// response fields, such as passwordChangeRequired.
+type tokenPair struct {
+	accessToken  string
+	refreshToken string
+	body         map[string]any
+}
+
+func (c *authClient) login(r *http.Request, payload types.LoginPayload) (*tokenPair, error) {
+	return c.tokens(r, "/login", payload)
+}
+
+func (c *authClient) refresh(r *http.Request, refreshToken string) (*tokenPair, error) {
+	return c.tokens(r, "/refresh", types.RefreshPayload{RefreshToken: refreshToken})
+}
+
+func (c *authClient) logout(r *http.Request, refreshToken string) error {
+	_, _, err := c.post(r, "/logout", types.RefreshPayload{RefreshToken: refreshToken})
+	return err
+}
+
+func (c *authClient) tokens(r *http.Request, path string, payload any) (*tokenPair, error) {
+	body, cookies, err := c.post(r, path, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	pair := &tokenPair{body: body}
+	pair.accessToken, _ = body["accessToken"].(string)
+	pair.refreshToken, _ = body["refreshToken"].(string)
+
+	// With COOKIE_MODE on, the refresh token comes back as a cookie.
+	if pair.refreshToken == "" {
+		for _, cookie := range cookies {
+			if cookie.Name == configs.Envs.RefreshCookieName {
+				pair.refreshToken = cookie.Value
+			}
+		}
+	}
+
+	if pair.accessToken == "" || pair.refreshToken == "" {
+		return nil, errors.New("auth api response is missing tokens")
+	}
+
+	delete(body, "accessToken")
+	delete(body, "refreshToken")
+	delete(body, "csrfToken")
+
+	return pair, nil
+}
+
+func (c *authClient) post(r *http.Request, path string, payload any) (map[string]any, []*http.Cookie, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, c.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", r.UserAgent())
+	req.Header.Set("X-Forwarded-For", utils.ClientIP(r))
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, nil, &authError{status: resp.StatusCode, body: raw}
+	}
+
+	body := map[string]any{}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return nil, nil, err
+	}
+
+	return body, resp.Cookies(), nil
+}
@@ -0,0 +1,372 @@
+package bff
+
+import (
+	"auth-api/configs"
+	"auth-api/types"
+	"auth-api/utils"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// refreshLeeway renews the access token shortly before it expires, so
+	// a proxied request never reaches an upstream with a dead token.
+	refreshLeeway = 30 * time.Second
+
+	// touchInterval limits how often use of a session pushes its expiry
+	// forward.
+	touchInterval = time.Minute
+)
+
+// Handler is a backend-for-frontend: the browser signs in through it and
+// then calls upstream APIs through it, while the access and refresh tokens
+// never leave the server.
+type Handler struct {
+	store     types.BFFSessionStore
+	auth      *authClient
+	authProxy *httputil.ReverseProxy
+	upstreams map[string]*httputil.ReverseProxy
+}
+
+// NewHandler fails when authURL or an entry of upstreams (see
+// parseUpstreams) is malformed.
+func NewHandler(store types.BFFSessionStore, authURL, upstreams string) (*Handler, error) {
+	base, err := url.Parse(authURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid BFF_AUTH_URL %q", authURL)
+	}
+
+	targets, err := parseUpstreams(upstreams)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Handler{
+		store:     store,
+		auth:      newAuthClient(strings.TrimSuffix(base.String(), "/")),
+		authProxy: newProxy(base),
+		upstreams: make(map[string]*httputil.ReverseProxy, len(targets)),
+	}
+	for name, target := range targets {
+		h.upstreams[name] = newProxy(target)
+	}
+
+	return h, nil
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.Handle("/bff/login", utils.RateLimit(10, 1*time.Minute)(http.HandlerFunc(h.handleLogin))).Methods("POST")
+	router.HandleFunc("/bff/logout", h.handleLogout).Methods("POST")
+	router.HandleFunc("/bff/user", h.handleUser).Methods("GET")
+	router.PathPrefix("/bff/api/").HandlerFunc(h.handleProxy)
+}
+
+func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var payload types.LoginPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	pair, err := h.auth.login(r, payload)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
+	userID, accessExpiresAt, err := parseAccessToken(pair.accessToken)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	sessionToken, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	_, err = h.store.CreateBFFSession(types.BFFSession{
+		UserID:          userID,
+		AccessToken:     pair.accessToken,
+		RefreshToken:    pair.refreshToken,
+		AccessExpiresAt: accessExpiresAt,
+		ExpiresAt:       time.Now().UTC().Add(configs.Envs.BFFSessionTTL),
+	}, utils.HashToken(sessionToken))
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Signing in again replaces whatever session the browser had before.
+	if previous, err := h.lookupSession(r); err == nil {
+		h.endSession(r, previous)
+	}
+
+	csrfToken, err := utils.SetCSRFCookie(w, time.Time{})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	utils.SetBFFSessionCookie(w, sessionToken)
+
+	pair.body["csrfToken"] = csrfToken
+	utils.WriteJSON(w, http.StatusOK, pair.body)
+}
+
+func (h *Handler) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if session, err := h.lookupSession(r); err == nil {
+		h.endSession(r, session)
+	}
+
+	utils.ClearBFFCookies(w)
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "logged out",
+	})
+}
+
+// handleUser returns the signed-in user's profile from the auth API.
+func (h *Handler) handleUser(w http.ResponseWriter, r *http.Request) {
+	session, ok := h.session(w, r)
+	if !ok {
+		return
+	}
+
+	forward(w, r, h.authProxy, "/me", session)
+}
+
+// handleProxy forwards /bff/api/<name>/<path> to <path> on the upstream
+// registered as name, with the session's access token attached.
+func (h *Handler) handleProxy(w http.ResponseWriter, r *http.Request) {
+	name, path, _ := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), "/bff/api/"), "/")
+
+	proxy, ok := h.upstreams[name]
+	if !ok {
+		utils.WriteError(w, http.StatusNotFound, errors.New("unknown upstream"))
+		return
+	}
+
+	session, ok := h.session(w, r)
+	if !ok {
+		return
+	}
+
+	forward(w, r, proxy, "/"+path, session)
+}
+
+// session loads the browser's session, refreshing its tokens when the
+// access token is about to expire. A session the auth API no longer
+// accepts is removed and the browser is told to sign in again.
+func (h *Handler) session(w http.ResponseWriter, r *http.Request) (*types.BFFSession, bool) {
+	session, err := h.lookupSession(r)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.ClearBFFCookies(w)
+		utils.WriteErrorCode(w, http.StatusUnauthorized, "session_expired", errors.New("not signed in"))
+		return nil, false
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	now := time.Now().UTC()
+	if session.AccessExpiresAt.Sub(now) >= refreshLeeway {
+		if now.Sub(session.LastUsedAt) > touchInterval {
+			_ = h.store.TouchBFFSession(session.ID, now.Add(configs.Envs.BFFSessionTTL))
+		}
+		return session, true
+	}
+
+	refreshed, err := h.refresh(r, session.ID)
+	var authErr *authError
+	if errors.Is(err, sql.ErrNoRows) || (errors.As(err, &authErr) && authErr.status < 500) {
+		_ = h.store.DeleteBFFSession(session.ID)
+		utils.ClearBFFCookies(w)
+		utils.WriteErrorCode(w, http.StatusUnauthorized, "session_expired", errors.New("session expired, please sign in again"))
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("bff: refreshing session %d: %v", session.ID, err)
+		utils.WriteError(w, http.StatusBadGateway, errors.New("failed to refresh session"))
+		return nil, false
+	}
+
+	return refreshed, true
+}
+
+// refresh swaps the session's refresh token for a new token pair. The row
+// stays locked meanwhile, so when parallel requests find the same stale
+// token only the first refreshes it and the rest reuse its result.
+func (h *Handler) refresh(r *http.Request, sessionID int) (*types.BFFSession, error) {
+	return h.store.UpdateBFFSessionLocked(sessionID, func(session *types.BFFSession) error {
+		now := time.Now().UTC()
+		if session.AccessExpiresAt.Sub(now) >= refreshLeeway {
+			return nil
+		}
+
+		pair, err := h.auth.refresh(r, session.RefreshToken)
+		if err != nil {
+			return err
+		}
+
+		_, accessExpiresAt, err := parseAccessToken(pair.accessToken)
+		if err != nil {
+			return err
+		}
+
+		session.AccessToken = pair.accessToken
+		session.RefreshToken = pair.refreshToken
+		session.AccessExpiresAt = accessExpiresAt
+		session.ExpiresAt = now.Add(configs.Envs.BFFSessionTTL)
+		return nil
+	})
+}
+
+func (h *Handler) lookupSession(r *http.Request) (*types.BFFSession, error) {
+	token, ok := utils.BFFSessionFromCookie(r)
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return h.store.GetBFFSessionByHash(utils.HashToken(token))
+}
+
+// endSession signs the session out of the auth API as well, so its refresh
+// token cannot be used even if it leaked from the database.
+func (h *Handler) endSession(r *http.Request, session *types.BFFSession) {
+	if err := h.auth.logout(r, session.RefreshToken); err != nil {
+		log.Printf("bff: logging out session %d: %v", session.ID, err)
+	}
+	_ = h.store.DeleteBFFSession(session.ID)
+}
+
+// forward sends r to proxy at the given escaped path, authenticated as the
+// session's user.
+func forward(w http.ResponseWriter, r *http.Request, proxy *httputil.ReverseProxy, path string, session *types.BFFSession) {
+	unescaped, err := url.PathUnescape(path)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("invalid path"))
+		return
+	}
+
+	out := r.Clone(r.Context())
+	out.URL.Path = unescaped
+	out.URL.RawPath = path
+	out.Header.Set("Authorization", "Bearer "+session.AccessToken)
+
+	proxy.ServeHTTP(w, out)
+}
+
+// newProxy strips the browser's cookies from requests to target, and drops
+// cookies and CORS headers from its responses, which would otherwise apply
+// to the BFF's own origin.
+func newProxy(target *url.URL) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			pr.Out.Header.Set("X-Forwarded-For", utils.ClientIP(pr.In))
+			pr.Out.Header.Del("Cookie")
+			pr.Out.Header.Del(utils.CSRFHeader)
+			// The transport negotiates compression with the upstream on its
+			// own; GzipMiddleware compresses the response for the browser.
+			pr.Out.Header.Del("Accept-Encoding")
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			resp.Header.Del("Set-Cookie")
+			for key := range resp.Header {
+				if strings.HasPrefix(key, "Access-Control-") {
+					resp.Header.Del(key)
+				}
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("bff: proxying %s: %v", r.URL.Path, err)
+			utils.WriteError(w, http.StatusBadGateway, errors.New("upstream unavailable"))
+		},
+	}
+}
+
+// writeAuthError relays a rejected login as the auth API answered it, so
+// the browser sees the same errors and codes as with a direct login.
+func writeAuthError(w http.ResponseWriter, err error) {
+	var authErr *authError
+	if !errors.As(err, &authErr) {
+		log.Printf("bff: calling auth api: %v", err)
+		utils.WriteError(w, http.StatusBadGateway, errors.New("auth service unavailable"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(authErr.status)
+	_, _ = w.Write(authErr.body)
+}
+
+func parseAccessToken(token string) (int, time.Time, error) {
+	claims, err := utils.ParseToken(token)
+	if err != nil || claims.ExpiresAt == nil {
+		return 0, time.Time{}, errors.New("auth api returned an unusable access token")
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || userID <= 0 {
+		return 0, time.Time{}, errors.New("auth api returned an unusable access token")
+	}
+
+	return userID, claims.ExpiresAt.Time, nil
+}
+
+// parseUpstreams reads BFF_UPSTREAMS, a comma-separated list of name=url
+// pairs such as "orders=http://orders:8080/v1".
+func parseUpstreams(raw string) (map[string]*url.URL, error) {
+	out := map[string]*url.URL{}
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, target, _ := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		u, err := url.Parse(strings.TrimSpace(target))
+		if name == "" || strings.Contains(name, "/") || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid BFF_UPSTREAMS entry %q", part)
+		}
+		if _, ok := out[name]; ok {
+			return nil, fmt.Errorf("duplicate BFF_UPSTREAMS name %q", name)
+		}
+
+		out[name] = u
+	}
+	return out, nil
+}
+
+// StartPruneWorker deletes expired sessions once per interval.
+func StartPruneWorker(store types.BFFSessionStore, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for ; ; <-ticker.C {
+			if n, err := store.DeleteExpiredBFFSessions(); err != nil {
+				log.Printf("bff prune worker: %v", err)
+			} else if n > 0 {
+				log.Printf("bff prune worker: removed %d expired session(s)", n)
+			}
+		}
+	}()
+}
@@ -0,0 +1,213 @@
+package bff
+
+import (
+	"auth-api/types"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+// Store keeps the tokens encrypted with a key derived from the session
+// secret, so a copy of the table alone cannot be used to act as its users.
+type Store struct {
+	db   *sql.DB
+	aead cipher.AEAD
+}
+
+func NewStore(db *sql.DB, secret string) *Store {
+	key := sha256.Sum256([]byte(secret))
+	// Neither call can fail for a 32-byte AES key.
+	block, _ := aes.NewCipher(key[:])
+	aead, _ := cipher.NewGCM(block)
+
+	return &Store{db: db, aead: aead}
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+const sessionColumns = `id, user_id, access_token, refresh_token, access_expires_at, expires_at, last_used_at, created_at`
+
+func (s *Store) scanSession(row rowScanner) (*types.BFFSession, error) {
+	var (
+		session                   types.BFFSession
+		accessToken, refreshToken string
+	)
+	err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&accessToken,
+		&refreshToken,
+		&session.AccessExpiresAt,
+		&session.ExpiresAt,
+		&session.LastUsedAt,
+		&session.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.AccessToken, err = s.open(accessToken); err != nil {
+		return nil, err
+	}
+	if session.RefreshToken, err = s.open(refreshToken); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (s *Store) CreateBFFSession(session types.BFFSession, sessionHash string) (int, error) {
+	accessToken, err := s.seal(session.AccessToken)
+	if err != nil {
+		return 0, err
+	}
+	refreshToken, err := s.seal(session.RefreshToken)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	err = s.db.QueryRow(
+		`INSERT INTO bff_sessions (session_hash, user_id, access_token, refresh_token, access_expires_at, expires_at)
+         VALUES ($1, $2, $3, $4, $5, $6)
+         RETURNING id`,
+		sessionHash,
+		session.UserID,
+		accessToken,
+		refreshToken,
+		session.AccessExpiresAt,
+		session.ExpiresAt,
+	).Scan(&id)
+	return id, err
+}
+
+func (s *Store) GetBFFSessionByHash(sessionHash string) (*types.BFFSession, error) {
+	row := s.db.QueryRow(
+		`SELECT `+sessionColumns+`
+           FROM bff_sessions
+          WHERE session_hash = $1
+            AND expires_at > NOW()`,
+		sessionHash,
+	)
+
+	return s.scanSession(row)
+}
+
+// UpdateBFFSessionLocked holds the session's row lock while update runs and
+// saves the tokens and expiry it leaves behind. Concurrent refreshes of the
+// same session therefore run one after another, and each sees the tokens
+// the previous one stored. Nothing is saved when update fails.
+func (s *Store) UpdateBFFSessionLocked(id int, update func(session *types.BFFSession) error) (*types.BFFSession, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	session, err := s.scanSession(tx.QueryRow(
+		`SELECT `+sessionColumns+`
+           FROM bff_sessions
+          WHERE id = $1
+            AND expires_at > NOW()
+            FOR UPDATE`,
+		id,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := update(session); err != nil {
+		return nil, err
+	}
+
+	accessToken, err := s.seal(session.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := s.seal(session.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(
+		`UPDATE bff_sessions
+            SET access_token = $2,
+                refresh_token = $3,
+                access_expires_at = $4,
+                expires_at = $5,
+                last_used_at = NOW()
+          WHERE id = $1
+          RETURNING last_used_at`,
+		id,
+		accessToken,
+		refreshToken,
+		session.AccessExpiresAt,
+		session.ExpiresAt,
+	).Scan(&session.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func (s *Store) TouchBFFSession(id int, expiresAt time.Time) error {
+	_, err := s.db.Exec(
+		`UPDATE bff_sessions
+            SET expires_at = $2,
+                last_used_at = NOW()
+          WHERE id = $1`,
+		id,
+		expiresAt,
+	)
+	return err
+}
+
+func (s *Store) DeleteBFFSession(id int) error {
+	_, err := s.db.Exec(`DELETE FROM bff_sessions WHERE id = $1`, id)
+	return err
+}
+
+func (s *Store) DeleteExpiredBFFSessions() (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM bff_sessions WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *Store) seal(plain string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := s.aead.Seal(nonce, nonce, []byte(plain), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *Store) open(sealed string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < s.aead.NonceSize() {
+		return "", errors.New("malformed bff session token")
+	}
+
+	nonce, ciphertext := raw[:s.aead.NonceSize()], raw[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.New("bff session token cannot be decrypted; was BFF_SESSION_KEY changed?")
+	}
+
+	return string(plain), nil
+}
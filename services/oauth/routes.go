@@ -0,0 +1,202 @@
+package oauth
+
+import (
+	"auth-api/configs"
+	"auth-api/services/audit"
+	"auth-api/types"
+	"auth-api/utils"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+type Handler struct {
+	audit types.AuditStore
+}
+
+func NewHandler(audit types.AuditStore) *Handler {
+	return &Handler{audit: audit}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.Handle("/oauth/token", utils.RateLimit(60, 1*time.Minute)(http.HandlerFunc(h.handleToken))).Methods("POST")
+}
+
+// handleToken is the OAuth 2.0 token endpoint. Parameters are form encoded
+// (RFC 6749 section 3.2) and only the token exchange grant is supported;
+// logins keep using /login.
+func (h *Handler) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+
+	switch grantType := r.PostForm.Get("grant_type"); grantType {
+	case GrantTypeTokenExchange:
+		h.handleTokenExchange(w, r)
+	case "":
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "grant_type is required")
+	default:
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "grant_type "+grantType+" is not supported")
+	}
+}
+
+// handleTokenExchange implements RFC 8693. The subject token is narrowed to
+// the requested scope and audience; when an actor token is given its
+// subject becomes the act claim, with earlier actors nested beneath it.
+func (h *Handler) handleTokenExchange(w http.ResponseWriter, r *http.Request) {
+	form := r.PostForm
+
+	if typ := form.Get("requested_token_type"); typ != "" && typ != TokenTypeAccessToken && typ != TokenTypeJWT {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "unsupported requested_token_type")
+		return
+	}
+
+	subjectToken := form.Get("subject_token")
+	if subjectToken == "" || form.Get("subject_token_type") == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "subject_token and subject_token_type are required")
+		return
+	}
+	if !supportedTokenType(form.Get("subject_token_type")) {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "unsupported subject_token_type")
+		return
+	}
+
+	subject, _, err := utils.ValidateAccessToken(subjectToken)
+	if err != nil {
+		writeTokenError(w, "subject_token", err)
+		return
+	}
+	if subject.PasswordChangeRequired {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "subject must change their password first")
+		return
+	}
+
+	act := subject.Act
+	if actorToken := form.Get("actor_token"); actorToken != "" {
+		if !supportedTokenType(form.Get("actor_token_type")) {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_request", "actor_token_type is missing or unsupported")
+			return
+		}
+
+		actor, _, err := utils.ValidateAccessToken(actorToken)
+		if err != nil {
+			writeTokenError(w, "actor_token", err)
+			return
+		}
+		// Only the services listed in TOKEN_EXCHANGE_ACTORS may act on
+		// behalf of others, and only as themselves.
+		if actor.Act != nil || !slices.Contains(parseList(configs.Envs.TokenExchangeActors), actor.Subject) {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "actor_token: actor is not allowed to act on behalf of others")
+			return
+		}
+		act = &utils.ActorClaim{Subject: actor.Subject, Act: subject.Act}
+	} else if form.Get("actor_token_type") != "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "actor_token_type given without actor_token")
+		return
+	}
+
+	audience := slices.Concat(form["audience"], form["resource"])
+	if len(audience) == 0 {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "audience or resource is required")
+		return
+	}
+	allowed := parseList(configs.Envs.TokenExchangeAudiences)
+	for _, aud := range audience {
+		if !slices.Contains(allowed, aud) {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_target", "audience "+aud+" is not allowed")
+			return
+		}
+	}
+	slices.Sort(audience)
+	audience = slices.Compact(audience)
+
+	// Without a scope parameter the token keeps the subject's scopes; it can
+	// only ever lose permissions in the exchange.
+	scopes := subject.Scopes()
+	if form.Has("scope") {
+		requested := strings.Fields(form.Get("scope"))
+		for _, scope := range requested {
+			if !subject.HasScope(scope) {
+				writeOAuthError(w, http.StatusBadRequest, "invalid_scope", "scope "+scope+" exceeds the subject token")
+				return
+			}
+		}
+		slices.Sort(requested)
+		scopes = slices.Compact(requested)
+	}
+
+	// The new token never outlives the one it was exchanged from.
+	expiresAt := time.Now().UTC().Add(configs.Envs.TokenExchangeTTL)
+	if subject.ExpiresAt != nil && subject.ExpiresAt.Before(expiresAt) {
+		expiresAt = subject.ExpiresAt.Time
+	}
+
+	accessToken, err := utils.GenerateExchangedToken(subject, act, audience, scopes, expiresAt)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to issue token")
+		return
+	}
+
+	metadata := map[string]any{
+		"audience": audience,
+		"scopes":   scopes,
+	}
+	if act != nil {
+		metadata["actor"] = act
+	}
+	audit.RecordRequest(h.audit, r, "token.exchange", "user", subject.Subject, metadata)
+
+	w.Header().Set("Cache-Control", "no-store")
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"access_token":      accessToken,
+		"issued_token_type": TokenTypeAccessToken,
+		"token_type":        "Bearer",
+		"expires_in":        int(time.Until(expiresAt).Seconds()),
+		"scope":             strings.Join(scopes, " "),
+	})
+}
+
+func supportedTokenType(typ string) bool {
+	return typ == TokenTypeAccessToken || typ == TokenTypeJWT
+}
+
+// writeTokenError reports a rejected subject or actor token as
+// invalid_grant, and anything else as a server error.
+func writeTokenError(w http.ResponseWriter, param string, err error) {
+	if utils.IsAccessTokenError(err) {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", param+": "+err.Error())
+		return
+	}
+	writeOAuthError(w, http.StatusInternalServerError, "server_error", err.Error())
+}
+
+// writeOAuthError uses the error response format of RFC 6749 section 5.2
+// rather than utils.WriteError, since OAuth clients expect it.
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Cache-Control", "no-store")
+	utils.WriteJSON(w, status, map[string]any{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+func parseList(raw string) []string {
+	out := []string{}
+	for _, part := range strings.Split(raw, ",") {
+		if v := strings.TrimSpace(part); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,430 @@
+package organization
+
+import (
+	"auth-api/types"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrSlugTaken     = errors.New("organization slug already taken")
+	ErrAlreadyMember = errors.New("user is already a member")
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// CreateOrganization creates the organization with ownerID as its first
+// owner.
+func (s *Store) CreateOrganization(org types.Organization, ownerID int) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(
+		`INSERT INTO organizations (slug, name)
+         VALUES ($1, $2)
+         RETURNING id`,
+		org.Slug,
+		org.Name,
+	).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, ErrSlugTaken
+		}
+		return 0, err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO memberships (org_id, user_id, role)
+         VALUES ($1, $2, $3)`,
+		id,
+		ownerID,
+		types.OrgRoleOwner,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (s *Store) GetOrganizationByID(id int) (*types.Organization, error) {
+	var org types.Organization
+	err := s.db.QueryRow(
+		`SELECT id, slug, name, created_at
+           FROM organizations
+          WHERE id = $1`,
+		id,
+	).Scan(&org.ID, &org.Slug, &org.Name, &org.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+func (s *Store) ListOrganizations() ([]types.Organization, error) {
+	rows, err := s.db.Query(
+		`SELECT id, slug, name, created_at
+           FROM organizations
+          ORDER BY id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []types.Organization{}
+	for rows.Next() {
+		var org types.Organization
+		if err := rows.Scan(&org.ID, &org.Slug, &org.Name, &org.CreatedAt); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orgs, nil
+}
+
+func (s *Store) DeleteOrganization(id int) error {
+	res, err := s.db.Exec(`DELETE FROM organizations WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+const membershipSelect = `SELECT m.org_id, o.slug, o.name, m.user_id, u.username, u.email, m.role, m.created_at
+           FROM memberships m
+           JOIN organizations o ON o.id = m.org_id
+           JOIN users u ON u.id = m.user_id`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMembership(row rowScanner) (*types.Membership, error) {
+	var m types.Membership
+	err := row.Scan(
+		&m.OrganizationID,
+		&m.OrganizationSlug,
+		&m.OrganizationName,
+		&m.UserID,
+		&m.Username,
+		&m.Email,
+		&m.Role,
+		&m.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+func (s *Store) queryMemberships(query string, args ...any) ([]types.Membership, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []types.Membership{}
+	for rows.Next() {
+		m, err := scanMembership(rows)
+		if err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, *m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return memberships, nil
+}
+
+func (s *Store) ListMemberships(userID int) ([]types.Membership, error) {
+	return s.queryMemberships(membershipSelect+`
+          WHERE m.user_id = $1
+          ORDER BY o.name`, userID)
+}
+
+func (s *Store) ListMembers(orgID int) ([]types.Membership, error) {
+	return s.queryMemberships(membershipSelect+`
+          WHERE m.org_id = $1
+          ORDER BY m.created_at, m.user_id`, orgID)
+}
+
+func (s *Store) GetMembership(orgID, userID int) (*types.Membership, error) {
+	row := s.db.QueryRow(membershipSelect+`
+          WHERE m.org_id = $1 AND m.user_id = $2`, orgID, userID)
+
+	return scanMembership(row)
+}
+
+func (s *Store) AddMember(orgID, userID int, role string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO memberships (org_id, user_id, role)
+         VALUES ($1, $2, $3)`,
+		orgID,
+		userID,
+		role,
+	)
+	if isUniqueViolation(err) {
+		return ErrAlreadyMember
+	}
+	return err
+}
+
+func (s *Store) UpdateMemberRole(orgID, userID int, role string) error {
+	res, err := s.db.Exec(
+		`UPDATE memberships
+            SET role = $3
+          WHERE org_id = $1 AND user_id = $2`,
+		orgID,
+		userID,
+		role,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// RemoveMember also clears the organization from the user's active
+// selection so their next token has no tenant.
+func (s *Store) RemoveMember(orgID, userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM memberships WHERE org_id = $1 AND user_id = $2`, orgID, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.Exec(
+		`UPDATE users
+            SET active_org_id = NULL
+          WHERE id = $1 AND active_org_id = $2`,
+		userID,
+		orgID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) CountOwners(orgID int) (int, error) {
+	var n int
+	err := s.db.QueryRow(
+		`SELECT COUNT(*) FROM memberships WHERE org_id = $1 AND role = $2`,
+		orgID,
+		types.OrgRoleOwner,
+	).Scan(&n)
+	return n, err
+}
+
+func (s *Store) SetActiveOrganization(userID int, orgID *int) error {
+	_, err := s.db.Exec(`UPDATE users SET active_org_id = $2 WHERE id = $1`, userID, orgID)
+	return err
+}
+
+const invitationColumns = `id, org_id, email, role, invited_by, expires_at, accepted_at, created_at`
+
+func scanInvitation(row rowScanner) (*types.OrganizationInvitation, error) {
+	var inv types.OrganizationInvitation
+	err := row.Scan(
+		&inv.ID,
+		&inv.OrganizationID,
+		&inv.Email,
+		&inv.Role,
+		&inv.InvitedBy,
+		&inv.ExpiresAt,
+		&inv.AcceptedAt,
+		&inv.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &inv, nil
+}
+
+func (s *Store) CreateInvitation(inv types.OrganizationInvitation, tokenHash string) (int, error) {
+	var id int
+	err := s.db.QueryRow(
+		`INSERT INTO organization_invitations (org_id, email, role, token_hash, invited_by, expires_at)
+         VALUES ($1, $2, $3, $4, $5, $6)
+         RETURNING id`,
+		inv.OrganizationID,
+		inv.Email,
+		inv.Role,
+		tokenHash,
+		inv.InvitedBy,
+		inv.ExpiresAt,
+	).Scan(&id)
+	return id, err
+}
+
+// ListInvitations returns invitations that are still waiting for an answer.
+func (s *Store) ListInvitations(orgID int) ([]types.OrganizationInvitation, error) {
+	rows, err := s.db.Query(
+		`SELECT `+invitationColumns+`
+           FROM organization_invitations
+          WHERE org_id = $1 AND accepted_at IS NULL AND expires_at > NOW()
+          ORDER BY id DESC`,
+		orgID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []types.OrganizationInvitation{}
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, *inv)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// GetInvitationByToken only finds pending, unexpired invitations.
+func (s *Store) GetInvitationByToken(tokenHash string) (*types.OrganizationInvitation, error) {
+	row := s.db.QueryRow(
+		`SELECT `+invitationColumns+`
+           FROM organization_invitations
+          WHERE token_hash = $1 AND accepted_at IS NULL AND expires_at > NOW()`,
+		tokenHash,
+	)
+
+	return scanInvitation(row)
+}
+
+// AcceptInvitation marks the invitation used and adds the membership. An
+// existing membership keeps its current role.
+func (s *Store) AcceptInvitation(inv *types.OrganizationInvitation, userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`UPDATE organization_invitations
+            SET accepted_at = NOW()
+          WHERE id = $1 AND accepted_at IS NULL`,
+		inv.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO memberships (org_id, user_id, role)
+         VALUES ($1, $2, $3)
+         ON CONFLICT (org_id, user_id) DO NOTHING`,
+		inv.OrganizationID,
+		userID,
+		inv.Role,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) DeleteInvitation(orgID, id int) error {
+	res, err := s.db.Exec(
+		`DELETE FROM organization_invitations
+          WHERE id = $1 AND org_id = $2 AND accepted_at IS NULL`,
+		id,
+		orgID,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
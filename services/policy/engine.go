@@ -0,0 +1,260 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+type Effect string
+
+const (
+	EffectAllow         Effect = "allow"
+	EffectDeny          Effect = "deny"
+	EffectNotApplicable Effect = "not_applicable"
+)
+
+// Policy grants or denies the listed actions when its condition holds. An
+// empty condition always holds. Actions may be exact ("users:read"), a
+// namespace wildcard ("users:*") or "*".
+type Policy struct {
+	ID          string   `json:"id"`
+	Description string   `json:"description,omitempty"`
+	Effect      Effect   `json:"effect"`
+	Actions     []string `json:"actions"`
+	Condition   string   `json:"condition,omitempty"`
+	Source      string   `json:"source,omitempty"`
+
+	expr node
+}
+
+// policyFile is the on-disk format of a *.json file in POLICY_DIR.
+type policyFile struct {
+	Policies []Policy `json:"policies"`
+}
+
+// Input is what a condition is evaluated against. Subject, Resource and
+// Request are exposed to conditions under the same names.
+type Input struct {
+	Action   string         `json:"action"`
+	Subject  map[string]any `json:"subject"`
+	Resource map[string]any `json:"resource"`
+	Request  map[string]any `json:"request"`
+}
+
+type Trace struct {
+	Policy  string `json:"policy"`
+	Effect  Effect `json:"effect"`
+	Matched bool   `json:"matched"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Decision is deny-overrides: any matching deny policy wins, then any
+// matching allow policy; otherwise no policy applies and the caller falls
+// back to RBAC. Trace lists every policy that targeted the action.
+type Decision struct {
+	Action string  `json:"action"`
+	Effect Effect  `json:"effect"`
+	Policy string  `json:"policy,omitempty"`
+	Trace  []Trace `json:"trace"`
+}
+
+type Engine struct {
+	policies []Policy
+	dryRun   bool
+}
+
+// NewEngine wraps compiled policies. In dry-run mode decisions are only
+// logged and RBAC alone decides access.
+func NewEngine(policies []Policy, dryRun bool) *Engine {
+	return &Engine{policies: policies, dryRun: dryRun}
+}
+
+func (e *Engine) Policies() []Policy {
+	if e == nil {
+		return nil
+	}
+	return e.policies
+}
+
+func (e *Engine) DryRun() bool {
+	return e != nil && e.dryRun
+}
+
+// Applies reports whether any policy targets action, so callers can skip
+// building an Input when it would not be used.
+func (e *Engine) Applies(action string) bool {
+	for _, p := range e.Policies() {
+		if p.targets(action) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Engine) Evaluate(in Input) Decision {
+	return Evaluate(e.Policies(), in)
+}
+
+// LoadDir reads and compiles every *.json file in dir. An empty dir means
+// no policies.
+func LoadDir(dir string) ([]Policy, error) {
+	if dir == "" {
+		return nil, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(files)
+
+	var policies []Policy
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var pf policyFile
+		if err := json.Unmarshal(data, &pf); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+
+		for i := range pf.Policies {
+			pf.Policies[i].Source = filepath.Base(file)
+		}
+		policies = append(policies, pf.Policies...)
+	}
+
+	return Compile(policies)
+}
+
+// Compile validates policies and parses their conditions.
+func Compile(policies []Policy) ([]Policy, error) {
+	compiled := make([]Policy, 0, len(policies))
+	seen := make(map[string]bool, len(policies))
+
+	for _, p := range policies {
+		if p.ID == "" {
+			return nil, fmt.Errorf("policy in %s has no id", sourceName(p))
+		}
+		if seen[p.ID] {
+			return nil, fmt.Errorf("duplicate policy id %q", p.ID)
+		}
+		seen[p.ID] = true
+
+		if p.Effect != EffectAllow && p.Effect != EffectDeny {
+			return nil, fmt.Errorf("policy %q: effect must be allow or deny", p.ID)
+		}
+		if len(p.Actions) == 0 {
+			return nil, fmt.Errorf("policy %q: actions are required", p.ID)
+		}
+
+		if strings.TrimSpace(p.Condition) != "" {
+			expr, err := compile(p.Condition)
+			if err != nil {
+				return nil, fmt.Errorf("policy %q: %w", p.ID, err)
+			}
+			p.expr = expr
+		}
+
+		compiled = append(compiled, p)
+	}
+
+	return compiled, nil
+}
+
+// Evaluate runs every policy targeting in.Action. A condition that fails
+// to evaluate counts as matched for deny policies and unmatched for allow
+// policies, so broken rules fail closed.
+func Evaluate(policies []Policy, in Input) Decision {
+	d := Decision{Action: in.Action, Effect: EffectNotApplicable, Trace: []Trace{}}
+
+	env, err := newEnv(in)
+	if err != nil {
+		d.Effect = EffectDeny
+		d.Trace = append(d.Trace, Trace{Effect: EffectDeny, Matched: true, Error: err.Error()})
+		return d
+	}
+
+	for _, p := range policies {
+		if !p.targets(in.Action) {
+			continue
+		}
+
+		t := Trace{Policy: p.ID, Effect: p.Effect}
+		matched, err := p.matches(env)
+		if err != nil {
+			t.Error = err.Error()
+			matched = p.Effect == EffectDeny
+		}
+		t.Matched = matched
+		d.Trace = append(d.Trace, t)
+
+		if !matched || d.Effect == EffectDeny {
+			continue
+		}
+		if p.Effect == EffectDeny || d.Effect == EffectNotApplicable {
+			d.Effect = p.Effect
+			d.Policy = p.ID
+		}
+	}
+
+	return d
+}
+
+func (p Policy) targets(action string) bool {
+	for _, a := range p.Actions {
+		if a == "*" || a == action {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(a, "*"); ok && strings.HasPrefix(action, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Policy) matches(env map[string]any) (bool, error) {
+	if p.expr == nil {
+		return true, nil
+	}
+
+	v, err := p.expr.eval(env)
+	if err != nil {
+		return false, err
+	}
+
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("condition evaluated to %s, not bool", typeName(v))
+	}
+	return b, nil
+}
+
+// newEnv round-trips the input through JSON so conditions only ever see
+// the plain types the evaluator understands (float64, string, bool, nil,
+// []any and map[string]any).
+func newEnv(in Input) (map[string]any, error) {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	var env map[string]any
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func sourceName(p Policy) string {
+	if p.Source == "" {
+		return "request"
+	}
+	return p.Source
+}
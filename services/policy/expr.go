@@ -0,0 +1,680 @@
+package policy
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The condition language is a small subset of CEL: literals (numbers,
+// strings, true, false, null, lists), member access and indexing, the
+// operators ! - * / % + == != < <= > >= in && ||, the global size()
+// function and the contains/startsWith/endsWith methods. Missing map keys
+// evaluate to null instead of failing, so `resource.region == null` can be
+// used where CEL would need has().
+
+// Roots are the identifiers a condition may start from.
+var roots = []string{"subject", "resource", "request", "action"}
+
+type node interface {
+	eval(env map[string]any) (any, error)
+}
+
+// compile parses a condition into an evaluable expression.
+func compile(src string) (node, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+	}
+	return n, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", ",", "."}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(src) && (src[i] == '_' || unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, src[start:i], start})
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(src) && (unicode.IsDigit(rune(src[i])) || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, src[start:i], start})
+		case c == '"' || c == '\'':
+			start := i
+			i++
+			for i < len(src) && src[i] != byte(c) {
+				if src[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("unterminated string at offset %d", start)
+			}
+			i++
+			text := src[start:i]
+			if c == '\'' {
+				text = `"` + strings.ReplaceAll(text[1:len(text)-1], `"`, `\"`) + `"`
+			}
+			s, err := strconv.Unquote(text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at offset %d", start)
+			}
+			tokens = append(tokens, token{tokString, s, start})
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, token{tokOp, op, i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) accept(ops ...string) (string, bool) {
+	tok := p.peek()
+	if tok.kind == tokOp || (tok.kind == tokIdent && tok.text == "in") {
+		for _, op := range ops {
+			if tok.text == op {
+				p.pos++
+				return op, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		tok := p.peek()
+		return fmt.Errorf("expected %q at offset %d", op, tok.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseBinary(next func() (node, error), ops ...string) (node, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, ok := p.accept(ops...)
+		if !ok {
+			return left, nil
+		}
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseOr() (node, error) {
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.parseBinary(p.parseRelation, "&&")
+}
+
+func (p *parser) parseRelation() (node, error) {
+	return p.parseBinary(p.parseAdditive, "==", "!=", "<=", ">=", "<", ">", "in")
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	return p.parseBinary(p.parseMultiplicative, "+", "-")
+}
+
+func (p *parser) parseMultiplicative() (node, error) {
+	return p.parseBinary(p.parseUnary, "*", "/", "%")
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if op, ok := p.accept("!", "-"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if _, ok := p.accept("."); ok {
+			tok := p.next()
+			if tok.kind != tokIdent {
+				return nil, fmt.Errorf("expected field name at offset %d", tok.pos)
+			}
+			if _, ok := p.accept("("); ok {
+				args, err := p.parseArgs(")")
+				if err != nil {
+					return nil, err
+				}
+				if _, ok := methods[tok.text]; !ok {
+					return nil, fmt.Errorf("unknown method %q", tok.text)
+				}
+				n = &callNode{name: tok.text, receiver: n, args: args}
+				continue
+			}
+			n = &memberNode{object: n, name: tok.text}
+			continue
+		}
+
+		if _, ok := p.accept("["); ok {
+			index, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = &indexNode{object: n, index: index}
+			continue
+		}
+
+		return n, nil
+	}
+}
+
+func (p *parser) parseArgs(close string) ([]node, error) {
+	var args []node
+	if _, ok := p.accept(close); ok {
+		return args, nil
+	}
+
+	for {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		if _, ok := p.accept(","); ok {
+			continue
+		}
+		if err := p.expect(close); err != nil {
+			return nil, err
+		}
+		return args, nil
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at offset %d", tok.text, tok.pos)
+		}
+		return &literalNode{value: f}, nil
+	case tokString:
+		return &literalNode{value: tok.text}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		case "size":
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+			args, err := p.parseArgs(")")
+			if err != nil {
+				return nil, err
+			}
+			if len(args) != 1 {
+				return nil, fmt.Errorf("size expects 1 argument")
+			}
+			return &callNode{name: "size", args: args}, nil
+		}
+		for _, root := range roots {
+			if tok.text == root {
+				return &identNode{name: tok.text}, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown identifier %q at offset %d", tok.text, tok.pos)
+	case tokOp:
+		switch tok.text {
+		case "(":
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return n, nil
+		case "[":
+			elems, err := p.parseArgs("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listNode{elems: elems}, nil
+		}
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+}
+
+type literalNode struct {
+	value any
+}
+
+func (n *literalNode) eval(env map[string]any) (any, error) {
+	return n.value, nil
+}
+
+type identNode struct {
+	name string
+}
+
+func (n *identNode) eval(env map[string]any) (any, error) {
+	return env[n.name], nil
+}
+
+type listNode struct {
+	elems []node
+}
+
+func (n *listNode) eval(env map[string]any) (any, error) {
+	list := make([]any, 0, len(n.elems))
+	for _, elem := range n.elems {
+		v, err := elem.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+type memberNode struct {
+	object node
+	name   string
+}
+
+func (n *memberNode) eval(env map[string]any) (any, error) {
+	obj, err := n.object.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch o := obj.(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		return o[n.name], nil
+	default:
+		return nil, fmt.Errorf("cannot read field %q of %s", n.name, typeName(obj))
+	}
+}
+
+type indexNode struct {
+	object node
+	index  node
+}
+
+func (n *indexNode) eval(env map[string]any) (any, error) {
+	obj, err := n.object.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := n.index.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch o := obj.(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		key, ok := idx.(string)
+		if !ok {
+			return nil, fmt.Errorf("map index must be a string, got %s", typeName(idx))
+		}
+		return o[key], nil
+	case []any:
+		f, ok := idx.(float64)
+		if !ok || f != float64(int(f)) {
+			return nil, fmt.Errorf("list index must be an integer, got %s", typeName(idx))
+		}
+		if i := int(f); i >= 0 && i < len(o) {
+			return o[i], nil
+		}
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("cannot index %s", typeName(obj))
+	}
+}
+
+var methods = map[string]func(recv any, args []any) (any, error){
+	"contains": func(recv any, args []any) (any, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("contains expects 1 argument")
+		}
+		switch r := recv.(type) {
+		case string:
+			s, ok := args[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("string.contains expects a string")
+			}
+			return strings.Contains(r, s), nil
+		case []any:
+			return listContains(r, args[0]), nil
+		case nil:
+			return false, nil
+		}
+		return nil, fmt.Errorf("contains is not defined on %s", typeName(recv))
+	},
+	"startsWith": stringMethod("startsWith", strings.HasPrefix),
+	"endsWith":   stringMethod("endsWith", strings.HasSuffix),
+}
+
+func stringMethod(name string, fn func(s, arg string) bool) func(any, []any) (any, error) {
+	return func(recv any, args []any) (any, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s expects 1 argument", name)
+		}
+		s, ok := recv.(string)
+		arg, argOK := args[0].(string)
+		if !ok || !argOK {
+			return nil, fmt.Errorf("%s is only defined on strings", name)
+		}
+		return fn(s, arg), nil
+	}
+}
+
+type callNode struct {
+	name     string
+	receiver node
+	args     []node
+}
+
+func (n *callNode) eval(env map[string]any) (any, error) {
+	args := make([]any, 0, len(n.args))
+	for _, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+
+	if n.receiver == nil {
+		switch v := args[0].(type) {
+		case string:
+			return float64(len([]rune(v))), nil
+		case []any:
+			return float64(len(v)), nil
+		case map[string]any:
+			return float64(len(v)), nil
+		case nil:
+			return float64(0), nil
+		}
+		return nil, fmt.Errorf("size is not defined on %s", typeName(args[0]))
+	}
+
+	recv, err := n.receiver.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return methods[n.name](recv, args)
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+func (n *unaryNode) eval(env map[string]any) (any, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "!":
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator ! expects bool, got %s", typeName(v))
+		}
+		return !b, nil
+	default:
+		f, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("operator - expects number, got %s", typeName(v))
+		}
+		return -f, nil
+	}
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+func (n *binaryNode) eval(env map[string]any) (any, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.op == "&&" || n.op == "||" {
+		l, ok := left.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator %s expects bool, got %s", n.op, typeName(left))
+		}
+		if (n.op == "&&" && !l) || (n.op == "||" && l) {
+			return l, nil
+		}
+		right, err := n.right.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := right.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator %s expects bool, got %s", n.op, typeName(right))
+		}
+		return r, nil
+	}
+
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return reflect.DeepEqual(left, right), nil
+	case "!=":
+		return !reflect.DeepEqual(left, right), nil
+	case "in":
+		switch r := right.(type) {
+		case []any:
+			return listContains(r, left), nil
+		case map[string]any:
+			key, ok := left.(string)
+			if !ok {
+				return false, nil
+			}
+			_, exists := r[key]
+			return exists, nil
+		case nil:
+			return false, nil
+		}
+		return nil, fmt.Errorf("operator in expects list or map, got %s", typeName(right))
+	case "+":
+		switch l := left.(type) {
+		case string:
+			if r, ok := right.(string); ok {
+				return l + r, nil
+			}
+		case []any:
+			if r, ok := right.([]any); ok {
+				return append(append([]any{}, l...), r...), nil
+			}
+		}
+	}
+
+	if l, ok := left.(string); ok {
+		if r, ok := right.(string); ok {
+			return compare(n.op, strings.Compare(l, r))
+		}
+	}
+
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("operator %s is not defined on %s and %s", n.op, typeName(left), typeName(right))
+	}
+
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	case "%":
+		if int(r) == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return float64(int(l) % int(r)), nil
+	}
+
+	switch {
+	case l < r:
+		return compare(n.op, -1)
+	case l > r:
+		return compare(n.op, 1)
+	default:
+		return compare(n.op, 0)
+	}
+}
+
+func compare(op string, cmp int) (any, error) {
+	switch op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+	return nil, fmt.Errorf("operator %s is not defined on strings", op)
+}
+
+func listContains(list []any, v any) bool {
+	for _, item := range list {
+		if reflect.DeepEqual(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "map"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,225 @@
+package policy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testEnv = map[string]any{
+	"action": "users:read",
+	"subject": map[string]any{
+		"id":         float64(7),
+		"roles":      []any{"support", "auditor"},
+		"attributes": map[string]any{"region": "eu"},
+	},
+	"resource": map[string]any{
+		"id":         float64(9),
+		"status":     "active",
+		"attributes": map[string]any{"region": "eu", "tags": []any{"vip"}},
+	},
+	"request": nil,
+}
+
+func TestExprEval(t *testing.T) {
+	tests := []struct {
+		src  string
+		want any
+	}{
+		// Precedence: * before +, + before comparison, && before ||.
+		{"1 + 2 * 3", float64(7)},
+		{"(1 + 2) * 3", float64(9)},
+		{"10 - 4 - 3", float64(3)},
+		{"7 % 4 * 2", float64(6)},
+		{"-2 * 3", float64(-6)},
+		{"1 + 1 == 2", true},
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"!false && true", true},
+		{"!(1 < 2)", false},
+		{"1 < 2 == true", true},
+
+		// in
+		{`"support" in subject.roles`, true},
+		{`"admin" in subject.roles`, false},
+		{`"region" in resource.attributes`, true},
+		{`"missing" in resource.attributes`, false},
+		{`1 in resource.attributes`, false},
+		{`"x" in resource.attributes.missing`, false},
+		{`2 in [1, 2, 3]`, true},
+		{`"vip" in resource.attributes.tags`, true},
+
+		// Member access and indexing through null yields null.
+		{"resource.attributes.missing", nil},
+		{"resource.attributes.missing.deeper", nil},
+		{"request.ip", nil},
+		{`resource.attributes["region"]`, "eu"},
+		{"subject.roles[1]", "auditor"},
+		{"subject.roles[5]", nil},
+		{"resource.attributes.missing == null", true},
+		{"resource.attributes.region == subject.attributes.region", true},
+
+		// Strings, lists and functions.
+		{`"ab" + 'cd'`, "abcd"},
+		{`"a" < "b"`, true},
+		{`[1] + [2]`, []any{float64(1), float64(2)}},
+		{`size("héllo")`, float64(5)},
+		{"size(subject.roles)", float64(2)},
+		{"size(resource.missing)", float64(0)},
+		{`resource.status.startsWith("act")`, true},
+		{`resource.status.endsWith("ive")`, true},
+		{`subject.roles.contains("auditor")`, true},
+		{`resource.missing.contains("x")`, false},
+
+		// && and || short-circuit, so the right side may not even type-check.
+		{"false && 1", false},
+		{"true || 1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			n, err := compile(tt.src)
+			if err != nil {
+				t.Fatalf("compile: %v", err)
+			}
+			got, err := n.eval(testEnv)
+			if err != nil {
+				t.Fatalf("eval: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExprEvalErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"1 + true", "not defined on number and bool"},
+		{`"a" - "b"`, "not defined on strings"},
+		{"!1", "expects bool"},
+		{`-"a"`, "expects number"},
+		{"1 && true", "expects bool"},
+		{"true && 1", "expects bool"},
+		{"1 in 2", "expects list or map"},
+		{"1 / 0", "division by zero"},
+		{"5 % 0", "division by zero"},
+		{"subject.id.name", "cannot read field"},
+		{"subject.roles[\"a\"]", "list index must be an integer"},
+		{"subject.roles[0.5]", "list index must be an integer"},
+		{"resource.attributes[1]", "map index must be a string"},
+		{"size(true)", "size is not defined on bool"},
+		{`resource.id.startsWith("a")`, "only defined on strings"},
+		{"subject.id.contains(1)", "contains is not defined on number"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			n, err := compile(tt.src)
+			if err != nil {
+				t.Fatalf("compile: %v", err)
+			}
+			_, err = n.eval(testEnv)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExprCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"1 +",
+		"(1 + 2",
+		"[1, 2",
+		`"unterminated`,
+		"user.id",
+		"subject.",
+		"subject.roles.reverse()",
+		"size(1, 2)",
+		"1 2",
+		"a = b",
+		"subject @ 1",
+	} {
+		if _, err := compile(src); err == nil {
+			t.Errorf("compile(%q) succeeded, want error", src)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	policies, err := Compile([]Policy{
+		{ID: "allow-support", Effect: EffectAllow, Actions: []string{"users:read"}, Condition: `"support" in subject.roles`},
+		{ID: "deny-self", Effect: EffectDeny, Actions: []string{"users:delete"}, Condition: "resource.id == subject.id"},
+		{ID: "allow-broken", Effect: EffectAllow, Actions: []string{"users:update"}, Condition: "subject.id + true"},
+		{ID: "deny-broken", Effect: EffectDeny, Actions: []string{"users:export"}, Condition: "subject.id.name"},
+		{ID: "allow-not-bool", Effect: EffectAllow, Actions: []string{"users:list"}, Condition: "subject.id"},
+		{ID: "allow-users", Effect: EffectAllow, Actions: []string{"users:*"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject := map[string]any{"id": 7, "roles": []string{"support"}}
+	tests := []struct {
+		name     string
+		action   string
+		resource map[string]any
+		want     Effect
+		policy   string
+	}{
+		{"allow matches", "users:read", nil, EffectAllow, "allow-support"},
+		{"deny overrides allow", "users:delete", map[string]any{"id": 7}, EffectDeny, "deny-self"},
+		{"unmatched deny falls through", "users:delete", map[string]any{"id": 8}, EffectAllow, "allow-users"},
+		{"broken allow does not grant", "users:update", nil, EffectAllow, "allow-users"},
+		{"broken deny fails closed", "users:export", nil, EffectDeny, "deny-broken"},
+		{"non-bool condition does not grant", "users:list", nil, EffectAllow, "allow-users"},
+		{"no policy targets action", "roles:manage", nil, EffectNotApplicable, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Evaluate(policies, Input{Action: tt.action, Subject: subject, Resource: tt.resource})
+			if d.Effect != tt.want || d.Policy != tt.policy {
+				t.Errorf("got %s by %q, want %s by %q", d.Effect, d.Policy, tt.want, tt.policy)
+			}
+		})
+	}
+}
+
+func TestEvaluateBrokenAllowAlone(t *testing.T) {
+	policies, err := Compile([]Policy{
+		{ID: "allow-broken", Effect: EffectAllow, Actions: []string{"users:read"}, Condition: "subject.missing.x < 1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := Evaluate(policies, Input{Action: "users:read", Subject: map[string]any{}})
+	if d.Effect != EffectNotApplicable {
+		t.Errorf("effect = %s, want %s", d.Effect, EffectNotApplicable)
+	}
+	if len(d.Trace) != 1 || d.Trace[0].Error == "" || d.Trace[0].Matched {
+		t.Errorf("trace = %+v, want one unmatched entry with an error", d.Trace)
+	}
+}
+
+func TestCompileRejectsInvalidPolicies(t *testing.T) {
+	tests := [][]Policy{
+		{{Effect: EffectAllow, Actions: []string{"*"}}},
+		{{ID: "a", Effect: "maybe", Actions: []string{"*"}}},
+		{{ID: "a", Effect: EffectAllow}},
+		{{ID: "a", Effect: EffectAllow, Actions: []string{"*"}, Condition: "1 +"}},
+		{{ID: "a", Effect: EffectAllow, Actions: []string{"*"}}, {ID: "a", Effect: EffectDeny, Actions: []string{"*"}}},
+	}
+
+	for i, policies := range tests {
+		if _, err := Compile(policies); err == nil {
+			t.Errorf("case %d: Compile succeeded, want error", i)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package policy
+
+import (
+	"auth-api/utils"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// InputFunc builds the subject and resource attributes for a request. It is
+// only called when at least one policy targets the action.
+type InputFunc func(r *http.Request) (Input, error)
+
+// Require must be wrapped by AuthMiddleware. RBAC decides by default; a
+// matching allow policy grants the action without the permission and a
+// matching deny policy refuses it even with the permission.
+func Require(engine *Engine, action string, input InputFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed, err := engine.Authorize(r, action, input)
+			if err != nil {
+				if errors.Is(err, errUnauthorized) {
+					utils.WriteError(w, http.StatusUnauthorized, err)
+					return
+				}
+				utils.WriteError(w, http.StatusInternalServerError, err)
+				return
+			}
+
+			if !allowed {
+				utils.WriteError(w, http.StatusForbidden, errors.New("forbidden"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+var errUnauthorized = errors.New("unauthorized")
+
+// Authorize combines the caller's RBAC permission for action with the
+// policy decision, for handlers that need to check an action themselves.
+func (e *Engine) Authorize(r *http.Request, action string, input InputFunc) (bool, error) {
+	allowed, err := utils.HasPermission(r, action)
+	if err != nil {
+		return false, errUnauthorized
+	}
+
+	if !e.Applies(action) {
+		return allowed, nil
+	}
+
+	in, err := input(r)
+	if err != nil {
+		return false, err
+	}
+	in.Action = action
+
+	d := e.Evaluate(in)
+	if e.DryRun() {
+		log.Printf("policy dry-run: action=%s effect=%s policy=%q rbac=%t", action, d.Effect, d.Policy, allowed)
+		return allowed, nil
+	}
+
+	switch d.Effect {
+	case EffectAllow:
+		return true, nil
+	case EffectDeny:
+		return false, nil
+	}
+	return allowed, nil
+}
+
+// SubjectFromRequest exposes the authenticated caller's token claims as
+// subject attributes: id, roles, scopes, tenant and email_verified.
+func SubjectFromRequest(r *http.Request) map[string]any {
+	subject := map[string]any{}
+
+	if userID, ok := utils.GetUserIDFromContext(r.Context()); ok {
+		subject["id"] = userID
+	}
+
+	if claims, ok := utils.GetClaimsFromContext(r.Context()); ok {
+		subject["roles"] = claims.Roles
+		subject["scopes"] = claims.Scopes()
+		subject["tenant"] = claims.Tenant
+		subject["email_verified"] = claims.EmailVerified
+	}
+
+	if actorID, ok := utils.GetActorIDFromContext(r.Context()); ok {
+		subject["actor_id"] = actorID
+	}
+
+	if accountID, ok := utils.GetServiceAccountIDFromContext(r.Context()); ok {
+		subject["service_account_id"] = accountID
+	}
+
+	return subject
+}
+
+// RequestAttributes exposes the request method, path and client IP.
+func RequestAttributes(r *http.Request) map[string]any {
+	return map[string]any{
+		"method": r.Method,
+		"path":   r.URL.Path,
+		"ip":     utils.ClientIP(r),
+	}
+}
@@ -0,0 +1,79 @@
+package policy
+
+import (
+	"auth-api/utils"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type Handler struct {
+	engine *Engine
+}
+
+func NewHandler(engine *Engine) *Handler {
+	return &Handler{engine: engine}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	manage := func(fn http.HandlerFunc) http.Handler {
+		return utils.AuthMiddleware(utils.RequirePermission(utils.PermRolesManage)(fn))
+	}
+
+	router.Handle("/admin/policies", manage(h.handleListPolicies)).Methods("GET")
+	router.Handle("/admin/policies/evaluate", manage(h.handleEvaluate)).Methods("POST")
+}
+
+func (h *Handler) handleListPolicies(w http.ResponseWriter, r *http.Request) {
+	policies := h.engine.Policies()
+	if policies == nil {
+		policies = []Policy{}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"dryRun":   h.engine.DryRun(),
+		"policies": policies,
+	})
+}
+
+// evaluatePayload asks for an explained decision. When Policies is set
+// those are evaluated instead of the loaded ones, so a rule can be tried
+// out before it is deployed.
+type evaluatePayload struct {
+	Action   string         `json:"action" validate:"required"`
+	Subject  map[string]any `json:"subject"`
+	Resource map[string]any `json:"resource"`
+	Request  map[string]any `json:"request"`
+	Policies []Policy       `json:"policies"`
+}
+
+func (h *Handler) handleEvaluate(w http.ResponseWriter, r *http.Request) {
+	var payload evaluatePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	policies := h.engine.Policies()
+	if payload.Policies != nil {
+		compiled, err := Compile(payload.Policies)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		policies = compiled
+	}
+
+	decision := Evaluate(policies, Input{
+		Action:   payload.Action,
+		Subject:  payload.Subject,
+		Resource: payload.Resource,
+		Request:  payload.Request,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, decision)
+}
@@ -0,0 +1,387 @@
+package rbac
+
+import (
+	"auth-api/services/audit"
+	"auth-api/types"
+	"auth-api/utils"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func (h *Handler) handleListGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := h.store.ListGroups()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, groups)
+}
+
+func (h *Handler) handleCreateGroup(w http.ResponseWriter, r *http.Request) {
+	var payload types.CreateGroupPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !roleNamePattern.MatchString(payload.Name) {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("group name must be lowercase letters, digits, '-' or '_'"))
+		return
+	}
+
+	groupID, err := h.store.CreateGroup(types.Group{
+		Name:        payload.Name,
+		Description: payload.Description,
+	})
+	if err != nil {
+		writeGroupStoreError(w, err)
+		return
+	}
+
+	audit.RecordRequest(h.audit, r, "group.create", "group", strconv.Itoa(groupID), map[string]any{
+		"name": payload.Name,
+	})
+
+	group, err := h.store.GetGroupByID(groupID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, group)
+}
+
+func (h *Handler) handleGetGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.loadGroup(w, r, "groupId")
+	if !ok {
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, group)
+}
+
+func (h *Handler) handleUpdateGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.loadGroup(w, r, "groupId")
+	if !ok {
+		return
+	}
+
+	var payload types.UpdateGroupPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if payload.Description != nil {
+		group.Description = *payload.Description
+	}
+
+	if err := h.store.UpdateGroup(*group); err != nil {
+		writeGroupStoreError(w, err)
+		return
+	}
+
+	audit.RecordRequest(h.audit, r, "group.update", "group", strconv.Itoa(group.ID), map[string]any{
+		"description": payload.Description,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, group)
+}
+
+func (h *Handler) handleDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.loadGroup(w, r, "groupId")
+	if !ok {
+		return
+	}
+
+	members, err := h.store.GroupUserIDs(group.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.store.DeleteGroup(group.ID); err != nil {
+		writeGroupStoreError(w, err)
+		return
+	}
+
+	if !h.revokeAccessTokens(w, members) {
+		return
+	}
+
+	audit.RecordRequest(h.audit, r, "group.delete", "group", strconv.Itoa(group.ID), map[string]any{
+		"name": group.Name,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "group deleted",
+	})
+}
+
+func (h *Handler) handleListGroupMembers(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.loadGroup(w, r, "groupId")
+	if !ok {
+		return
+	}
+
+	members, err := h.store.GetGroupMembers(group.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, members)
+}
+
+func (h *Handler) handleAddGroupUser(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.loadGroup(w, r, "groupId")
+	if !ok {
+		return
+	}
+
+	userID, ok := h.loadUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.AddGroupUser(group.ID, userID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	audit.RecordRequest(h.audit, r, "group.user_add", "group", strconv.Itoa(group.ID), map[string]any{
+		"userId": userID,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "user added to group",
+	})
+}
+
+func (h *Handler) handleRemoveGroupUser(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.loadGroup(w, r, "groupId")
+	if !ok {
+		return
+	}
+
+	userID, ok := h.loadUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.RemoveGroupUser(group.ID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusNotFound, errors.New("user is not a member of this group"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !h.revokeAccessTokens(w, []int{userID}) {
+		return
+	}
+
+	audit.RecordRequest(h.audit, r, "group.user_remove", "group", strconv.Itoa(group.ID), map[string]any{
+		"userId": userID,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "user removed from group",
+	})
+}
+
+func (h *Handler) handleAddSubgroup(w http.ResponseWriter, r *http.Request) {
+	parent, ok := h.loadGroup(w, r, "groupId")
+	if !ok {
+		return
+	}
+
+	child, ok := h.loadGroup(w, r, "childId")
+	if !ok {
+		return
+	}
+
+	if err := h.store.AddSubgroup(parent.ID, child.ID); err != nil {
+		writeGroupStoreError(w, err)
+		return
+	}
+
+	audit.RecordRequest(h.audit, r, "group.subgroup_add", "group", strconv.Itoa(parent.ID), map[string]any{
+		"childId": child.ID,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "group nested",
+	})
+}
+
+func (h *Handler) handleRemoveSubgroup(w http.ResponseWriter, r *http.Request) {
+	parent, ok := h.loadGroup(w, r, "groupId")
+	if !ok {
+		return
+	}
+
+	child, ok := h.loadGroup(w, r, "childId")
+	if !ok {
+		return
+	}
+
+	if err := h.store.RemoveSubgroup(parent.ID, child.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusNotFound, errors.New("group is not nested in this group"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !h.revokeGroupMembers(w, child.ID) {
+		return
+	}
+
+	audit.RecordRequest(h.audit, r, "group.subgroup_remove", "group", strconv.Itoa(parent.ID), map[string]any{
+		"childId": child.ID,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "group unnested",
+	})
+}
+
+func (h *Handler) handleAssignGroupRole(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.loadGroup(w, r, "groupId")
+	if !ok {
+		return
+	}
+
+	role, ok := h.loadRole(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.AssignGroupRole(group.ID, role.ID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	audit.RecordRequest(h.audit, r, "group.role_assign", "group", strconv.Itoa(group.ID), map[string]any{
+		"role": role.Name,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "role assigned",
+	})
+}
+
+func (h *Handler) handleUnassignGroupRole(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.loadGroup(w, r, "groupId")
+	if !ok {
+		return
+	}
+
+	role, ok := h.loadRole(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.UnassignGroupRole(group.ID, role.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusNotFound, errors.New("role not assigned"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !h.revokeGroupMembers(w, group.ID) {
+		return
+	}
+
+	audit.RecordRequest(h.audit, r, "group.role_unassign", "group", strconv.Itoa(group.ID), map[string]any{
+		"role": role.Name,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "role unassigned",
+	})
+}
+
+// handleListUserGroups shows where a user's inherited roles come from:
+// every effective group plus the resolved roles and permissions.
+func (h *Handler) handleListUserGroups(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.loadUserID(w, r)
+	if !ok {
+		return
+	}
+
+	groups, err := h.store.GetUserGroups(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	roles, err := h.store.EffectiveUserRoles(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	perms, err := h.store.UserPermissions(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"groups":         groups,
+		"effectiveRoles": roles,
+		"permissions":    perms,
+	})
+}
+
+func (h *Handler) loadGroup(w http.ResponseWriter, r *http.Request, param string) (*types.Group, bool) {
+	groupID, err := strconv.Atoi(mux.Vars(r)[param])
+	if err != nil || groupID <= 0 {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("invalid group id"))
+		return nil, false
+	}
+
+	group, err := h.store.GetGroupByID(groupID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusNotFound, errors.New("group not found"))
+			return nil, false
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	return group, true
+}
+
+func writeGroupStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		utils.WriteError(w, http.StatusNotFound, errors.New("group not found"))
+	case errors.Is(err, ErrGroupExists):
+		utils.WriteError(w, http.StatusConflict, err)
+	case errors.Is(err, ErrGroupCycle):
+		utils.WriteError(w, http.StatusBadRequest, err)
+	default:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,385 @@
+package rbac
+
+import (
+	"auth-api/services/audit"
+	"auth-api/types"
+	"auth-api/utils"
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+type Handler struct {
+	store     types.RoleStore
+	userStore types.UserStore
+	audit     types.AuditStore
+}
+
+func NewHandler(store types.RoleStore, userStore types.UserStore, audit types.AuditStore) *Handler {
+	return &Handler{store: store, userStore: userStore, audit: audit}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	manage := func(fn http.HandlerFunc) http.Handler {
+		return utils.AuthMiddleware(utils.RequirePermission(utils.PermRolesManage)(fn))
+	}
+
+	router.Handle("/admin/permissions", manage(h.handleListPermissions)).Methods("GET")
+	router.Handle("/admin/roles", manage(h.handleListRoles)).Methods("GET")
+	router.Handle("/admin/roles", manage(h.handleCreateRole)).Methods("POST")
+	router.Handle("/admin/roles/{roleId}", manage(h.handleGetRole)).Methods("GET")
+	router.Handle("/admin/roles/{roleId}", manage(h.handleUpdateRole)).Methods("PATCH")
+	router.Handle("/admin/roles/{roleId}", manage(h.handleDeleteRole)).Methods("DELETE")
+	router.Handle("/admin/users/{id}/roles", manage(h.handleListUserRoles)).Methods("GET")
+	router.Handle("/admin/users/{id}/roles/{roleId}", manage(h.handleAssignRole)).Methods("PUT")
+	router.Handle("/admin/users/{id}/roles/{roleId}", manage(h.handleUnassignRole)).Methods("DELETE")
+	router.Handle("/admin/users/{id}/groups", manage(h.handleListUserGroups)).Methods("GET")
+
+	router.Handle("/admin/groups", manage(h.handleListGroups)).Methods("GET")
+	router.Handle("/admin/groups", manage(h.handleCreateGroup)).Methods("POST")
+	router.Handle("/admin/groups/{groupId}", manage(h.handleGetGroup)).Methods("GET")
+	router.Handle("/admin/groups/{groupId}", manage(h.handleUpdateGroup)).Methods("PATCH")
+	router.Handle("/admin/groups/{groupId}", manage(h.handleDeleteGroup)).Methods("DELETE")
+	router.Handle("/admin/groups/{groupId}/members", manage(h.handleListGroupMembers)).Methods("GET")
+	router.Handle("/admin/groups/{groupId}/users/{id}", manage(h.handleAddGroupUser)).Methods("PUT")
+	router.Handle("/admin/groups/{groupId}/users/{id}", manage(h.handleRemoveGroupUser)).Methods("DELETE")
+	router.Handle("/admin/groups/{groupId}/groups/{childId}", manage(h.handleAddSubgroup)).Methods("PUT")
+	router.Handle("/admin/groups/{groupId}/groups/{childId}", manage(h.handleRemoveSubgroup)).Methods("DELETE")
+	router.Handle("/admin/groups/{groupId}/roles/{roleId}", manage(h.handleAssignGroupRole)).Methods("PUT")
+	router.Handle("/admin/groups/{groupId}/roles/{roleId}", manage(h.handleUnassignGroupRole)).Methods("DELETE")
+}
+
+func (h *Handler) handleListPermissions(w http.ResponseWriter, r *http.Request) {
+	perms, err := h.store.ListPermissions()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, perms)
+}
+
+func (h *Handler) handleListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.store.ListRoles()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, roles)
+}
+
+func (h *Handler) handleCreateRole(w http.ResponseWriter, r *http.Request) {
+	var payload types.CreateRolePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !roleNamePattern.MatchString(payload.Name) {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("role name must be lowercase letters, digits, '-' or '_'"))
+		return
+	}
+
+	roleID, err := h.store.CreateRole(types.Role{
+		Name:        payload.Name,
+		Description: payload.Description,
+		Permissions: payload.Permissions,
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	audit.RecordRequest(h.audit, r, "role.create", "role", strconv.Itoa(roleID), map[string]any{
+		"name":        payload.Name,
+		"permissions": payload.Permissions,
+	})
+
+	role, err := h.store.GetRoleByID(roleID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, role)
+}
+
+func (h *Handler) handleGetRole(w http.ResponseWriter, r *http.Request) {
+	role, ok := h.loadRole(w, r)
+	if !ok {
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, role)
+}
+
+func (h *Handler) handleUpdateRole(w http.ResponseWriter, r *http.Request) {
+	role, ok := h.loadRole(w, r)
+	if !ok {
+		return
+	}
+
+	var payload types.UpdateRolePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Stripping the admin role of roles:manage would leave nobody able to
+	// undo it.
+	if role.Name == "admin" && payload.Permissions != nil && !slices.Contains(payload.Permissions, utils.PermRolesManage) {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("the admin role must keep roles:manage"))
+		return
+	}
+
+	var removed bool
+	if payload.Permissions != nil {
+		removed = slices.ContainsFunc(role.Permissions, func(perm string) bool {
+			return !slices.Contains(payload.Permissions, perm)
+		})
+	}
+
+	if payload.Description != nil {
+		role.Description = *payload.Description
+	}
+	role.Permissions = payload.Permissions
+
+	if err := h.store.UpdateRole(*role); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if removed && !h.revokeRoleHolders(w, role.ID) {
+		return
+	}
+
+	audit.RecordRequest(h.audit, r, "role.update", "role", strconv.Itoa(role.ID), map[string]any{
+		"description": payload.Description,
+		"permissions": payload.Permissions,
+	})
+
+	role, err := h.store.GetRoleByID(role.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, role)
+}
+
+func (h *Handler) handleDeleteRole(w http.ResponseWriter, r *http.Request) {
+	role, ok := h.loadRole(w, r)
+	if !ok {
+		return
+	}
+
+	if role.System {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("built-in roles cannot be deleted"))
+		return
+	}
+
+	holders, err := h.store.RoleUserIDs(role.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.store.DeleteRole(role.ID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if !h.revokeAccessTokens(w, holders) {
+		return
+	}
+
+	audit.RecordRequest(h.audit, r, "role.delete", "role", strconv.Itoa(role.ID), map[string]any{
+		"name": role.Name,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "role deleted",
+	})
+}
+
+func (h *Handler) handleListUserRoles(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.loadUserID(w, r)
+	if !ok {
+		return
+	}
+
+	roles, err := h.store.GetUserRoles(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, roles)
+}
+
+func (h *Handler) handleAssignRole(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.loadUserID(w, r)
+	if !ok {
+		return
+	}
+
+	role, ok := h.loadRole(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.AssignRole(userID, role.ID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	audit.RecordRequest(h.audit, r, "user.role_assign", "user", strconv.Itoa(userID), map[string]any{
+		"role": role.Name,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "role assigned",
+	})
+}
+
+func (h *Handler) handleUnassignRole(w http.ResponseWriter, r *http.Request) {
+	actorID, _ := utils.GetUserIDFromContext(r.Context())
+
+	userID, ok := h.loadUserID(w, r)
+	if !ok {
+		return
+	}
+
+	role, ok := h.loadRole(w, r)
+	if !ok {
+		return
+	}
+
+	if userID == actorID && slices.Contains(role.Permissions, utils.PermRolesManage) {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("cannot remove a role that lets you manage roles from yourself"))
+		return
+	}
+
+	if err := h.store.UnassignRole(userID, role.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusNotFound, errors.New("role not assigned"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !h.revokeAccessTokens(w, []int{userID}) {
+		return
+	}
+
+	audit.RecordRequest(h.audit, r, "user.role_unassign", "user", strconv.Itoa(userID), map[string]any{
+		"role": role.Name,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "role unassigned",
+	})
+}
+
+// revokeAccessTokens ends the access tokens of users who just lost a role
+// or permission. Tokens carry the roles and scope they were issued with,
+// so they would otherwise keep the old access until they expire; a refresh
+// issues new ones.
+func (h *Handler) revokeAccessTokens(w http.ResponseWriter, userIDs []int) bool {
+	for _, userID := range userIDs {
+		if err := h.userStore.RevokeAccessTokensForUser(userID); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Handler) revokeRoleHolders(w http.ResponseWriter, roleID int) bool {
+	userIDs, err := h.store.RoleUserIDs(roleID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return false
+	}
+	return h.revokeAccessTokens(w, userIDs)
+}
+
+// revokeGroupMembers covers the group's own members and those of its
+// subgroups, who inherit its roles.
+func (h *Handler) revokeGroupMembers(w http.ResponseWriter, groupID int) bool {
+	userIDs, err := h.store.GroupUserIDs(groupID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return false
+	}
+	return h.revokeAccessTokens(w, userIDs)
+}
+
+func (h *Handler) loadRole(w http.ResponseWriter, r *http.Request) (*types.Role, bool) {
+	roleID, err := strconv.Atoi(mux.Vars(r)["roleId"])
+	if err != nil || roleID <= 0 {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("invalid role id"))
+		return nil, false
+	}
+
+	role, err := h.store.GetRoleByID(roleID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusNotFound, errors.New("role not found"))
+			return nil, false
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	return role, true
+}
+
+func (h *Handler) loadUserID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || userID <= 0 {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("invalid user id"))
+		return 0, false
+	}
+
+	if _, err := h.userStore.GetUserByID(userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusNotFound, errors.New("user not found"))
+			return 0, false
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return 0, false
+	}
+
+	return userID, true
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		utils.WriteError(w, http.StatusNotFound, errors.New("role not found"))
+	case errors.Is(err, ErrRoleExists):
+		utils.WriteError(w, http.StatusConflict, err)
+	case errors.Is(err, ErrUnknownPermission):
+		utils.WriteError(w, http.StatusBadRequest, err)
+	default:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+	}
+}
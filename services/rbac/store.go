@@ -0,0 +1,784 @@
+package rbac
+
+import (
+	"auth-api/types"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrUnknownPermission = errors.New("unknown permission")
+	ErrRoleExists        = errors.New("role already exists")
+	ErrGroupExists       = errors.New("group already exists")
+	ErrGroupCycle        = errors.New("group would contain itself")
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+const roleSelect = `SELECT r.id, r.name, r.description, r.system, r.created_at,
+                COALESCE(array_agg(p.name ORDER BY p.name) FILTER (WHERE p.name IS NOT NULL), '{}')
+           FROM roles r
+           LEFT JOIN role_permissions rp ON rp.role_id = r.id
+           LEFT JOIN permissions p ON p.id = rp.permission_id`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRole(row rowScanner) (*types.Role, error) {
+	var r types.Role
+	err := row.Scan(
+		&r.ID,
+		&r.Name,
+		&r.Description,
+		&r.System,
+		&r.CreatedAt,
+		pq.Array(&r.Permissions),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+func (s *Store) queryRoles(query string, args ...any) ([]types.Role, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []types.Role{}
+	for rows.Next() {
+		r, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, *r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+func (s *Store) ListRoles() ([]types.Role, error) {
+	return s.queryRoles(roleSelect + `
+          GROUP BY r.id
+          ORDER BY r.name`)
+}
+
+func (s *Store) GetRoleByID(id int) (*types.Role, error) {
+	row := s.db.QueryRow(roleSelect+`
+          WHERE r.id = $1
+          GROUP BY r.id`,
+		id,
+	)
+
+	return scanRole(row)
+}
+
+func (s *Store) CreateRole(role types.Role) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(
+		`INSERT INTO roles (name, description)
+         VALUES ($1, $2)
+         RETURNING id`,
+		role.Name,
+		role.Description,
+	).Scan(&id)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return 0, ErrRoleExists
+		}
+		return 0, err
+	}
+
+	if err := setRolePermissions(tx, id, role.Permissions); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// UpdateRole saves the description and replaces the permission set. A nil
+// Permissions slice leaves permissions untouched.
+func (s *Store) UpdateRole(role types.Role) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`UPDATE roles
+           SET description = $1
+         WHERE id = $2`,
+		role.Description,
+		role.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if role.Permissions != nil {
+		if err := setRolePermissions(tx, role.ID, role.Permissions); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func setRolePermissions(tx *sql.Tx, roleID int, perms []string) error {
+	if _, err := tx.Exec(
+		`DELETE FROM role_permissions
+         WHERE role_id = $1`,
+		roleID,
+	); err != nil {
+		return err
+	}
+
+	if len(perms) == 0 {
+		return nil
+	}
+
+	res, err := tx.Exec(
+		`INSERT INTO role_permissions (role_id, permission_id)
+         SELECT $1, id FROM permissions WHERE name = ANY($2)`,
+		roleID,
+		pq.Array(perms),
+	)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); int(n) != len(uniqueStrings(perms)) {
+		return ErrUnknownPermission
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteRole(id int) error {
+	res, err := s.db.Exec(
+		`DELETE FROM roles
+         WHERE id = $1`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Store) ListPermissions() ([]types.Permission, error) {
+	rows, err := s.db.Query(
+		`SELECT id, name, description
+           FROM permissions
+          ORDER BY name`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	perms := []types.Permission{}
+	for rows.Next() {
+		var p types.Permission
+		if err := rows.Scan(&p.ID, &p.Name, &p.Description); err != nil {
+			return nil, err
+		}
+		perms = append(perms, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return perms, nil
+}
+
+func (s *Store) GetUserRoles(userID int) ([]types.Role, error) {
+	return s.queryRoles(roleSelect+`
+           JOIN user_roles ur ON ur.role_id = r.id
+          WHERE ur.user_id = $1
+          GROUP BY r.id
+          ORDER BY r.name`,
+		userID,
+	)
+}
+
+func (s *Store) AssignRole(userID, roleID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`INSERT INTO user_roles (user_id, role_id)
+         VALUES ($1, $2)
+         ON CONFLICT DO NOTHING`,
+		userID,
+		roleID,
+	); err != nil {
+		return err
+	}
+
+	if err := syncLegacyRole(tx, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) UnassignRole(userID, roleID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`DELETE FROM user_roles
+         WHERE user_id = $1
+           AND role_id = $2`,
+		userID,
+		roleID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := syncLegacyRole(tx, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// syncLegacyRole keeps users.role, which predates RBAC and is still
+// reported by the user endpoints, in line with the user's direct role
+// assignments: "admin" while the admin role is assigned, "user" otherwise.
+func syncLegacyRole(tx *sql.Tx, userID int) error {
+	_, err := tx.Exec(
+		`UPDATE users
+            SET role = CASE WHEN EXISTS (
+                    SELECT 1
+                      FROM user_roles ur
+                      JOIN roles r ON r.id = ur.role_id
+                     WHERE ur.user_id = $1
+                       AND r.name = 'admin'
+                ) THEN 'admin' ELSE 'user' END
+          WHERE id = $1`,
+		userID,
+	)
+	return err
+}
+
+// userGroupsCTE expands $1's direct groups to every group that contains
+// them, however deeply nested. UNION (not UNION ALL) stops the recursion on
+// rows already seen, so a cycle can never make it loop.
+const userGroupsCTE = `WITH RECURSIVE user_groups (group_id) AS (
+                SELECT group_id FROM group_members WHERE user_id = $1
+                 UNION
+                SELECT gs.parent_id
+                  FROM group_subgroups gs
+                  JOIN user_groups ug ON gs.child_id = ug.group_id
+           ),
+           effective_roles (role_id) AS (
+                SELECT role_id FROM user_roles WHERE user_id = $1
+                 UNION
+                SELECT gr.role_id
+                  FROM group_roles gr
+                  JOIN user_groups ug ON ug.group_id = gr.group_id
+           )
+           `
+
+// EffectiveUserRoles returns the user's own roles plus those inherited
+// through groups.
+func (s *Store) EffectiveUserRoles(userID int) ([]types.Role, error) {
+	return s.queryRoles(userGroupsCTE+roleSelect+`
+          WHERE r.id IN (SELECT role_id FROM effective_roles)
+          GROUP BY r.id
+          ORDER BY r.name`,
+		userID,
+	)
+}
+
+// UserPermissions resolves the union of permissions over all of the user's
+// effective roles. It backs utils.RequirePermission.
+func (s *Store) UserPermissions(userID int) ([]string, error) {
+	rows, err := s.db.Query(
+		userGroupsCTE+`SELECT DISTINCT p.name
+           FROM effective_roles er
+           JOIN role_permissions rp ON rp.role_id = er.role_id
+           JOIN permissions p ON p.id = rp.permission_id
+          ORDER BY p.name`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	perms := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		perms = append(perms, name)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return perms, nil
+}
+
+func uniqueStrings(in []string) []string {
+	seen := make(map[string]bool, len(in))
+	out := make([]string, 0, len(in))
+	for _, v := range in {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+const groupSelect = `SELECT g.id, g.name, g.description, g.created_at,
+                COALESCE(array_agg(r.name ORDER BY r.name) FILTER (WHERE r.name IS NOT NULL), '{}')
+           FROM groups g
+           LEFT JOIN group_roles gr ON gr.group_id = g.id
+           LEFT JOIN roles r ON r.id = gr.role_id`
+
+func scanGroup(row rowScanner) (*types.Group, error) {
+	var g types.Group
+	err := row.Scan(
+		&g.ID,
+		&g.Name,
+		&g.Description,
+		&g.CreatedAt,
+		pq.Array(&g.Roles),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &g, nil
+}
+
+func (s *Store) queryGroups(query string, args ...any) ([]types.Group, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []types.Group{}
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, *g)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+func (s *Store) ListGroups() ([]types.Group, error) {
+	return s.queryGroups(groupSelect + `
+          GROUP BY g.id
+          ORDER BY g.name`)
+}
+
+func (s *Store) GetGroupByID(id int) (*types.Group, error) {
+	row := s.db.QueryRow(groupSelect+`
+          WHERE g.id = $1
+          GROUP BY g.id`,
+		id,
+	)
+
+	return scanGroup(row)
+}
+
+func (s *Store) CreateGroup(group types.Group) (int, error) {
+	var id int
+	err := s.db.QueryRow(
+		`INSERT INTO groups (name, description)
+         VALUES ($1, $2)
+         RETURNING id`,
+		group.Name,
+		group.Description,
+	).Scan(&id)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return 0, ErrGroupExists
+		}
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (s *Store) UpdateGroup(group types.Group) error {
+	res, err := s.db.Exec(
+		`UPDATE groups
+           SET description = $1
+         WHERE id = $2`,
+		group.Description,
+		group.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Store) DeleteGroup(id int) error {
+	res, err := s.db.Exec(
+		`DELETE FROM groups
+         WHERE id = $1`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Store) GetGroupMembers(groupID int) (*types.GroupMembers, error) {
+	rows, err := s.db.Query(
+		`SELECT u.id, u.username, u.email
+           FROM group_members gm
+           JOIN users u ON u.id = gm.user_id
+          WHERE gm.group_id = $1
+          ORDER BY u.username`,
+		groupID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := &types.GroupMembers{Users: []types.GroupUser{}}
+	for rows.Next() {
+		var u types.GroupUser
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email); err != nil {
+			return nil, err
+		}
+		members.Users = append(members.Users, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	members.Groups, err = s.queryGroups(groupSelect+`
+          WHERE g.id IN (SELECT child_id FROM group_subgroups WHERE parent_id = $1)
+          GROUP BY g.id
+          ORDER BY g.name`,
+		groupID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (s *Store) AddGroupUser(groupID, userID int) error {
+	_, err := s.db.Exec(
+		`INSERT INTO group_members (group_id, user_id)
+         VALUES ($1, $2)
+         ON CONFLICT DO NOTHING`,
+		groupID,
+		userID,
+	)
+	return err
+}
+
+func (s *Store) RemoveGroupUser(groupID, userID int) error {
+	res, err := s.db.Exec(
+		`DELETE FROM group_members
+         WHERE group_id = $1
+           AND user_id = $2`,
+		groupID,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AddSubgroup nests childID inside parentID. It refuses links that would
+// make a group reachable from itself; the table lock keeps two concurrent
+// additions from closing a cycle between them.
+func (s *Store) AddSubgroup(parentID, childID int) error {
+	if parentID == childID {
+		return ErrGroupCycle
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`LOCK TABLE group_subgroups IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+
+	var cycle bool
+	err = tx.QueryRow(
+		`WITH RECURSIVE descendants (group_id) AS (
+                SELECT child_id FROM group_subgroups WHERE parent_id = $1
+                 UNION
+                SELECT gs.child_id
+                  FROM group_subgroups gs
+                  JOIN descendants d ON gs.parent_id = d.group_id
+           )
+         SELECT EXISTS (SELECT 1 FROM descendants WHERE group_id = $2)`,
+		childID,
+		parentID,
+	).Scan(&cycle)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return ErrGroupCycle
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO group_subgroups (parent_id, child_id)
+         VALUES ($1, $2)
+         ON CONFLICT DO NOTHING`,
+		parentID,
+		childID,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) RemoveSubgroup(parentID, childID int) error {
+	res, err := s.db.Exec(
+		`DELETE FROM group_subgroups
+         WHERE parent_id = $1
+           AND child_id = $2`,
+		parentID,
+		childID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Store) AssignGroupRole(groupID, roleID int) error {
+	_, err := s.db.Exec(
+		`INSERT INTO group_roles (group_id, role_id)
+         VALUES ($1, $2)
+         ON CONFLICT DO NOTHING`,
+		groupID,
+		roleID,
+	)
+	return err
+}
+
+func (s *Store) UnassignGroupRole(groupID, roleID int) error {
+	res, err := s.db.Exec(
+		`DELETE FROM group_roles
+         WHERE group_id = $1
+           AND role_id = $2`,
+		groupID,
+		roleID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetUserGroups returns every group the user belongs to directly or
+// through nesting.
+func (s *Store) GetUserGroups(userID int) ([]types.UserGroup, error) {
+	rows, err := s.db.Query(
+		userGroupsCTE+groupSelect+`
+          WHERE g.id IN (SELECT group_id FROM user_groups)
+          GROUP BY g.id
+          ORDER BY g.name`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []types.UserGroup{}
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, types.UserGroup{Group: *g})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	direct, err := s.directGroupIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		groups[i].Direct = direct[groups[i].ID]
+	}
+
+	return groups, nil
+}
+
+func (s *Store) directGroupIDs(userID int) (map[int]bool, error) {
+	rows, err := s.db.Query(
+		`SELECT group_id
+           FROM group_members
+          WHERE user_id = $1`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+
+	return ids, rows.Err()
+}
+
+// RoleUserIDs returns every user who holds the role, directly or through
+// a group.
+func (s *Store) RoleUserIDs(roleID int) ([]int, error) {
+	return s.queryUserIDs(
+		`WITH RECURSIVE role_groups (group_id) AS (
+                SELECT group_id FROM group_roles WHERE role_id = $1
+                 UNION
+                SELECT gs.child_id
+                  FROM group_subgroups gs
+                  JOIN role_groups rg ON gs.parent_id = rg.group_id
+           )
+         SELECT user_id FROM user_roles WHERE role_id = $1
+          UNION
+         SELECT gm.user_id
+           FROM group_members gm
+           JOIN role_groups rg ON rg.group_id = gm.group_id`,
+		roleID,
+	)
+}
+
+// GroupUserIDs returns every member of the group, including members of
+// its subgroups.
+func (s *Store) GroupUserIDs(groupID int) ([]int, error) {
+	return s.queryUserIDs(
+		`WITH RECURSIVE group_tree (group_id) AS (
+                SELECT $1::int
+                 UNION
+                SELECT gs.child_id
+                  FROM group_subgroups gs
+                  JOIN group_tree gt ON gs.parent_id = gt.group_id
+           )
+         SELECT DISTINCT gm.user_id
+           FROM group_members gm
+           JOIN group_tree gt ON gt.group_id = gm.group_id`,
+		groupID,
+	)
+}
+
+func (s *Store) queryUserIDs(query string, args ...any) ([]int, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
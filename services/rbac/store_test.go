@@ -0,0 +1,139 @@
+package rbac
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// subgroupDriver answers the statements AddSubgroup runs against an
+// in-memory group_subgroups table, so the direction of the cycle check can
+// be tested without a database.
+type subgroupDriver struct {
+	mu    sync.Mutex
+	edges map[[2]int64]bool
+}
+
+func (d *subgroupDriver) Open(string) (driver.Conn, error) { return &subgroupConn{d}, nil }
+
+type subgroupConn struct{ d *subgroupDriver }
+
+func (c *subgroupConn) Prepare(query string) (driver.Stmt, error) {
+	return &subgroupStmt{d: c.d, query: query}, nil
+}
+func (c *subgroupConn) Close() error              { return nil }
+func (c *subgroupConn) Begin() (driver.Tx, error) { return subgroupTx{}, nil }
+
+type subgroupTx struct{}
+
+func (subgroupTx) Commit() error   { return nil }
+func (subgroupTx) Rollback() error { return nil }
+
+type subgroupStmt struct {
+	d     *subgroupDriver
+	query string
+}
+
+func (s *subgroupStmt) Close() error  { return nil }
+func (s *subgroupStmt) NumInput() int { return -1 }
+
+func (s *subgroupStmt) Exec(args []driver.Value) (driver.Result, error) {
+	switch {
+	case strings.HasPrefix(s.query, "LOCK TABLE"):
+	case strings.Contains(s.query, "INSERT INTO group_subgroups"):
+		s.d.mu.Lock()
+		s.d.edges[[2]int64{args[0].(int64), args[1].(int64)}] = true
+		s.d.mu.Unlock()
+	default:
+		return nil, errors.New("unexpected statement: " + s.query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+// Query reports whether args[1] is a descendant of args[0].
+func (s *subgroupStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if !strings.Contains(s.query, "WITH RECURSIVE descendants") {
+		return nil, errors.New("unexpected query: " + s.query)
+	}
+
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+
+	from, target := args[0].(int64), args[1].(int64)
+	seen := map[int64]bool{}
+	queue := []int64{from}
+	for len(queue) > 0 {
+		group := queue[0]
+		queue = queue[1:]
+		for edge := range s.d.edges {
+			if edge[0] == group && !seen[edge[1]] {
+				seen[edge[1]] = true
+				queue = append(queue, edge[1])
+			}
+		}
+	}
+	return &boolRows{value: seen[target]}, nil
+}
+
+type boolRows struct {
+	value bool
+	done  bool
+}
+
+func (r *boolRows) Columns() []string { return []string{"exists"} }
+func (r *boolRows) Close() error      { return nil }
+func (r *boolRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.value
+	return nil
+}
+
+var subgroups = &subgroupDriver{edges: map[[2]int64]bool{}}
+
+func init() {
+	sql.Register("rbac-subgroups", subgroups)
+}
+
+func TestAddSubgroupRefusesCycles(t *testing.T) {
+	db, err := sql.Open("rbac-subgroups", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s := NewStore(db)
+
+	// 1 -> 2 -> 3
+	for _, link := range [][2]int{{1, 2}, {2, 3}} {
+		if err := s.AddSubgroup(link[0], link[1]); err != nil {
+			t.Fatalf("AddSubgroup(%d, %d) = %v", link[0], link[1], err)
+		}
+	}
+
+	tests := []struct {
+		parent, child int
+		want          error
+	}{
+		{1, 1, ErrGroupCycle},
+		{3, 1, ErrGroupCycle},
+		{2, 1, ErrGroupCycle},
+		{3, 2, ErrGroupCycle},
+		{1, 3, nil},
+		{4, 1, nil},
+	}
+	for _, tt := range tests {
+		if err := s.AddSubgroup(tt.parent, tt.child); !errors.Is(err, tt.want) {
+			t.Errorf("AddSubgroup(%d, %d) = %v, want %v", tt.parent, tt.child, err, tt.want)
+		}
+	}
+
+	if subgroups.edges[[2]int64{3, 1}] {
+		t.Error("a refused link was inserted")
+	}
+}
@@ -0,0 +1,562 @@
+package serviceaccount
+
+import (
+	"auth-api/configs"
+	"auth-api/services/audit"
+	"auth-api/types"
+	"auth-api/utils"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// keyDisplayPrefixLen is how much of a key is kept in clear so admins can
+// tell keys apart, e.g. "sak_Xy3kq9Ab".
+const keyDisplayPrefixLen = 12
+
+type Handler struct {
+	store    types.ServiceAccountStore
+	orgStore types.OrganizationStore
+	audit    types.AuditStore
+}
+
+func NewHandler(store types.ServiceAccountStore, orgStore types.OrganizationStore, audit types.AuditStore) *Handler {
+	return &Handler{store: store, orgStore: orgStore, audit: audit}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	manage := func(fn http.HandlerFunc) http.Handler {
+		return utils.AuthMiddleware(utils.RequirePermission(utils.PermServiceAccountsManage)(fn))
+	}
+
+	router.Handle("/admin/service-accounts", manage(h.handleListServiceAccounts)).Methods("GET")
+	router.Handle("/admin/service-accounts", manage(h.handleCreateServiceAccount)).Methods("POST")
+	router.Handle("/admin/service-accounts/{accountId}", manage(h.handleGetServiceAccount)).Methods("GET")
+	router.Handle("/admin/service-accounts/{accountId}", manage(h.handleUpdateServiceAccount)).Methods("PATCH")
+	router.Handle("/admin/service-accounts/{accountId}", manage(h.handleDeleteServiceAccount)).Methods("DELETE")
+	router.Handle("/admin/service-accounts/{accountId}/keys", manage(h.handleListKeys)).Methods("GET")
+	router.Handle("/admin/service-accounts/{accountId}/keys", manage(h.handleCreateKey)).Methods("POST")
+	router.Handle("/admin/service-accounts/{accountId}/keys/{keyId}/rotate", manage(h.handleRotateKey)).Methods("POST")
+	router.Handle("/admin/service-accounts/{accountId}/keys/{keyId}", manage(h.handleRevokeKey)).Methods("DELETE")
+}
+
+func (h *Handler) handleListServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	orgID, allOrgs, err := utils.OrganizationScope(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusForbidden, err)
+		return
+	}
+
+	var filter *int
+	if !allOrgs {
+		filter = &orgID
+	}
+
+	accounts, err := h.store.ListServiceAccounts(filter)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, accounts)
+}
+
+// handleCreateServiceAccount puts the account in the caller's active
+// organization. Platform admins may pick any organization, or none for a
+// platform-wide account.
+func (h *Handler) handleCreateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	var payload types.CreateServiceAccountPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	orgID, allOrgs, err := utils.OrganizationScope(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusForbidden, err)
+		return
+	}
+
+	account := types.ServiceAccount{
+		Name:           payload.Name,
+		Description:    payload.Description,
+		OrganizationID: payload.OrganizationID,
+	}
+	if !allOrgs {
+		if payload.OrganizationID != nil && *payload.OrganizationID != orgID {
+			utils.WriteError(w, http.StatusForbidden, errors.New("cannot create service accounts for another organization"))
+			return
+		}
+		account.OrganizationID = &orgID
+	}
+
+	if account.OrganizationID != nil {
+		if _, err := h.orgStore.GetOrganizationByID(*account.OrganizationID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				utils.WriteError(w, http.StatusBadRequest, errors.New("organization not found"))
+				return
+			}
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	if account.Scopes, err = grantableScopes(r, payload.Scopes); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if account.IPAllowlist, err = normalizeAllowlist(payload.IPAllowlist); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if callerID, ok := utils.GetUserIDFromContext(r.Context()); ok {
+		account.CreatedBy = &callerID
+	}
+
+	accountID, err := h.store.CreateServiceAccount(account)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.recordAudit(r, "service_account.create", accountID, map[string]any{
+		"name":           account.Name,
+		"organizationId": account.OrganizationID,
+		"scopes":         account.Scopes,
+		"ipAllowlist":    account.IPAllowlist,
+	})
+
+	created, err := h.store.GetServiceAccountByID(accountID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handler) handleGetServiceAccount(w http.ResponseWriter, r *http.Request) {
+	account, ok := h.loadAccount(w, r)
+	if !ok {
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, account)
+}
+
+func (h *Handler) handleUpdateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	account, ok := h.loadAccount(w, r)
+	if !ok {
+		return
+	}
+
+	var payload types.UpdateServiceAccountPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var err error
+	if payload.Description != nil {
+		account.Description = *payload.Description
+	}
+	if payload.Scopes != nil {
+		if account.Scopes, err = grantableScopes(r, *payload.Scopes); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if payload.IPAllowlist != nil {
+		if account.IPAllowlist, err = normalizeAllowlist(*payload.IPAllowlist); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if payload.Disabled != nil {
+		switch {
+		case *payload.Disabled && account.DisabledAt == nil:
+			now := time.Now().UTC()
+			account.DisabledAt = &now
+		case !*payload.Disabled:
+			account.DisabledAt = nil
+		}
+	}
+
+	if err := h.store.UpdateServiceAccount(*account); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusNotFound, errors.New("service account not found"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.recordAudit(r, "service_account.update", account.ID, map[string]any{
+		"description": payload.Description,
+		"scopes":      payload.Scopes,
+		"ipAllowlist": payload.IPAllowlist,
+		"disabled":    payload.Disabled,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, account)
+}
+
+func (h *Handler) handleDeleteServiceAccount(w http.ResponseWriter, r *http.Request) {
+	account, ok := h.loadAccount(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.DeleteServiceAccount(account.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusNotFound, errors.New("service account not found"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.recordAudit(r, "service_account.delete", account.ID, map[string]any{
+		"name": account.Name,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "service account deleted",
+	})
+}
+
+func (h *Handler) handleListKeys(w http.ResponseWriter, r *http.Request) {
+	account, ok := h.loadAccount(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := h.store.ListServiceAccountKeys(account.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, keys)
+}
+
+// handleCreateKey returns the key exactly once; only its hash is stored.
+func (h *Handler) handleCreateKey(w http.ResponseWriter, r *http.Request) {
+	account, ok := h.loadAccount(w, r)
+	if !ok {
+		return
+	}
+
+	var payload types.CreateServiceAccountKeyPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now().UTC()) {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("expiresAt must be in the future"))
+		return
+	}
+
+	rawKey, err := generateKey()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	key := types.ServiceAccountKey{
+		ServiceAccountID: account.ID,
+		Name:             payload.Name,
+		Prefix:           rawKey[:keyDisplayPrefixLen],
+		ExpiresAt:        payload.ExpiresAt,
+		CreatedAt:        time.Now().UTC(),
+	}
+
+	key.ID, err = h.store.CreateServiceAccountKey(key, utils.HashToken(rawKey))
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.recordAudit(r, "service_account.key_create", account.ID, map[string]any{
+		"keyId": key.ID,
+		"name":  key.Name,
+	})
+
+	utils.WriteJSON(w, http.StatusCreated, map[string]any{
+		"apiKey": rawKey,
+		"key":    key,
+	})
+}
+
+// handleRotateKey issues a replacement key while the old one keeps working
+// until the overlap runs out.
+func (h *Handler) handleRotateKey(w http.ResponseWriter, r *http.Request) {
+	account, ok := h.loadAccount(w, r)
+	if !ok {
+		return
+	}
+
+	keyID, ok := parseKeyID(w, r)
+	if !ok {
+		return
+	}
+
+	var payload types.RotateServiceAccountKeyPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(now) {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("expiresAt must be in the future"))
+		return
+	}
+
+	overlap := configs.Envs.APIKeyRotationOverlap
+	if payload.OverlapSeconds != nil {
+		overlap = time.Duration(*payload.OverlapSeconds) * time.Second
+	}
+	overlapUntil := now.Add(overlap)
+
+	rawKey, err := generateKey()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	key, err := h.store.RotateServiceAccountKey(account.ID, keyID, types.ServiceAccountKey{
+		Prefix:    rawKey[:keyDisplayPrefixLen],
+		ExpiresAt: payload.ExpiresAt,
+	}, utils.HashToken(rawKey), overlapUntil)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusNotFound, errors.New("key not found"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.recordAudit(r, "service_account.key_rotate", account.ID, map[string]any{
+		"keyId":        keyID,
+		"newKeyId":     key.ID,
+		"overlapUntil": overlapUntil,
+	})
+
+	utils.WriteJSON(w, http.StatusCreated, map[string]any{
+		"apiKey":             rawKey,
+		"key":                key,
+		"previousKeyExpires": overlapUntil,
+	})
+}
+
+func (h *Handler) handleRevokeKey(w http.ResponseWriter, r *http.Request) {
+	account, ok := h.loadAccount(w, r)
+	if !ok {
+		return
+	}
+
+	keyID, ok := parseKeyID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.RevokeServiceAccountKey(account.ID, keyID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusNotFound, errors.New("key not found"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.recordAudit(r, "service_account.key_revoke", account.ID, map[string]any{
+		"keyId": keyID,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "key revoked",
+	})
+}
+
+// ResolveAPIKey implements utils.APIKeyResolver.
+func (h *Handler) ResolveAPIKey(rawKey, ip string) (*utils.CustomClaims, error) {
+	key, err := h.store.GetServiceAccountKeyByHash(utils.HashToken(rawKey))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	account, err := h.store.GetServiceAccountByID(key.ServiceAccountID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	if account.DisabledAt != nil {
+		return nil, utils.ErrInvalidAPIKey
+	}
+
+	if !ipAllowed(account.IPAllowlist, ip) {
+		return nil, utils.ErrAPIKeyIPNotAllowed
+	}
+
+	if err := h.store.TouchServiceAccountKey(key.ID, ip); err != nil {
+		log.Printf("service account key %d: failed to record usage: %v", key.ID, err)
+	}
+
+	tenant := ""
+	if account.OrganizationID != nil {
+		tenant = strconv.Itoa(*account.OrganizationID)
+	}
+
+	return utils.NewServiceAccountClaims(account.ID, key.ID, key.CreatedAt, account.Scopes, tenant), nil
+}
+
+// loadAccount hides accounts of other organizations from everyone but
+// platform admins.
+func (h *Handler) loadAccount(w http.ResponseWriter, r *http.Request) (*types.ServiceAccount, bool) {
+	accountID, err := strconv.Atoi(mux.Vars(r)["accountId"])
+	if err != nil || accountID <= 0 {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("invalid service account id"))
+		return nil, false
+	}
+
+	orgID, allOrgs, err := utils.OrganizationScope(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusForbidden, err)
+		return nil, false
+	}
+
+	account, err := h.store.GetServiceAccountByID(accountID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusNotFound, errors.New("service account not found"))
+			return nil, false
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	if !allOrgs && (account.OrganizationID == nil || *account.OrganizationID != orgID) {
+		utils.WriteError(w, http.StatusNotFound, errors.New("service account not found"))
+		return nil, false
+	}
+
+	return account, true
+}
+
+func parseKeyID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	keyID, err := strconv.Atoi(mux.Vars(r)["keyId"])
+	if err != nil || keyID <= 0 {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("invalid key id"))
+		return 0, false
+	}
+	return keyID, true
+}
+
+func (h *Handler) recordAudit(r *http.Request, action string, accountID int, metadata map[string]any) {
+	audit.RecordRequest(h.audit, r, action, "service_account", strconv.Itoa(accountID), metadata)
+}
+
+func generateKey() (string, error) {
+	secret, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		return "", err
+	}
+	return utils.APIKeyPrefix + secret, nil
+}
+
+// grantableScopes de-duplicates scopes and refuses any the caller does not
+// hold themselves, so service accounts cannot be used to escalate.
+func grantableScopes(r *http.Request, scopes []string) ([]string, error) {
+	granted := []string{}
+	for _, scope := range scopes {
+		ok, err := utils.HasPermission(r, scope)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("scope %q is not granted to you", scope)
+		}
+		if !slices.Contains(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	slices.Sort(granted)
+	return granted, nil
+}
+
+// normalizeAllowlist accepts addresses and CIDR ranges and stores them all
+// as prefixes, e.g. "10.0.0.1" becomes "10.0.0.1/32".
+func normalizeAllowlist(entries []string) ([]string, error) {
+	allowlist := []string{}
+	for _, entry := range entries {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid ip allowlist entry %q", entry)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+
+		normalized := prefix.Masked().String()
+		if !slices.Contains(allowlist, normalized) {
+			allowlist = append(allowlist, normalized)
+		}
+	}
+	return allowlist, nil
+}
+
+// ipAllowed treats an empty allowlist as allowing every address.
+func ipAllowed(allowlist []string, ip string) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, entry := range allowlist {
+		prefix, err := netip.ParsePrefix(entry)
+		if err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
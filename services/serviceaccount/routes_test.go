@@ -0,0 +1,57 @@
+package serviceaccount
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNormalizeAllowlist(t *testing.T) {
+	got, err := normalizeAllowlist([]string{"10.0.0.1", "192.168.1.77/24", "2001:db8::1", "10.0.0.1/32", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.1/32", "192.168.1.0/24", "2001:db8::1/128", "2001:db8::/32"}
+	if !slices.Equal(got, want) {
+		t.Errorf("normalizeAllowlist = %v, want %v", got, want)
+	}
+
+	if got, err := normalizeAllowlist(nil); err != nil || got == nil || len(got) != 0 {
+		t.Errorf("normalizeAllowlist(nil) = %#v, %v; want an empty list", got, err)
+	}
+
+	for _, entry := range []string{"10.0.0.300", "example.com", "10.0.0.0/33", ""} {
+		if _, err := normalizeAllowlist([]string{entry}); err == nil {
+			t.Errorf("normalizeAllowlist accepted %q", entry)
+		}
+	}
+}
+
+func TestIPAllowed(t *testing.T) {
+	allowlist := []string{"10.0.0.0/8", "198.51.100.7/32", "2001:db8::/32"}
+
+	tests := map[string]bool{
+		"10.1.2.3":            true,
+		"198.51.100.7":        true,
+		"198.51.100.8":        false,
+		"::ffff:10.9.9.9":     true,
+		"2001:db8:1::5":       true,
+		"2001:db9::1":         false,
+		"not an ip":           false,
+		"":                    false,
+		"10.1.2.3:4444":       false,
+		"fe80::1%eth0":        false,
+		"::ffff:198.51.100.8": false,
+	}
+	for ip, want := range tests {
+		if got := ipAllowed(allowlist, ip); got != want {
+			t.Errorf("ipAllowed(%q) = %v, want %v", ip, got, want)
+		}
+	}
+
+	if !ipAllowed(nil, "203.0.113.1") {
+		t.Error("empty allowlist refused an address")
+	}
+	if !ipAllowed([]string{}, "not an ip") {
+		t.Error("empty allowlist refused a request")
+	}
+}
@@ -0,0 +1,323 @@
+package serviceaccount
+
+import (
+	"auth-api/types"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+const accountColumns = `id, name, description, org_id, created_by, scopes, ip_allowlist, disabled_at, created_at`
+
+func scanAccount(row rowScanner) (*types.ServiceAccount, error) {
+	var a types.ServiceAccount
+	err := row.Scan(
+		&a.ID,
+		&a.Name,
+		&a.Description,
+		&a.OrganizationID,
+		&a.CreatedBy,
+		pq.Array(&a.Scopes),
+		pq.Array(&a.IPAllowlist),
+		&a.DisabledAt,
+		&a.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Scopes == nil {
+		a.Scopes = []string{}
+	}
+	if a.IPAllowlist == nil {
+		a.IPAllowlist = []string{}
+	}
+
+	return &a, nil
+}
+
+func (s *Store) CreateServiceAccount(account types.ServiceAccount) (int, error) {
+	var id int
+	err := s.db.QueryRow(
+		`INSERT INTO service_accounts (name, description, org_id, created_by, scopes, ip_allowlist)
+         VALUES ($1, $2, $3, $4, $5, $6)
+         RETURNING id`,
+		account.Name,
+		account.Description,
+		account.OrganizationID,
+		account.CreatedBy,
+		pq.Array(account.Scopes),
+		pq.Array(account.IPAllowlist),
+	).Scan(&id)
+	return id, err
+}
+
+func (s *Store) GetServiceAccountByID(id int) (*types.ServiceAccount, error) {
+	row := s.db.QueryRow(
+		`SELECT `+accountColumns+`
+           FROM service_accounts
+          WHERE id = $1`,
+		id,
+	)
+
+	return scanAccount(row)
+}
+
+// ListServiceAccounts returns every account when orgID is nil, otherwise
+// only the accounts owned by that organization.
+func (s *Store) ListServiceAccounts(orgID *int) ([]types.ServiceAccount, error) {
+	rows, err := s.db.Query(
+		`SELECT `+accountColumns+`
+           FROM service_accounts
+          WHERE $1::BIGINT IS NULL OR org_id = $1
+          ORDER BY id`,
+		orgID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []types.ServiceAccount{}
+	for rows.Next() {
+		a, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, *a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+func (s *Store) UpdateServiceAccount(account types.ServiceAccount) error {
+	res, err := s.db.Exec(
+		`UPDATE service_accounts
+            SET description = $2,
+                scopes = $3,
+                ip_allowlist = $4,
+                disabled_at = $5
+          WHERE id = $1`,
+		account.ID,
+		account.Description,
+		pq.Array(account.Scopes),
+		pq.Array(account.IPAllowlist),
+		account.DisabledAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteServiceAccount(id int) error {
+	res, err := s.db.Exec(`DELETE FROM service_accounts WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+const keyColumns = `id, service_account_id, name, key_prefix, expires_at, last_used_at, last_used_ip, revoked_at, created_at`
+
+func scanKey(row rowScanner) (*types.ServiceAccountKey, error) {
+	var k types.ServiceAccountKey
+	err := row.Scan(
+		&k.ID,
+		&k.ServiceAccountID,
+		&k.Name,
+		&k.Prefix,
+		&k.ExpiresAt,
+		&k.LastUsedAt,
+		&k.LastUsedIP,
+		&k.RevokedAt,
+		&k.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &k, nil
+}
+
+func (s *Store) CreateServiceAccountKey(key types.ServiceAccountKey, keyHash string) (int, error) {
+	var id int
+	err := s.db.QueryRow(
+		`INSERT INTO service_account_keys (service_account_id, name, key_prefix, key_hash, expires_at)
+         VALUES ($1, $2, $3, $4, $5)
+         RETURNING id`,
+		key.ServiceAccountID,
+		key.Name,
+		key.Prefix,
+		keyHash,
+		key.ExpiresAt,
+	).Scan(&id)
+	return id, err
+}
+
+// ListServiceAccountKeys returns keys that have not been revoked, including
+// expired ones and keys still inside a rotation overlap.
+func (s *Store) ListServiceAccountKeys(accountID int) ([]types.ServiceAccountKey, error) {
+	rows, err := s.db.Query(
+		`SELECT `+keyColumns+`
+           FROM service_account_keys
+          WHERE service_account_id = $1 AND revoked_at IS NULL
+          ORDER BY id DESC`,
+		accountID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []types.ServiceAccountKey{}
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// GetServiceAccountKeyByHash only finds keys that are neither revoked nor
+// expired.
+func (s *Store) GetServiceAccountKeyByHash(keyHash string) (*types.ServiceAccountKey, error) {
+	row := s.db.QueryRow(
+		`SELECT `+keyColumns+`
+           FROM service_account_keys
+          WHERE key_hash = $1
+            AND revoked_at IS NULL
+            AND (expires_at IS NULL OR expires_at > NOW())`,
+		keyHash,
+	)
+
+	return scanKey(row)
+}
+
+// RotateServiceAccountKey issues replacement under the old key's name and
+// cuts the old key's lifetime down to overlapUntil. A key that already
+// expires sooner keeps its own expiry.
+func (s *Store) RotateServiceAccountKey(accountID, keyID int, replacement types.ServiceAccountKey, keyHash string, overlapUntil time.Time) (*types.ServiceAccountKey, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(
+		`UPDATE service_account_keys
+            SET expires_at = LEAST(COALESCE(expires_at, $3), $3)
+          WHERE id = $1
+            AND service_account_id = $2
+            AND revoked_at IS NULL
+            AND (expires_at IS NULL OR expires_at > NOW())
+          RETURNING name`,
+		keyID,
+		accountID,
+		overlapUntil,
+	).Scan(&replacement.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	replacement.ServiceAccountID = accountID
+	err = tx.QueryRow(
+		`INSERT INTO service_account_keys (service_account_id, name, key_prefix, key_hash, expires_at)
+         VALUES ($1, $2, $3, $4, $5)
+         RETURNING id, created_at`,
+		replacement.ServiceAccountID,
+		replacement.Name,
+		replacement.Prefix,
+		keyHash,
+		replacement.ExpiresAt,
+	).Scan(&replacement.ID, &replacement.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &replacement, nil
+}
+
+// TouchServiceAccountKey records usage at most once a minute per key unless
+// the caller's address changes.
+func (s *Store) TouchServiceAccountKey(id int, ip string) error {
+	_, err := s.db.Exec(
+		`UPDATE service_account_keys
+            SET last_used_at = NOW(),
+                last_used_ip = $2
+          WHERE id = $1
+            AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute' OR last_used_ip <> $2)`,
+		id,
+		ip,
+	)
+	return err
+}
+
+func (s *Store) RevokeServiceAccountKey(accountID, keyID int) error {
+	res, err := s.db.Exec(
+		`UPDATE service_account_keys
+            SET revoked_at = NOW()
+          WHERE id = $1 AND service_account_id = $2 AND revoked_at IS NULL`,
+		keyID,
+		accountID,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,448 @@
+package user
+
+import (
+	"auth-api/services/audit"
+	"auth-api/types"
+	"auth-api/utils"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func (h *Handler) handleAdminCreateUser(w http.ResponseWriter, r *http.Request) {
+	var payload types.AdminCreateUserPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if existing, err := h.store.GetUserByEmail(payload.Email); err == nil && existing != nil {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("email already exists"))
+		return
+	}
+
+	if existing, err := h.store.GetUserByUsername(payload.Username); err == nil && existing != nil {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("username already exists"))
+		return
+	}
+
+	hashed, err := utils.HashPassword(payload.Password)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	user := types.User{
+		Username:           payload.Username,
+		Email:              payload.Email,
+		Password:           hashed,
+		Role:               "user",
+		MustChangePassword: payload.MustChangePassword,
+	}
+	if payload.EmailVerified {
+		now := time.Now().UTC()
+		user.EmailVerifiedAt = &now
+	}
+
+	orgID, allOrgs, err := utils.OrganizationScope(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusForbidden, err)
+		return
+	}
+
+	// Accounts created from inside an organization join it, otherwise the
+	// creator could not see them afterwards.
+	var userID int
+	if allOrgs {
+		userID, err = h.store.CreateUser(user)
+	} else {
+		userID, err = h.store.CreateUserInOrganization(user, orgID, types.OrgRoleMember)
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.recordUserAudit(r, "user.create", userID, map[string]any{
+		"username": user.Username,
+		"email":    user.Email,
+	})
+
+	u, err := h.store.GetUserByID(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, userResponse(u))
+}
+
+func (h *Handler) handleAdminGetUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, userResponse(u))
+}
+
+func (h *Handler) handleAdminUpdateUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := h.checkTargetPrivileges(w, r, u, "update"); !ok {
+		return
+	}
+
+	var payload types.AdminUpdateUserPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	changes := map[string]any{}
+
+	if payload.Username != nil && *payload.Username != u.Username {
+		if existing, err := h.store.GetUserByUsername(*payload.Username); err == nil && existing.ID != u.ID {
+			utils.WriteError(w, http.StatusBadRequest, errors.New("username already exists"))
+			return
+		}
+		changes["username"] = map[string]string{"from": u.Username, "to": *payload.Username}
+		u.Username = *payload.Username
+	}
+
+	emailChanged := payload.Email != nil && *payload.Email != u.Email
+	if emailChanged {
+		if existing, err := h.store.GetUserByEmail(*payload.Email); err == nil && existing.ID != u.ID {
+			utils.WriteError(w, http.StatusBadRequest, errors.New("email already exists"))
+			return
+		}
+		changes["email"] = map[string]string{"from": u.Email, "to": *payload.Email}
+		u.Email = *payload.Email
+		u.EmailVerifiedAt = nil
+	}
+
+	accountChanged := len(changes) > 0
+
+	// Admins may set any attribute, including the admin-only ones users
+	// cannot change themselves.
+	if payload.Metadata != nil {
+		defs, err := h.store.ListUserAttributeDefinitions()
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		merged := mergeMetadata(defs, u.Metadata, payload.Metadata)
+		if err := validateMetadata(defs, payload.Metadata, merged, true); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		changes["metadata"] = payload.Metadata
+		u.Metadata = merged
+	}
+
+	if accountChanged {
+		if err := h.store.UpdateUserAccount(u.ID, u.Username, u.Email); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	// Access tokens claim the old address was verified; the user has to
+	// verify the new one before that holds again.
+	if emailChanged {
+		if err := h.store.RevokeAccessTokensForUser(u.ID); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		h.sendVerificationEmail(u)
+	}
+	if payload.Metadata != nil {
+		if err := h.store.UpdateProfile(u); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if len(changes) > 0 {
+		h.recordUserAudit(r, "user.update", u.ID, changes)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, userResponse(u))
+}
+
+func (h *Handler) handleAdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := utils.GetUserIDFromContext(r.Context())
+
+	u, ok := h.loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	if u.ID == adminID {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("cannot delete your own account here, use DELETE /me"))
+		return
+	}
+
+	// Record first so the event is anonymized along with the user's other
+	// references.
+	h.recordUserAudit(r, "user.delete", u.ID, map[string]any{
+		"username": u.Username,
+		"email":    u.Email,
+	})
+
+	if err := h.store.DeleteUser(u.ID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "user deleted",
+	})
+}
+
+// handleAdminResetPassword sets a temporary password that the user must
+// change on next login. A generated password is only returned once.
+func (h *Handler) handleAdminResetPassword(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := h.checkTargetPrivileges(w, r, u, "reset the password of"); !ok {
+		return
+	}
+
+	var payload types.AdminResetPasswordPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	password := payload.NewPassword
+	generated := password == ""
+	if generated {
+		var err error
+		password, err = utils.GenerateOpaqueToken(12)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	hashed, err := utils.HashPassword(password)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.store.SetTemporaryPassword(u.ID, hashed); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.forceLogout(u.ID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.recordUserAudit(r, "user.password_reset", u.ID, map[string]any{
+		"generated": generated,
+	})
+
+	resp := map[string]any{
+		"message": "password reset, the user must change it on next login",
+	}
+	if generated {
+		resp["temporaryPassword"] = password
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) handleAdminUpdateUserStatus(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := utils.GetUserIDFromContext(r.Context())
+
+	target, ok := h.loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+	userID := target.ID
+
+	if userID == adminID {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("cannot change your own status"))
+		return
+	}
+	if _, ok := h.checkTargetPrivileges(w, r, target, "change the status of"); !ok {
+		return
+	}
+
+	var payload types.UpdateUserStatusPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if payload.ExpiresAt != nil {
+		if payload.Status == types.UserStatusActive {
+			utils.WriteError(w, http.StatusBadRequest, errors.New("expiresAt cannot be set for active status"))
+			return
+		}
+		if !payload.ExpiresAt.After(time.Now().UTC()) {
+			utils.WriteError(w, http.StatusBadRequest, errors.New("expiresAt must be in the future"))
+			return
+		}
+	}
+
+	if err := h.store.UpdateUserStatus(userID, payload.Status, payload.Reason, payload.ExpiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusNotFound, errors.New("user not found"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.recordUserAudit(r, "user.status_change", userID, map[string]any{
+		"status":    payload.Status,
+		"reason":    payload.Reason,
+		"expiresAt": payload.ExpiresAt,
+	})
+
+	if payload.Status != types.UserStatusActive {
+		if err := h.forceLogout(userID); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	u, err := h.store.GetUserByID(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, userResponse(u))
+}
+
+func (h *Handler) handleAdminForceLogout(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+	userID := u.ID
+
+	if err := h.forceLogout(userID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.recordUserAudit(r, "user.force_logout", userID, nil)
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "all sessions and access tokens have been revoked",
+	})
+}
+
+// forceLogout revokes refresh tokens, personal access tokens and every
+// access token issued so far.
+func (h *Handler) forceLogout(userID int) error {
+	if err := h.store.RevokeAllRefreshTokensForUser(userID); err != nil {
+		return err
+	}
+	if err := h.store.RevokePersonalAccessTokensForUser(userID); err != nil {
+		return err
+	}
+	return h.store.RevokeAccessTokensForUser(userID)
+}
+
+func (h *Handler) loadTargetUser(w http.ResponseWriter, r *http.Request) (*types.User, bool) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || userID <= 0 {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("invalid user id"))
+		return nil, false
+	}
+
+	u, err := h.store.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusNotFound, errors.New("user not found"))
+			return nil, false
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	if !h.checkOrganizationScope(w, r, u.ID) {
+		return nil, false
+	}
+
+	return u, true
+}
+
+// checkTargetPrivileges refuses to act on admins and on users holding a
+// permission the caller lacks: taking over their account, by resetting
+// its password, changing its email or impersonating it, would hand the
+// caller that access. Callers acting on themselves gain nothing and pass.
+// It returns target's token options.
+func (h *Handler) checkTargetPrivileges(w http.ResponseWriter, r *http.Request, target *types.User, action string) (utils.AccessTokenOptions, bool) {
+	opts, err := h.accessTokenOptions(target)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return opts, false
+	}
+
+	if callerID, ok := utils.GetUserIDFromContext(r.Context()); ok && callerID == target.ID {
+		return opts, true
+	}
+
+	if slices.Contains(opts.Roles, "admin") || slices.ContainsFunc(opts.Permissions, func(perm string) bool {
+		return slices.Contains(adminPermissions, perm)
+	}) {
+		utils.WriteErrorCode(w, http.StatusForbidden, "insufficient_permissions", fmt.Errorf("cannot %s an admin", action))
+		return opts, false
+	}
+
+	for _, perm := range opts.Permissions {
+		held, err := utils.HasPermission(r, perm)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return opts, false
+		}
+		if !held {
+			utils.WriteErrorCode(w, http.StatusForbidden, "insufficient_permissions", fmt.Errorf("cannot %s a user with permission %q you do not hold", action, perm))
+			return opts, false
+		}
+	}
+
+	return opts, true
+}
+
+func (h *Handler) recordUserAudit(r *http.Request, action string, userID int, metadata map[string]any) {
+	h.recordAudit(r, action, "user", strconv.Itoa(userID), metadata)
+}
+
+func (h *Handler) recordAudit(r *http.Request, action, targetType, targetID string, metadata map[string]any) {
+	audit.RecordRequest(h.audit, r, action, targetType, targetID, metadata)
+}
@@ -0,0 +1,123 @@
+package user
+
+import (
+	"auth-api/configs"
+	"auth-api/types"
+	"auth-api/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestCheckTargetPrivileges(t *testing.T) {
+	withEnvs(t, func(c *configs.Config) { c.JWTSecret = "test-secret" })
+
+	const helpdesk = 1
+	h, _, _, _ := newTestHandler()
+	h.roles = &fakeRoleStore{
+		roles: map[int][]string{3: {"admin"}},
+		perms: map[int][]string{
+			helpdesk: {utils.PermUsersRead, utils.PermUsersWrite},
+			2:        {utils.PermUsersRead},
+			4:        {utils.PermRolesManage},
+			5:        {"billing:write"},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		target int
+		want   bool
+	}{
+		{"user with fewer permissions", 2, true},
+		{"user without permissions", 6, true},
+		{"admin role", 3, false},
+		{"admin permission", 4, false},
+		{"permission the caller lacks", 5, false},
+		{"the caller", helpdesk, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := utils.GenerateAccessToken(helpdesk, utils.AccessTokenOptions{
+				Permissions: []string{utils.PermUsersRead, utils.PermUsersWrite},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var ok bool
+			w := httptest.NewRecorder()
+			utils.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, ok = h.checkTargetPrivileges(w, r, &types.User{ID: tt.target}, "reset the password of")
+			})).ServeHTTP(w, authorized(httptest.NewRequest("POST", "/admin/users/1/password", nil), token))
+
+			if ok != tt.want {
+				t.Fatalf("checkTargetPrivileges = %v, want %v", ok, tt.want)
+			}
+			if !ok && (w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "insufficient_permissions")) {
+				t.Errorf("refusal = %d %s, want 403 insufficient_permissions", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func authorized(r *http.Request, token string) *http.Request {
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestAdminEmailChangeRequiresVerification(t *testing.T) {
+	withEnvs(t, func(c *configs.Config) {
+		c.JWTSecret = "test-secret"
+		c.AppURL = "https://app.example.com"
+	})
+
+	verified := time.Now().UTC()
+	h, store, _, mailer := newTestHandler()
+	h.roles = &fakeRoleStore{}
+	store.users = map[int]*types.User{
+		2: {ID: 2, Username: "bob", Email: "bob@example.com", EmailVerifiedAt: &verified},
+	}
+
+	router := mux.NewRouter()
+	router.Handle("/admin/users/{id}", utils.AuthMiddleware(http.HandlerFunc(h.handleAdminUpdateUser)))
+
+	token, err := utils.GenerateAccessToken(1, utils.AccessTokenOptions{
+		Permissions: []string{utils.PermPlatformAdmin, utils.PermUsersWrite},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	update := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, authorized(httptest.NewRequest("PATCH", "/admin/users/2", strings.NewReader(body)), token))
+		return w
+	}
+
+	if w := update(`{"username":"robert"}`); w.Code != http.StatusOK {
+		t.Fatalf("rename = %d %s", w.Code, w.Body)
+	}
+	if store.users[2].EmailVerifiedAt == nil || len(mailer.Messages()) != 0 || len(store.revoked) != 0 {
+		t.Fatal("a rename without an email change reset verification")
+	}
+
+	if w := update(`{"email":"attacker@example.net"}`); w.Code != http.StatusOK {
+		t.Fatalf("email change = %d %s", w.Code, w.Body)
+	}
+	if store.users[2].EmailVerifiedAt != nil {
+		t.Error("new email kept the old verification")
+	}
+	if len(store.revoked) != 1 || store.revoked[0] != 2 {
+		t.Errorf("revoked access tokens of %v, want [2]", store.revoked)
+	}
+	msg, ok := mailer.Last()
+	if !ok || msg.To != "attacker@example.net" || !strings.Contains(msg.Text, "/verify-email?token=") {
+		t.Errorf("verification email = %+v, %v", msg, ok)
+	}
+}
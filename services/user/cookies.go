@@ -0,0 +1,53 @@
+package user
+
+import (
+	"auth-api/configs"
+	"auth-api/types"
+	"auth-api/utils"
+	"net/http"
+	"time"
+)
+
+// writeTokenResponse adds the token pair to body. In cookie mode the
+// refresh token goes into its HttpOnly cookie instead, and a fresh CSRF
+// token is returned for the client to echo back in the CSRF header.
+func writeTokenResponse(w http.ResponseWriter, status int, body map[string]any, accessToken, refreshToken string) {
+	body["accessToken"] = accessToken
+
+	if !configs.Envs.CookieMode {
+		body["refreshToken"] = refreshToken
+		utils.WriteJSON(w, status, body)
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(utils.RefreshTokenTTL)
+	csrfToken, err := utils.SetCSRFCookie(w, expiresAt)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	utils.SetRefreshCookie(w, refreshToken, expiresAt)
+
+	body["csrfToken"] = csrfToken
+	utils.WriteJSON(w, status, body)
+}
+
+// readRefreshToken prefers the refresh cookie in cookie mode and otherwise
+// expects the token in the JSON body.
+func readRefreshToken(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if token, ok := utils.RefreshTokenFromCookie(r); ok {
+		return token, true
+	}
+
+	var payload types.RefreshPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return "", false
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return "", false
+	}
+
+	return payload.RefreshToken, true
+}
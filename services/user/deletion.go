@@ -0,0 +1,114 @@
+package user
+
+import (
+	"auth-api/configs"
+	"auth-api/types"
+	"auth-api/utils"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+func (h *Handler) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserIDFromContext(r.Context())
+	if !ok {
+		utils.WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	var payload types.DeleteAccountPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	u, err := h.store.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if u.IsDeleted() {
+		utils.WriteError(w, http.StatusConflict, errors.New("account is already scheduled for deletion"))
+		return
+	}
+
+	if !utils.CheckPassword(u.Password, payload.CurrentPassword) {
+		utils.WriteError(w, http.StatusUnauthorized, errors.New("invalid current password"))
+		return
+	}
+
+	purgeAfter := time.Now().UTC().Add(configs.Envs.AccountDeletionGracePeriod)
+	if err := h.store.SoftDeleteUser(u.ID, purgeAfter); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.store.RevokeAllRefreshTokensForUser(u.ID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.sendEmail(u, "account_deletion_scheduled", accountDeletionData{
+		Username:   u.Username,
+		PurgeAfter: purgeAfter,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message":    "account scheduled for deletion, log in before purgeAfter to restore it",
+		"purgeAfter": purgeAfter,
+	})
+}
+
+// StartPurgeWorker hard-deletes accounts whose deletion grace period has
+// ended and drops expired data exports, stale login lockouts and old login
+// history, checking every interval until the process exits. An interval
+// that is not positive falls back to an hour.
+func StartPurgeWorker(store types.UserStore, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for ; ; <-ticker.C {
+			if n, err := store.PurgeDeletedUsers(); err != nil {
+				log.Printf("purge worker: %v", err)
+			} else if n > 0 {
+				log.Printf("purge worker: purged %d deleted account(s)", n)
+			}
+
+			if n, err := store.DeleteExpiredDataExports(); err != nil {
+				log.Printf("purge worker: %v", err)
+			} else if n > 0 {
+				log.Printf("purge worker: removed %d expired export(s)", n)
+			}
+
+			if n, err := store.DeleteStaleLoginLockouts(configs.Envs.LoginFailureWindow); err != nil {
+				log.Printf("purge worker: %v", err)
+			} else if n > 0 {
+				log.Printf("purge worker: removed %d stale login lockout(s)", n)
+			}
+
+			if retention := configs.Envs.LoginHistoryRetention; retention > 0 {
+				if n, err := store.DeleteOldLoginAttempts(retention); err != nil {
+					log.Printf("purge worker: %v", err)
+				} else if n > 0 {
+					log.Printf("purge worker: removed %d old login attempt(s)", n)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,200 @@
+package user
+
+import (
+	"auth-api/configs"
+	"auth-api/types"
+	"auth-api/utils"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	emailChangeTTL          = 24 * time.Hour
+	emailChangeCancelWindow = 7 * 24 * time.Hour
+)
+
+func (h *Handler) handleRequestEmailChange(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserIDFromContext(r.Context())
+	if !ok {
+		utils.WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	var payload types.ChangeEmailPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	u, err := h.store.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !utils.CheckPassword(u.Password, payload.CurrentPassword) {
+		utils.WriteError(w, http.StatusUnauthorized, errors.New("invalid current password"))
+		return
+	}
+
+	if strings.EqualFold(u.Email, payload.NewEmail) {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("new email must differ from the current one"))
+		return
+	}
+
+	if existing, err := h.store.GetUserByEmail(payload.NewEmail); err == nil && existing != nil {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("email already exists"))
+		return
+	}
+
+	confirmToken, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	cancelToken, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	change := types.EmailChange{
+		UserID:           u.ID,
+		OldEmail:         u.Email,
+		NewEmail:         payload.NewEmail,
+		ConfirmTokenHash: utils.HashToken(confirmToken),
+		CancelTokenHash:  utils.HashToken(cancelToken),
+		ExpiresAt:        time.Now().UTC().Add(emailChangeTTL),
+	}
+	if err := h.store.CreateEmailChange(change); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.sendEmailTo(u, payload.NewEmail, "email_change_confirm", emailChangeData{
+		Username: u.Username,
+		NewEmail: payload.NewEmail,
+		Link:     configs.Envs.AppURL + "/confirm-email-change?token=" + url.QueryEscape(confirmToken),
+	})
+	h.sendEmailTo(u, u.Email, "email_change_notice", emailChangeData{
+		Username: u.Username,
+		NewEmail: payload.NewEmail,
+		Link:     configs.Envs.AppURL + "/cancel-email-change?token=" + url.QueryEscape(cancelToken),
+	})
+
+	utils.WriteJSON(w, http.StatusAccepted, map[string]any{
+		"message": "a confirmation link has been sent to the new address",
+	})
+}
+
+func (h *Handler) handleConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var payload types.EmailChangeTokenPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	change, err := h.store.GetEmailChangeByConfirmToken(utils.HashToken(payload.Token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusBadRequest, errors.New("invalid or expired token"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if change.ConfirmedAt != nil || change.CancelledAt != nil || time.Now().UTC().After(change.ExpiresAt) {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("invalid or expired token"))
+		return
+	}
+
+	if existing, err := h.store.GetUserByEmail(change.NewEmail); err == nil && existing.ID != change.UserID {
+		utils.WriteError(w, http.StatusConflict, errors.New("email already exists"))
+		return
+	}
+
+	if err := h.store.ConfirmEmailChange(change); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusBadRequest, errors.New("invalid or expired token"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if configs.Envs.EmailChangeRevokes {
+		if err := h.store.RevokeAllRefreshTokensForUser(change.UserID); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "email changed successfully",
+	})
+}
+
+// handleCancelEmailChange is reached from the notice sent to the old
+// address. It also reverts an already confirmed change, and always logs out
+// every session since the request may not have come from the owner.
+func (h *Handler) handleCancelEmailChange(w http.ResponseWriter, r *http.Request) {
+	var payload types.EmailChangeTokenPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	change, err := h.store.GetEmailChangeByCancelToken(utils.HashToken(payload.Token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusBadRequest, errors.New("invalid or expired token"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if change.CancelledAt != nil || time.Now().UTC().After(change.CreatedAt.Add(emailChangeCancelWindow)) {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("invalid or expired token"))
+		return
+	}
+
+	if err := h.store.CancelEmailChange(change); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusBadRequest, errors.New("invalid or expired token"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.store.RevokeAllRefreshTokensForUser(change.UserID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "email change cancelled, all sessions have been logged out",
+	})
+}
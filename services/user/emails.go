@@ -0,0 +1,79 @@
+package user
+
+import (
+	"auth-api/configs"
+	"auth-api/types"
+	"auth-api/utils"
+	"log"
+	"net/url"
+	"time"
+)
+
+const passwordResetTTL = 1 * time.Hour
+
+type emailData struct {
+	Username string
+	Link     string
+}
+
+type emailChangeData struct {
+	Username string
+	NewEmail string
+	Link     string
+}
+
+type accountDeletionData struct {
+	Username   string
+	PurgeAfter time.Time
+}
+
+func (h *Handler) sendVerificationEmail(u *types.User) {
+	token, err := utils.GenerateEmailVerificationToken(u.ID, u.Email)
+	if err != nil {
+		log.Printf("verification email: failed to generate token for user %d: %v", u.ID, err)
+		return
+	}
+
+	h.sendEmail(u, "verify_email", emailData{
+		Username: u.Username,
+		Link:     configs.Envs.AppURL + "/verify-email?token=" + url.QueryEscape(token),
+	})
+}
+
+func (h *Handler) sendPasswordResetEmail(u *types.User) {
+	token, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		log.Printf("password reset email: failed to generate token for user %d: %v", u.ID, err)
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(passwordResetTTL)
+	if err := h.store.CreatePasswordReset(u.ID, utils.HashToken(token), expiresAt); err != nil {
+		log.Printf("password reset email: failed to persist token for user %d: %v", u.ID, err)
+		return
+	}
+
+	h.sendEmail(u, "password_reset", emailData{
+		Username: u.Username,
+		Link:     configs.Envs.AppURL + "/reset-password?token=" + url.QueryEscape(token),
+	})
+}
+
+func (h *Handler) sendEmail(u *types.User, template string, data any) {
+	h.sendEmailTo(u, u.Email, template, data)
+}
+
+// sendEmailTo renders a template from utils/templates/mail and delivers it
+// on behalf of u. Failures are logged rather than surfaced to the caller.
+func (h *Handler) sendEmailTo(u *types.User, to, template string, data any) {
+	msg, err := utils.RenderMail(template, u.Locale, data)
+	if err != nil {
+		log.Printf("%s email: failed to render for user %d: %v", template, u.ID, err)
+		return
+	}
+	msg.To = to
+
+	if err := h.mailer.Send(msg); err != nil {
+		log.Printf("%s email: failed to send to user %d: %v", template, u.ID, err)
+	}
+}
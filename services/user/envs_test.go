@@ -0,0 +1,15 @@
+package user
+
+import (
+	"auth-api/configs"
+	"testing"
+)
+
+// withEnvs changes configs.Envs for the rest of the test.
+func withEnvs(t *testing.T, change func(*configs.Config)) {
+	t.Helper()
+
+	saved := configs.Envs
+	t.Cleanup(func() { configs.Envs = saved })
+	change(&configs.Envs)
+}
@@ -0,0 +1,238 @@
+package user
+
+import (
+	"archive/zip"
+	"auth-api/configs"
+	"auth-api/types"
+	"auth-api/utils"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func (h *Handler) handleExportMe(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserIDFromContext(r.Context())
+	if !ok {
+		utils.WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	h.exportUser(w, r, userID, userID)
+}
+
+func (h *Handler) handleAdminExportUser(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := utils.GetUserIDFromContext(r.Context())
+
+	u, ok := h.loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+	userID := u.ID
+
+	h.recordUserAudit(r, "user.export", userID, map[string]any{
+		"format": r.URL.Query().Get("format"),
+	})
+
+	h.exportUser(w, r, userID, adminID)
+}
+
+// exportUser streams the bundle directly for small accounts and falls back to
+// a background job, polled through the exports endpoints, for large ones.
+func (h *Handler) exportUser(w http.ResponseWriter, r *http.Request, userID, requestedBy int) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "zip" {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("format must be json or zip"))
+		return
+	}
+
+	count, err := h.store.CountUserRecords(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if count <= configs.Envs.ExportSyncMaxRecords && r.URL.Query().Get("async") != "true" {
+		data, err := h.buildExport(userID, format)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeExport(w, userID, format, data)
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(configs.Envs.ExportRetention)
+	exportID, err := h.store.CreateDataExport(userID, requestedBy, format, expiresAt)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	go h.runExport(exportID, userID, format)
+
+	utils.WriteJSON(w, http.StatusAccepted, map[string]any{
+		"exportId": exportID,
+		"status":   types.DataExportPending,
+	})
+}
+
+func (h *Handler) runExport(exportID, userID int, format string) {
+	data, err := h.buildExport(userID, format)
+	if err != nil {
+		log.Printf("data export %d: %v", exportID, err)
+		if err := h.store.FailDataExport(exportID, "export failed"); err != nil {
+			log.Printf("data export %d: failed to record failure: %v", exportID, err)
+		}
+		return
+	}
+
+	if err := h.store.CompleteDataExport(exportID, data); err != nil {
+		log.Printf("data export %d: failed to store result: %v", exportID, err)
+	}
+}
+
+func (h *Handler) buildExport(userID int, format string) ([]byte, error) {
+	export, err := h.store.GetUserDataExport(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == "json" {
+		return json.MarshalIndent(export, "", "  ")
+	}
+
+	sections := []struct {
+		name string
+		v    any
+	}{
+		{"profile.json", export.Profile},
+		{"sessions.json", export.Sessions},
+		{"device_sessions.json", export.DeviceSessions},
+		{"personal_access_tokens.json", export.PersonalAccessTokens},
+		{"organizations.json", export.Organizations},
+		{"groups.json", export.Groups},
+		{"login_history.json", export.LoginHistory},
+		{"lockouts.json", export.Lockouts},
+		{"email_changes.json", export.EmailChanges},
+		{"password_resets.json", export.PasswordResets},
+		{"audit_events.json", export.AuditEvents},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, s := range sections {
+		f, err := zw.Create(s.name)
+		if err != nil {
+			return nil, err
+		}
+		b, err := json.MarshalIndent(s.v, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write(b); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (h *Handler) handleGetMyExport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserIDFromContext(r.Context())
+	if !ok {
+		utils.WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	export, ok := h.loadExport(w, r)
+	if !ok {
+		return
+	}
+
+	if export.UserID != userID {
+		utils.WriteError(w, http.StatusNotFound, errors.New("export not found"))
+		return
+	}
+
+	serveExport(w, r, export)
+}
+
+func (h *Handler) handleAdminGetExport(w http.ResponseWriter, r *http.Request) {
+	export, ok := h.loadExport(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.checkOrganizationScope(w, r, export.UserID) {
+		return
+	}
+
+	serveExport(w, r, export)
+}
+
+func (h *Handler) loadExport(w http.ResponseWriter, r *http.Request) (*types.DataExport, bool) {
+	exportID, err := strconv.Atoi(mux.Vars(r)["exportId"])
+	if err != nil || exportID <= 0 {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("invalid export id"))
+		return nil, false
+	}
+
+	export, err := h.store.GetDataExport(exportID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusNotFound, errors.New("export not found"))
+			return nil, false
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	if time.Now().UTC().After(export.ExpiresAt) {
+		utils.WriteError(w, http.StatusNotFound, errors.New("export not found"))
+		return nil, false
+	}
+
+	return export, true
+}
+
+// serveExport returns the job status, or the bundle itself when called with
+// ?download=true on a finished export.
+func serveExport(w http.ResponseWriter, r *http.Request, export *types.DataExport) {
+	if r.URL.Query().Get("download") != "true" {
+		utils.WriteJSON(w, http.StatusOK, export)
+		return
+	}
+
+	if export.Status != types.DataExportReady {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("export is %s", export.Status))
+		return
+	}
+
+	writeExport(w, export.UserID, export.Format, export.Data)
+}
+
+func writeExport(w http.ResponseWriter, userID int, format string, data []byte) {
+	contentType := "application/json"
+	if format == "zip" {
+		contentType = "application/zip"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-export.%s"`, userID, format))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
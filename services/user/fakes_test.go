@@ -0,0 +1,243 @@
+package user
+
+import (
+	"auth-api/types"
+	"auth-api/utils"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// fakeStore keeps login lockouts and login attempts in memory. Methods the
+// tests do not reach fall through to the nil embedded store and panic.
+type fakeStore struct {
+	types.UserStore
+
+	mu          sync.Mutex
+	nextID      int
+	lockouts    map[string]*fakeLockout
+	attempts    []types.LoginAttempt
+	familiarity types.LoginFamiliarity
+	users       map[int]*types.User
+	revoked     []int
+}
+
+type fakeLockout struct {
+	types.LoginLockout
+	unlockTokenHash string
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{lockouts: map[string]*fakeLockout{}}
+}
+
+func (s *fakeStore) GetUserByID(id int) (*types.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *u
+	return &copied, nil
+}
+
+func (s *fakeStore) GetUserByEmail(email string) (*types.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Email == email {
+			copied := *u
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeStore) GetUserByUsername(username string) (*types.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Username == username {
+			copied := *u
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeStore) UpdateUserAccount(userID int, username, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if u.Email != email {
+		u.EmailVerifiedAt = nil
+	}
+	u.Username, u.Email = username, email
+	return nil
+}
+
+func (s *fakeStore) RevokeAccessTokensForUser(userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revoked = append(s.revoked, userID)
+	return nil
+}
+
+func (s *fakeStore) GetLoginLockout(kind, key string) (*types.LoginLockout, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.lockouts[kind+":"+key]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	lockout := l.LoginLockout
+	return &lockout, nil
+}
+
+func (s *fakeStore) RecordLoginFailure(kind, key string, userID *int, ip string, window time.Duration) (*types.LoginLockout, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.lockouts[kind+":"+key]
+	if !ok {
+		s.nextID++
+		l = &fakeLockout{LoginLockout: types.LoginLockout{ID: s.nextID, Kind: kind, Key: key}}
+		s.lockouts[kind+":"+key] = l
+	}
+	if userID != nil {
+		l.UserID = userID
+	}
+	l.FailedCount++
+	l.LastFailedAt = time.Now().UTC()
+	l.LastFailedIP = ip
+
+	lockout := l.LoginLockout
+	return &lockout, nil
+}
+
+func (s *fakeStore) SetLoginLockoutDeadlines(id int, retryAfter, lockedUntil *time.Time, unlockTokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, l := range s.lockouts {
+		if l.ID == id {
+			l.RetryAfter = retryAfter
+			l.LockedUntil = lockedUntil
+			if unlockTokenHash != "" {
+				l.unlockTokenHash = unlockTokenHash
+			}
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (s *fakeStore) ConsumeUnlockToken(tokenHash string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	for k, l := range s.lockouts {
+		if l.unlockTokenHash == tokenHash && l.Locked(now) && l.UserID != nil {
+			delete(s.lockouts, k)
+			return *l.UserID, nil
+		}
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (s *fakeStore) ClearLoginLockoutsForUser(userID int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cleared := 0
+	for k, l := range s.lockouts {
+		if l.UserID != nil && *l.UserID == userID {
+			delete(s.lockouts, k)
+			cleared++
+		}
+	}
+	return cleared, nil
+}
+
+func (s *fakeStore) RecordLoginAttempt(attempt types.LoginAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attempts = append(s.attempts, attempt)
+	return nil
+}
+
+func (s *fakeStore) GetLoginFamiliarity(userID int, deviceName, network string) (*types.LoginFamiliarity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	familiarity := s.familiarity
+	return &familiarity, nil
+}
+
+type fakeAuditStore struct {
+	mu     sync.Mutex
+	events []types.AuditEvent
+}
+
+func (s *fakeAuditStore) RecordEvent(event types.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *fakeAuditStore) ListEvents(filter types.AuditEventFilter) ([]types.AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]types.AuditEvent(nil), s.events...), nil
+}
+
+func (s *fakeAuditStore) actions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []string
+	for _, e := range s.events {
+		out = append(out, e.Action)
+	}
+	return out
+}
+
+func newTestHandler() (*Handler, *fakeStore, *fakeAuditStore, *utils.MemoryMailer) {
+	store, audit, mailer := newFakeStore(), &fakeAuditStore{}, utils.NewMemoryMailer()
+	return &Handler{store: store, audit: audit, mailer: mailer}, store, audit, mailer
+}
+
+// fakeRoleStore hands out fixed roles and permissions per user.
+type fakeRoleStore struct {
+	types.RoleStore
+
+	roles map[int][]string
+	perms map[int][]string
+}
+
+func (s *fakeRoleStore) EffectiveUserRoles(userID int) ([]types.Role, error) {
+	roles := []types.Role{}
+	for _, name := range s.roles[userID] {
+		roles = append(roles, types.Role{Name: name})
+	}
+	return roles, nil
+}
+
+func (s *fakeRoleStore) UserPermissions(userID int) ([]string, error) {
+	return s.perms[userID], nil
+}
@@ -0,0 +1,77 @@
+package user
+
+import (
+	"auth-api/configs"
+	"auth-api/types"
+	"auth-api/utils"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// adminPermissions mark a user as an admin. Other admins may not
+// impersonate them or take over their account; see checkTargetPrivileges.
+var adminPermissions = []string{
+	utils.PermPlatformAdmin,
+	utils.PermRolesManage,
+	utils.PermUsersImpersonate,
+}
+
+// handleAdminImpersonateUser issues a short-lived access token for the
+// target user with the caller in its act claim. Every request made with it
+// is audited, and credential changes are refused by RejectDelegatedAccess.
+func (h *Handler) handleAdminImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := utils.GetUserIDFromContext(r.Context())
+
+	target, ok := h.loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	var payload types.ImpersonateUserPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if target.ID == adminID {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("cannot impersonate yourself"))
+		return
+	}
+
+	if target.IsDeleted() || target.CurrentStatus() != types.UserStatusActive {
+		utils.WriteError(w, http.StatusConflict, errors.New("only active users can be impersonated"))
+		return
+	}
+
+	// The token carries the target's permissions, so it must not grant the
+	// caller anything they do not already hold.
+	opts, ok := h.checkTargetPrivileges(w, r, target, "impersonate")
+	if !ok {
+		return
+	}
+
+	ttl := configs.Envs.ImpersonationTokenTTL
+	expiresAt := time.Now().UTC().Add(ttl)
+
+	accessToken, err := utils.GenerateImpersonationToken(target.ID, adminID, ttl, opts)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.recordUserAudit(r, "user.impersonation_start", target.ID, map[string]any{
+		"reason":    payload.Reason,
+		"expiresAt": expiresAt,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"accessToken": accessToken,
+		"expiresAt":   expiresAt,
+		"user":        userResponse(target),
+	})
+}
@@ -0,0 +1,104 @@
+package user
+
+import (
+	"auth-api/types"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultUserListLimit = 50
+	maxUserListLimit     = 200
+)
+
+// pagingRequested reports whether the query asks for a page. GET /users
+// answered with a bare array of every user before paging existed, and
+// keeps doing so for clients that don't ask for one.
+func pagingRequested(r *http.Request) bool {
+	q := r.URL.Query()
+	return q.Has("limit") || q.Has("offset") || q.Has("cursor")
+}
+
+// parseUserListParams reads the GET /users query string:
+//
+//	limit, offset, cursor  paging (cursor takes precedence over offset)
+//	role, status, verified filters
+//	createdAfter, createdBefore  RFC 3339 timestamps
+//	q      case-insensitive search on username and email
+//	sort   id, username, email or createdAt; prefix with "-" for descending
+func parseUserListParams(r *http.Request) (types.UserListParams, error) {
+	q := r.URL.Query()
+
+	params := types.UserListParams{
+		Limit:  defaultUserListLimit,
+		Cursor: q.Get("cursor"),
+		Role:   q.Get("role"),
+		Status: q.Get("status"),
+		Search: strings.TrimSpace(q.Get("q")),
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxUserListLimit {
+			return params, fmt.Errorf("limit must be between 1 and %d", maxUserListLimit)
+		}
+		params.Limit = limit
+	}
+
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return params, errors.New("invalid offset")
+		}
+		params.Offset = offset
+	}
+
+	switch params.Status {
+	case "", types.UserStatusActive, types.UserStatusSuspended, types.UserStatusDisabled, types.UserStatusLocked:
+	default:
+		return params, errors.New("invalid status")
+	}
+
+	if v := q.Get("verified"); v != "" {
+		verified, err := strconv.ParseBool(v)
+		if err != nil {
+			return params, errors.New("verified must be true or false")
+		}
+		params.Verified = &verified
+	}
+
+	for _, f := range []struct {
+		name string
+		dst  **time.Time
+	}{
+		{"createdAfter", &params.CreatedAfter},
+		{"createdBefore", &params.CreatedBefore},
+	} {
+		v := q.Get(f.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return params, fmt.Errorf("%s must be an RFC 3339 timestamp", f.name)
+		}
+		*f.dst = &t
+	}
+
+	if sort := q.Get("sort"); sort != "" {
+		params.Desc = strings.HasPrefix(sort, "-")
+		params.Sort = strings.TrimPrefix(sort, "-")
+
+		switch params.Sort {
+		case types.UserSortID, types.UserSortUsername, types.UserSortEmail, types.UserSortCreatedAt:
+		default:
+			return params, errors.New("sort must be one of id, username, email, createdAt")
+		}
+	}
+
+	return params, nil
+}
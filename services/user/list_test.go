@@ -0,0 +1,21 @@
+package user
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPagingRequested(t *testing.T) {
+	tests := map[string]bool{
+		"/users":                     false,
+		"/users?role=admin&sort=-id": false,
+		"/users?limit=10":            true,
+		"/users?offset=0":            true,
+		"/users?cursor=abc&q=ann":    true,
+	}
+	for target, want := range tests {
+		if got := pagingRequested(httptest.NewRequest("GET", target, nil)); got != want {
+			t.Errorf("pagingRequested(%q) = %v, want %v", target, got, want)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package user
+
+import (
+	"auth-api/configs"
+	"auth-api/types"
+	"auth-api/utils"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type accountLockedData struct {
+	Username    string
+	Link        string
+	LockedUntil time.Time
+}
+
+func normalizeIdentifier(identifier string) string {
+	return strings.ToLower(strings.TrimSpace(identifier))
+}
+
+// checkLoginLockout answers the login itself while the counter for kind and
+// key is backing off or locked, and returns the outcome for the login
+// history. Such attempts are not checked against the password and are not
+// counted.
+func (h *Handler) checkLoginLockout(w http.ResponseWriter, kind, key string) (string, bool) {
+	lockout, err := h.store.GetLoginLockout(kind, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", true
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return types.LoginOutcomeError, false
+	}
+
+	now := time.Now().UTC()
+	switch {
+	case lockout.Locked(now):
+		setRetryAfter(w, *lockout.LockedUntil, now)
+		utils.WriteErrorCode(w, http.StatusLocked, "account_locked", errors.New("too many failed logins, try again later"))
+		return types.LoginOutcomeLocked, false
+	case lockout.RetryAfter != nil && lockout.RetryAfter.After(now):
+		setRetryAfter(w, *lockout.RetryAfter, now)
+		utils.WriteErrorCode(w, http.StatusTooManyRequests, "login_throttled", errors.New("too many failed logins, try again shortly"))
+		return types.LoginOutcomeThrottled, false
+	default:
+		return "", true
+	}
+}
+
+// recordLoginFailure counts a failed login twice: against the identifier
+// as typed, which throttles guessing whether or not an account exists, and
+// against the account u, which catches guesses spread over its email and
+// username. u is nil when the identifier matches no account.
+func (h *Handler) recordLoginFailure(r *http.Request, u *types.User, identifier string) {
+	var userID *int
+	if u != nil {
+		userID = &u.ID
+	}
+
+	h.countLoginFailure(r, types.LockoutKindIdentifier, identifier, userID, nil)
+	if u != nil {
+		h.countLoginFailure(r, types.LockoutKindAccount, strconv.Itoa(u.ID), userID, u)
+	}
+}
+
+// countLoginFailure sets the backoff for the new failure count and locks
+// the counter once it reaches LOGIN_LOCKOUT_THRESHOLD. When owner is set
+// they are emailed a link that lifts the lockout early.
+func (h *Handler) countLoginFailure(r *http.Request, kind, key string, userID *int, owner *types.User) {
+	lockout, err := h.store.RecordLoginFailure(kind, key, userID, utils.ClientIP(r), configs.Envs.LoginFailureWindow)
+	if err != nil {
+		log.Printf("login lockout: failed to record %s failure: %v", kind, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	var retryAfter *time.Time
+	if delay := loginBackoff(lockout.FailedCount); delay > 0 {
+		t := now.Add(delay)
+		retryAfter = &t
+	}
+
+	threshold := configs.Envs.LoginLockoutThreshold
+	if threshold <= 0 || lockout.FailedCount < threshold || lockout.Locked(now) {
+		if err := h.store.SetLoginLockoutDeadlines(lockout.ID, retryAfter, lockout.LockedUntil, ""); err != nil {
+			log.Printf("login lockout: failed to set backoff: %v", err)
+		}
+		return
+	}
+
+	lockedUntil := now.Add(configs.Envs.LoginLockoutDuration)
+	var unlockToken, unlockTokenHash string
+	if owner != nil {
+		if unlockToken, err = utils.GenerateOpaqueToken(32); err != nil {
+			log.Printf("login lockout: failed to generate unlock token for user %d: %v", owner.ID, err)
+		} else {
+			unlockTokenHash = utils.HashToken(unlockToken)
+		}
+	}
+
+	if err := h.store.SetLoginLockoutDeadlines(lockout.ID, retryAfter, &lockedUntil, unlockTokenHash); err != nil {
+		log.Printf("login lockout: failed to lock %s: %v", kind, err)
+		return
+	}
+
+	metadata := map[string]any{
+		"failedAttempts": lockout.FailedCount,
+		"lockedUntil":    lockedUntil,
+	}
+	if owner == nil {
+		h.recordAudit(r, "login.identifier_locked_out", "login_identifier", key, metadata)
+		return
+	}
+	h.recordUserAudit(r, "user.locked_out", owner.ID, metadata)
+
+	if unlockToken != "" {
+		h.sendEmail(owner, "account_locked", accountLockedData{
+			Username:    owner.Username,
+			Link:        configs.Envs.AppURL + "/unlock-account?token=" + url.QueryEscape(unlockToken),
+			LockedUntil: lockedUntil,
+		})
+	}
+}
+
+// clearLoginFailures forgets earlier failures after a correct password.
+func (h *Handler) clearLoginFailures(u *types.User, identifier string) {
+	if err := h.store.ClearLoginLockout(types.LockoutKindIdentifier, identifier); err != nil {
+		log.Printf("login lockout: failed to clear identifier: %v", err)
+	}
+	if err := h.store.ClearLoginLockout(types.LockoutKindAccount, strconv.Itoa(u.ID)); err != nil {
+		log.Printf("login lockout: failed to clear user %d: %v", u.ID, err)
+	}
+}
+
+// loginBackoff is the wait after the given number of failures in a row:
+// none for the first LOGIN_BACKOFF_AFTER, then LOGIN_BACKOFF_BASE doubling
+// with each failure up to LOGIN_BACKOFF_MAX.
+func loginBackoff(failures int) time.Duration {
+	delay, limit := configs.Envs.LoginBackoffBase, configs.Envs.LoginBackoffMax
+	if delay <= 0 || failures <= configs.Envs.LoginBackoffAfter {
+		return 0
+	}
+
+	for i := configs.Envs.LoginBackoffAfter + 1; i < failures && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
+
+func setRetryAfter(w http.ResponseWriter, until, now time.Time) {
+	seconds := int(until.Sub(now).Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// handleUnlockAccount redeems the link from the lockout email. It clears
+// every counter tied to the account, so the owner can sign in at once.
+func (h *Handler) handleUnlockAccount(w http.ResponseWriter, r *http.Request) {
+	var payload types.UnlockAccountPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	userID, err := h.store.ConsumeUnlockToken(utils.HashToken(payload.Token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusBadRequest, errors.New("invalid or expired unlock token"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if _, err := h.store.ClearLoginLockoutsForUser(userID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.recordUserAudit(r, "user.unlocked", userID, map[string]any{"via": "email"})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "account unlocked",
+	})
+}
+
+// handleAdminListLockouts lists failure counters, or with ?locked=true
+// only the ones currently locked. Identifier counters are not tied to an
+// organization, so the list is limited to platform-wide admins.
+func (h *Handler) handleAdminListLockouts(w http.ResponseWriter, r *http.Request) {
+	if !requirePlatformScope(w, r) {
+		return
+	}
+
+	lockedOnly, _ := strconv.ParseBool(r.URL.Query().Get("locked"))
+	lockouts, err := h.store.ListLoginLockouts(lockedOnly)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"lockouts": lockouts,
+	})
+}
+
+func (h *Handler) handleAdminDeleteLockout(w http.ResponseWriter, r *http.Request) {
+	if !requirePlatformScope(w, r) {
+		return
+	}
+
+	lockoutID, err := strconv.Atoi(mux.Vars(r)["lockoutId"])
+	if err != nil || lockoutID <= 0 {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("invalid lockout id"))
+		return
+	}
+
+	lockout, err := h.store.DeleteLoginLockout(lockoutID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusNotFound, errors.New("lockout not found"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.recordAudit(r, "login.lockout_cleared", "login_lockout", strconv.Itoa(lockout.ID), map[string]any{
+		"kind": lockout.Kind,
+		"key":  lockout.Key,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "lockout cleared",
+	})
+}
+
+func (h *Handler) handleAdminGetUserLockouts(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	lockouts, err := h.store.ListLoginLockoutsForUser(u.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	locked := false
+	for _, lockout := range lockouts {
+		if lockout.Locked(now) {
+			locked = true
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"locked":   locked,
+		"lockouts": lockouts,
+	})
+}
+
+func (h *Handler) handleAdminClearUserLockouts(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	cleared, err := h.store.ClearLoginLockoutsForUser(u.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.recordUserAudit(r, "user.unlocked", u.ID, map[string]any{"via": "admin"})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "lockouts cleared",
+		"cleared": cleared,
+	})
+}
+
+func requirePlatformScope(w http.ResponseWriter, r *http.Request) bool {
+	_, allOrgs, err := utils.OrganizationScope(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusForbidden, err)
+		return false
+	}
+	if !allOrgs {
+		utils.WriteError(w, http.StatusForbidden, errors.New("lockouts can only be managed platform-wide"))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,181 @@
+package user
+
+import (
+	"auth-api/configs"
+	"auth-api/types"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	withEnvs(t, func(c *configs.Config) {
+		c.LoginBackoffAfter = 3
+		c.LoginBackoffBase = time.Second
+		c.LoginBackoffMax = 10 * time.Second
+	})
+
+	tests := map[int]time.Duration{
+		0:  0,
+		3:  0,
+		4:  time.Second,
+		5:  2 * time.Second,
+		6:  4 * time.Second,
+		7:  8 * time.Second,
+		8:  10 * time.Second,
+		50: 10 * time.Second,
+	}
+	for failures, want := range tests {
+		if got := loginBackoff(failures); got != want {
+			t.Errorf("loginBackoff(%d) = %s, want %s", failures, got, want)
+		}
+	}
+}
+
+func TestLoginBackoffDisabled(t *testing.T) {
+	withEnvs(t, func(c *configs.Config) {
+		c.LoginBackoffAfter = 0
+		c.LoginBackoffBase = 0
+		c.LoginBackoffMax = time.Minute
+	})
+
+	if got := loginBackoff(100); got != 0 {
+		t.Errorf("loginBackoff(100) = %s with LOGIN_BACKOFF_BASE=0, want 0", got)
+	}
+}
+
+var unlockLink = regexp.MustCompile(`https://app\.example\.com/unlock-account\?token=(\S+)`)
+
+func TestLockoutEmailAndUnlock(t *testing.T) {
+	withEnvs(t, func(c *configs.Config) {
+		c.AppURL = "https://app.example.com"
+		c.LoginBackoffBase = 0
+		c.LoginLockoutThreshold = 3
+		c.LoginLockoutDuration = 15 * time.Minute
+		c.LoginFailureWindow = time.Hour
+	})
+
+	h, _, audit, mailer := newTestHandler()
+	u := &types.User{ID: 7, Username: "alice", Email: "alice@example.com"}
+	r := httptest.NewRequest("POST", "/login", nil)
+
+	for range 2 {
+		h.recordLoginFailure(r, u, "alice")
+	}
+	if n := len(mailer.Messages()); n != 0 {
+		t.Fatalf("%d emails sent below the threshold", n)
+	}
+	if _, ok := h.checkLoginLockout(httptest.NewRecorder(), types.LockoutKindAccount, "7"); !ok {
+		t.Fatal("account locked below the threshold")
+	}
+
+	h.recordLoginFailure(r, u, "alice")
+
+	msgs := mailer.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("%d emails sent at the threshold, want 1", len(msgs))
+	}
+	if msgs[0].To != "alice@example.com" {
+		t.Errorf("lockout email sent to %q", msgs[0].To)
+	}
+	match := unlockLink.FindStringSubmatch(msgs[0].Text)
+	if match == nil {
+		t.Fatalf("lockout email has no unlock link:\n%s", msgs[0].Text)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(audit.actions(), "user.locked_out") {
+		t.Errorf("audit actions = %v, want user.locked_out", audit.actions())
+	}
+
+	w := httptest.NewRecorder()
+	outcome, ok := h.checkLoginLockout(w, types.LockoutKindAccount, "7")
+	if ok || outcome != types.LoginOutcomeLocked || w.Code != http.StatusLocked {
+		t.Fatalf("checkLoginLockout = %q, %v, %d; want locked", outcome, ok, w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("locked response has no Retry-After")
+	}
+
+	w = unlock(h, token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unlock = %d %s", w.Code, w.Body)
+	}
+	for _, kind := range []string{types.LockoutKindAccount, types.LockoutKindIdentifier} {
+		key := "7"
+		if kind == types.LockoutKindIdentifier {
+			key = "alice"
+		}
+		if _, ok := h.checkLoginLockout(httptest.NewRecorder(), kind, key); !ok {
+			t.Errorf("%s counter still blocks login after unlock", kind)
+		}
+	}
+
+	if w := unlock(h, token); w.Code != http.StatusBadRequest {
+		t.Errorf("reusing the unlock token = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestUnknownIdentifierLockoutSendsNoEmail(t *testing.T) {
+	withEnvs(t, func(c *configs.Config) {
+		c.LoginBackoffBase = 0
+		c.LoginLockoutThreshold = 2
+		c.LoginLockoutDuration = 15 * time.Minute
+	})
+
+	h, _, audit, mailer := newTestHandler()
+	r := httptest.NewRequest("POST", "/login", nil)
+
+	for range 2 {
+		h.recordLoginFailure(r, nil, "nobody")
+	}
+
+	if n := len(mailer.Messages()); n != 0 {
+		t.Errorf("%d emails sent for an unknown identifier", n)
+	}
+	if _, ok := h.checkLoginLockout(httptest.NewRecorder(), types.LockoutKindIdentifier, "nobody"); ok {
+		t.Error("identifier not locked at the threshold")
+	}
+	if !slices.Contains(audit.actions(), "login.identifier_locked_out") {
+		t.Errorf("audit actions = %v, want login.identifier_locked_out", audit.actions())
+	}
+}
+
+func TestLoginBackoffThrottles(t *testing.T) {
+	withEnvs(t, func(c *configs.Config) {
+		c.LoginBackoffAfter = 1
+		c.LoginBackoffBase = time.Minute
+		c.LoginBackoffMax = time.Hour
+		c.LoginLockoutThreshold = 10
+	})
+
+	h, _, _, _ := newTestHandler()
+	r := httptest.NewRequest("POST", "/login", nil)
+
+	h.recordLoginFailure(r, nil, "bob")
+	if _, ok := h.checkLoginLockout(httptest.NewRecorder(), types.LockoutKindIdentifier, "bob"); !ok {
+		t.Fatal("throttled after the first failure")
+	}
+
+	h.recordLoginFailure(r, nil, "bob")
+	w := httptest.NewRecorder()
+	outcome, ok := h.checkLoginLockout(w, types.LockoutKindIdentifier, "bob")
+	if ok || outcome != types.LoginOutcomeThrottled || w.Code != http.StatusTooManyRequests {
+		t.Errorf("checkLoginLockout = %q, %v, %d; want throttled", outcome, ok, w.Code)
+	}
+}
+
+func unlock(h *Handler, token string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(types.UnlockAccountPayload{Token: token})
+	w := httptest.NewRecorder()
+	h.handleUnlockAccount(w, httptest.NewRequest("POST", "/unlock-account", bytes.NewReader(body)))
+	return w
+}
@@ -0,0 +1,152 @@
+package user
+
+import (
+	"auth-api/configs"
+	"auth-api/types"
+	"auth-api/utils"
+	"errors"
+	"log"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+type newDeviceLoginData struct {
+	Username   string
+	DeviceName string
+	IP         string
+	LoginAt    time.Time
+}
+
+// recordLoginHistory adds an attempt to the history. u is nil when the
+// identifier matched no account or was not looked up yet; the identifier
+// is then not kept, since it is often a password typed into the wrong
+// field.
+func (h *Handler) recordLoginHistory(r *http.Request, u *types.User, identifier, method, outcome string) {
+	h.saveLoginAttempt(newLoginAttempt(r, u, identifier, method, outcome))
+}
+
+// recordSuccessfulLogin adds a successful login to the history and emails
+// the user when its device or network is not among their earlier logins.
+// Nobody is alerted on their first recorded login, since there is nothing
+// to compare it with.
+func (h *Handler) recordSuccessfulLogin(r *http.Request, u *types.User, identifier, method string) {
+	attempt := newLoginAttempt(r, u, identifier, method, types.LoginOutcomeSuccess)
+
+	familiarity, err := h.store.GetLoginFamiliarity(u.ID, attempt.DeviceName, attempt.Network)
+	if err != nil {
+		log.Printf("login history: failed to check devices of user %d: %v", u.ID, err)
+	} else {
+		attempt.NewDevice = familiarity.HasHistory && (!familiarity.KnownDevice || !familiarity.KnownNetwork)
+	}
+
+	h.saveLoginAttempt(attempt)
+
+	if attempt.NewDevice && configs.Envs.NewDeviceAlerts {
+		h.sendEmail(u, "new_device_login", newDeviceLoginData{
+			Username:   u.Username,
+			DeviceName: attempt.DeviceName,
+			IP:         attempt.IP,
+			LoginAt:    time.Now().UTC(),
+		})
+	}
+}
+
+func (h *Handler) saveLoginAttempt(attempt types.LoginAttempt) {
+	if err := h.store.RecordLoginAttempt(attempt); err != nil {
+		log.Printf("login history: failed to record %s attempt: %v", attempt.Outcome, err)
+	}
+}
+
+func newLoginAttempt(r *http.Request, u *types.User, identifier, method, outcome string) types.LoginAttempt {
+	ip := utils.ClientIP(r)
+	attempt := types.LoginAttempt{
+		Method:     method,
+		Outcome:    outcome,
+		IP:         ip,
+		Network:    loginNetwork(ip),
+		UserAgent:  r.UserAgent(),
+		DeviceName: utils.DeviceName(r.UserAgent()),
+	}
+	if u != nil {
+		attempt.UserID = &u.ID
+		attempt.Identifier = identifier
+	}
+	return attempt
+}
+
+// loginNetwork groups addresses that usually belong to the same network,
+// so a new address from the same provider does not count as new.
+func loginNetwork(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+
+	bits := 48
+	if addr = addr.Unmap(); addr.Is4() {
+		bits = 24
+	}
+
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ip
+	}
+	return prefix.String()
+}
+
+func (h *Handler) handleMyLoginHistory(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserIDFromContext(r.Context())
+	if !ok {
+		utils.WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	h.writeLoginHistory(w, r, userID)
+}
+
+func (h *Handler) handleAdminLoginHistory(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	h.writeLoginHistory(w, r, u.ID)
+}
+
+// writeLoginHistory lists the most recent attempts first, paged with limit
+// (default 50, at most 200) and offset.
+func (h *Handler) writeLoginHistory(w http.ResponseWriter, r *http.Request, userID int) {
+	q := r.URL.Query()
+
+	limit := 50
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 200 {
+			utils.WriteError(w, http.StatusBadRequest, errors.New("limit must be between 1 and 200"))
+			return
+		}
+		limit = n
+	}
+
+	offset := 0
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			utils.WriteError(w, http.StatusBadRequest, errors.New("invalid offset"))
+			return
+		}
+		offset = n
+	}
+
+	attempts, err := h.store.ListLoginAttempts(userID, limit, offset)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"attempts": attempts,
+	})
+}
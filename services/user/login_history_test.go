@@ -0,0 +1,98 @@
+package user
+
+import (
+	"auth-api/configs"
+	"auth-api/types"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewLoginAttemptDropsUnmatchedIdentifier(t *testing.T) {
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.RemoteAddr = "198.51.100.7:5000"
+
+	attempt := newLoginAttempt(r, nil, "hunter2", types.LoginMethodPassword, types.LoginOutcomeInvalidCredentials)
+	if attempt.Identifier != "" {
+		t.Errorf("Identifier = %q, want it dropped for an unknown account", attempt.Identifier)
+	}
+	if attempt.UserID != nil {
+		t.Errorf("UserID = %v, want nil", *attempt.UserID)
+	}
+
+	u := &types.User{ID: 3}
+	attempt = newLoginAttempt(r, u, "alice", types.LoginMethodPassword, types.LoginOutcomeSuccess)
+	if attempt.Identifier != "alice" || attempt.UserID == nil || *attempt.UserID != 3 {
+		t.Errorf("attempt = %+v, want identifier and user kept", attempt)
+	}
+	if attempt.IP != "198.51.100.7" || attempt.Network != "198.51.100.0/24" {
+		t.Errorf("IP, Network = %q, %q", attempt.IP, attempt.Network)
+	}
+}
+
+func TestLoginNetwork(t *testing.T) {
+	tests := map[string]string{
+		"203.0.113.77":        "203.0.113.0/24",
+		"::ffff:203.0.113.77": "203.0.113.0/24",
+		"2001:db8:abcd:12::1": "2001:db8:abcd::/48",
+		"not-an-ip":           "not-an-ip",
+	}
+	for ip, want := range tests {
+		if got := loginNetwork(ip); got != want {
+			t.Errorf("loginNetwork(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestNewDeviceLoginEmail(t *testing.T) {
+	tests := []struct {
+		name        string
+		familiarity types.LoginFamiliarity
+		alerts      bool
+		wantEmail   bool
+	}{
+		{"first login", types.LoginFamiliarity{}, true, false},
+		{"known device and network", types.LoginFamiliarity{HasHistory: true, KnownDevice: true, KnownNetwork: true}, true, false},
+		{"new device", types.LoginFamiliarity{HasHistory: true, KnownNetwork: true}, true, true},
+		{"new network", types.LoginFamiliarity{HasHistory: true, KnownDevice: true}, true, true},
+		{"alerts off", types.LoginFamiliarity{HasHistory: true}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withEnvs(t, func(c *configs.Config) { c.NewDeviceAlerts = tt.alerts })
+
+			h, store, _, mailer := newTestHandler()
+			store.familiarity = tt.familiarity
+
+			r := httptest.NewRequest("POST", "/login", nil)
+			r.RemoteAddr = "203.0.113.50:443"
+			r.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0")
+			u := &types.User{ID: 3, Username: "alice", Email: "alice@example.com"}
+
+			h.recordSuccessfulLogin(r, u, "alice", types.LoginMethodPassword)
+
+			if len(store.attempts) != 1 {
+				t.Fatalf("%d attempts recorded, want 1", len(store.attempts))
+			}
+			wantNew := tt.familiarity.HasHistory && !(tt.familiarity.KnownDevice && tt.familiarity.KnownNetwork)
+			if store.attempts[0].NewDevice != wantNew {
+				t.Errorf("NewDevice = %v, want %v", store.attempts[0].NewDevice, wantNew)
+			}
+
+			msg, sent := mailer.Last()
+			if sent != tt.wantEmail {
+				t.Fatalf("email sent = %v, want %v", sent, tt.wantEmail)
+			}
+			if !sent {
+				return
+			}
+			if msg.To != "alice@example.com" {
+				t.Errorf("email sent to %q", msg.To)
+			}
+			if !strings.Contains(msg.Text, "203.0.113.50") || !strings.Contains(msg.Text, store.attempts[0].DeviceName) {
+				t.Errorf("email does not name the device and address:\n%s", msg.Text)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package user
+
+import (
+	"auth-api/types"
+	"auth-api/utils"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// checkOrganizationScope hides users outside the caller's active
+// organization from everyone but platform admins.
+func (h *Handler) checkOrganizationScope(w http.ResponseWriter, r *http.Request, userID int) bool {
+	orgID, allOrgs, err := utils.OrganizationScope(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusForbidden, err)
+		return false
+	}
+	if allOrgs {
+		return true
+	}
+
+	if _, err := h.orgs.GetMembership(orgID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusNotFound, errors.New("user not found"))
+			return false
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return false
+	}
+
+	return true
+}
+
+func (h *Handler) handleListMyOrganizations(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserIDFromContext(r.Context())
+	if !ok {
+		utils.WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	memberships, err := h.orgs.ListMemberships(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, memberships)
+}
+
+// handleSetActiveOrganization switches the organization embedded in the
+// tenant claim. A fresh token pair for the current session is returned
+// since existing access tokens keep the previous tenant until they expire.
+func (h *Handler) handleSetActiveOrganization(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserIDFromContext(r.Context())
+	if !ok {
+		utils.WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	var payload types.SetActiveOrganizationPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if payload.OrganizationID != nil {
+		if _, err := h.orgs.GetMembership(*payload.OrganizationID, userID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				utils.WriteError(w, http.StatusForbidden, errors.New("not a member of this organization"))
+				return
+			}
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	if err := h.orgs.SetActiveOrganization(userID, payload.OrganizationID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	u, err := h.store.GetUserByID(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	var accessToken, refreshToken string
+	if sessionID, ok := utils.GetSessionIDFromContext(r.Context()); ok {
+		var session *types.Session
+		session, err = h.store.GetSession(sessionID)
+		if err == nil {
+			accessToken, refreshToken, err = h.issueTokenPair(u, sessionID, session.ExpiresAt)
+		}
+	} else {
+		accessToken, refreshToken, err = h.startSession(r, u, "")
+	}
+	if err != nil {
+		writeStartSessionError(w, err)
+		return
+	}
+
+	writeTokenResponse(w, http.StatusOK, map[string]any{
+		"activeOrganizationId": u.ActiveOrgID,
+	}, accessToken, refreshToken)
+}
+
+// activeMembership returns u's membership in their active organization, or
+// nil when none is selected or they have since left it.
+func (h *Handler) activeMembership(u *types.User) (*types.Membership, error) {
+	if u.ActiveOrgID == nil {
+		return nil, nil
+	}
+
+	m, err := h.orgs.GetMembership(*u.ActiveOrgID, u.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+func tenantClaim(m *types.Membership) string {
+	if m == nil {
+		return ""
+	}
+	return strconv.Itoa(m.OrganizationID)
+}
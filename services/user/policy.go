@@ -0,0 +1,69 @@
+package user
+
+import (
+	"auth-api/services/policy"
+	"auth-api/types"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// policyInput describes the caller and, on /users/{id} routes, the target
+// user. Both expose their admin-only profile attributes under
+// "attributes", so a rule like
+// `resource.attributes.region == subject.attributes.region` can compare
+// them. Attributes users may edit themselves are left out.
+func (h *Handler) policyInput(r *http.Request) (policy.Input, error) {
+	in := policy.Input{
+		Subject:  policy.SubjectFromRequest(r),
+		Resource: map[string]any{"type": "user"},
+		Request:  policy.RequestAttributes(r),
+	}
+
+	defs, err := h.store.ListUserAttributeDefinitions()
+	if err != nil {
+		return in, err
+	}
+
+	if userID, ok := in.Subject["id"].(int); ok {
+		u, err := h.store.GetUserByID(userID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return in, err
+		}
+		if u != nil {
+			in.Subject["attributes"] = adminAttributes(defs, u.Metadata)
+		}
+	}
+
+	// An unknown or malformed id leaves the resource bare; the handler
+	// reports it once access has been decided.
+	targetID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return in, nil
+	}
+
+	target, err := h.store.GetUserByID(targetID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return in, nil
+		}
+		return in, err
+	}
+	in.Resource = userResource(target, defs)
+
+	return in, nil
+}
+
+func userResource(u *types.User, defs []types.UserAttributeDefinition) map[string]any {
+	return map[string]any{
+		"type":       "user",
+		"id":         u.ID,
+		"role":       u.Role,
+		"status":     u.Status,
+		"verified":   u.IsEmailVerified(),
+		"attributes": adminAttributes(defs, u.Metadata),
+	}
+}
@@ -0,0 +1,306 @@
+package user
+
+import (
+	"auth-api/configs"
+	"auth-api/types"
+	"auth-api/utils"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+var attributeNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+func (h *Handler) handleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserIDFromContext(r.Context())
+	if !ok {
+		utils.WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	var payload types.UpdateProfilePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	u, err := h.store.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if payload.Username != nil && *payload.Username != u.Username {
+		now := time.Now().UTC()
+		if u.UsernameChangedAt != nil && now.Before(u.UsernameChangedAt.Add(configs.Envs.UsernameChangeCooldown)) {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf(
+				"username can be changed again after %s",
+				u.UsernameChangedAt.Add(configs.Envs.UsernameChangeCooldown).Format(time.RFC3339),
+			))
+			return
+		}
+
+		if existing, err := h.store.GetUserByUsername(*payload.Username); err == nil && existing.ID != u.ID {
+			utils.WriteError(w, http.StatusBadRequest, errors.New("username already exists"))
+			return
+		}
+
+		u.Username = *payload.Username
+		u.UsernameChangedAt = &now
+	}
+
+	if payload.DisplayName != nil {
+		u.DisplayName = *payload.DisplayName
+	}
+
+	// An empty string clears locale, timezone and avatar.
+	if payload.Locale != nil {
+		if *payload.Locale != "" {
+			if err := utils.Validate.Var(*payload.Locale, "bcp47_language_tag"); err != nil {
+				utils.WriteError(w, http.StatusBadRequest, errors.New("invalid locale"))
+				return
+			}
+		}
+		u.Locale = *payload.Locale
+	}
+
+	if payload.Timezone != nil {
+		u.Timezone = *payload.Timezone
+	}
+
+	if payload.AvatarURL != nil {
+		if *payload.AvatarURL != "" && !isHTTPURL(*payload.AvatarURL) {
+			utils.WriteError(w, http.StatusBadRequest, errors.New("avatarUrl must be an http or https URL"))
+			return
+		}
+		u.AvatarURL = *payload.AvatarURL
+	}
+
+	if payload.Metadata != nil {
+		defs, err := h.store.ListUserAttributeDefinitions()
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if err := checkSelfEditable(defs, u.Metadata, payload.Metadata); err != nil {
+			utils.WriteError(w, http.StatusForbidden, err)
+			return
+		}
+
+		merged := mergeMetadata(defs, u.Metadata, payload.Metadata)
+		if err := validateMetadata(defs, payload.Metadata, merged, false); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		u.Metadata = merged
+	}
+
+	if err := h.store.UpdateProfile(u); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, userResponse(u))
+}
+
+func (h *Handler) handleListUserAttributes(w http.ResponseWriter, r *http.Request) {
+	defs, err := h.store.ListUserAttributeDefinitions()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if defs == nil {
+		defs = []types.UserAttributeDefinition{}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, defs)
+}
+
+func (h *Handler) handlePutUserAttribute(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !attributeNamePattern.MatchString(name) {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("attribute name must be lowercase snake_case"))
+		return
+	}
+
+	var payload types.UserAttributeDefinitionPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if payload.MaxLength != nil && payload.Type != types.AttributeTypeString {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("maxLength only applies to string attributes"))
+		return
+	}
+
+	def := types.UserAttributeDefinition{
+		Name:        name,
+		Type:        payload.Type,
+		Required:    payload.Required,
+		MaxLength:   payload.MaxLength,
+		AdminOnly:   payload.AdminOnly,
+		Description: payload.Description,
+	}
+	if err := h.store.UpsertUserAttributeDefinition(def); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.recordAudit(r, "user_attribute.upsert", "user_attribute", name, map[string]any{
+		"type":      def.Type,
+		"required":  def.Required,
+		"maxLength": def.MaxLength,
+		"adminOnly": def.AdminOnly,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, def)
+}
+
+func (h *Handler) handleDeleteUserAttribute(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	if err := h.store.DeleteUserAttributeDefinition(name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusNotFound, errors.New("attribute not found"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.recordAudit(r, "user_attribute.delete", "user_attribute", name, nil)
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "attribute deleted",
+	})
+}
+
+// mergeMetadata applies patch to current, where a nil value removes the
+// key. Keys whose definition has since been deleted are dropped.
+func mergeMetadata(defs []types.UserAttributeDefinition, current, patch map[string]any) map[string]any {
+	merged := make(map[string]any, len(current)+len(patch))
+	for _, d := range defs {
+		if v, ok := current[d.Name]; ok {
+			merged[d.Name] = v
+		}
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
+// checkSelfEditable rejects a patch that changes an admin-only attribute.
+// Unchanged values are let through, so clients may send back the metadata
+// they were given.
+func checkSelfEditable(defs []types.UserAttributeDefinition, current, patch map[string]any) error {
+	for _, d := range defs {
+		v, ok := patch[d.Name]
+		if !ok || !d.AdminOnly {
+			continue
+		}
+		if old, set := current[d.Name]; (v == nil && !set) || (set && reflect.DeepEqual(old, v)) {
+			continue
+		}
+		return fmt.Errorf("metadata attribute %q can only be changed by an admin", d.Name)
+	}
+	return nil
+}
+
+// adminAttributes returns the admin-only attributes in metadata. Users
+// edit the rest themselves, so access decisions must not depend on them.
+func adminAttributes(defs []types.UserAttributeDefinition, metadata map[string]any) map[string]any {
+	attrs := map[string]any{}
+	for _, d := range defs {
+		if v, ok := metadata[d.Name]; ok && d.AdminOnly {
+			attrs[d.Name] = v
+		}
+	}
+	return attrs
+}
+
+// validateMetadata checks the values set by patch against the admin-defined
+// attributes; values already stored are not checked again. Required
+// attributes are enforced on merged, but only those the caller may set:
+// admin-only ones are left to admins, so adding one doesn't lock users out
+// of their own edits.
+func validateMetadata(defs []types.UserAttributeDefinition, patch, merged map[string]any, admin bool) error {
+	byName := make(map[string]types.UserAttributeDefinition, len(defs))
+	for _, d := range defs {
+		byName[d.Name] = d
+	}
+
+	for k, v := range patch {
+		if v == nil {
+			continue
+		}
+		def, ok := byName[k]
+		if !ok {
+			return fmt.Errorf("unknown metadata attribute %q", k)
+		}
+
+		switch def.Type {
+		case types.AttributeTypeString:
+			str, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("metadata attribute %q must be a string", k)
+			}
+			if def.MaxLength != nil && utf8.RuneCountInString(str) > *def.MaxLength {
+				return fmt.Errorf("metadata attribute %q must be at most %d characters", k, *def.MaxLength)
+			}
+		case types.AttributeTypeNumber:
+			if _, ok := v.(float64); !ok {
+				return fmt.Errorf("metadata attribute %q must be a number", k)
+			}
+		case types.AttributeTypeBoolean:
+			if _, ok := v.(bool); !ok {
+				return fmt.Errorf("metadata attribute %q must be a boolean", k)
+			}
+		}
+	}
+
+	for _, d := range defs {
+		if !d.Required || (d.AdminOnly && !admin) {
+			continue
+		}
+		if _, ok := merged[d.Name]; !ok {
+			return fmt.Errorf("metadata attribute %q is required", d.Name)
+		}
+	}
+
+	return nil
+}
+
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,109 @@
+package user
+
+import (
+	"auth-api/types"
+	"reflect"
+	"testing"
+)
+
+var testAttributeDefs = []types.UserAttributeDefinition{
+	{Name: "region", Type: types.AttributeTypeString, AdminOnly: true},
+	{Name: "nickname", Type: types.AttributeTypeString},
+}
+
+func TestCheckSelfEditable(t *testing.T) {
+	current := map[string]any{"region": "eu", "nickname": "bob"}
+
+	tests := []struct {
+		name    string
+		patch   map[string]any
+		wantErr bool
+	}{
+		{"user attribute", map[string]any{"nickname": "rob"}, false},
+		{"admin-only attribute unchanged", map[string]any{"region": "eu", "nickname": "rob"}, false},
+		{"admin-only attribute changed", map[string]any{"region": "us"}, true},
+		{"admin-only attribute cleared", map[string]any{"region": nil}, true},
+		{"admin-only attribute type changed", map[string]any{"region": 1.0}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSelfEditable(testAttributeDefs, current, tt.patch)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := checkSelfEditable(testAttributeDefs, map[string]any{}, map[string]any{"region": "us"}); err == nil {
+		t.Error("setting an unset admin-only attribute succeeded, want error")
+	}
+	if err := checkSelfEditable(testAttributeDefs, map[string]any{}, map[string]any{"region": nil}); err != nil {
+		t.Errorf("clearing an unset admin-only attribute: %v", err)
+	}
+}
+
+func TestAdminAttributes(t *testing.T) {
+	got := adminAttributes(testAttributeDefs, map[string]any{"region": "eu", "nickname": "bob", "stale": "x"})
+	want := map[string]any{"region": "eu"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("adminAttributes = %v, want %v", got, want)
+	}
+}
+
+func TestMetadataPatchIgnoresDeletedAttributes(t *testing.T) {
+	defs := []types.UserAttributeDefinition{{Name: "nickname", Type: types.AttributeTypeString}}
+	current := map[string]any{"nickname": "bob", "team": "blue"}
+
+	patch := map[string]any{"nickname": "rob"}
+	merged := mergeMetadata(defs, current, patch)
+	if err := validateMetadata(defs, patch, merged, false); err != nil {
+		t.Fatalf("patch after an attribute was deleted: %v", err)
+	}
+	if want := map[string]any{"nickname": "rob"}; !reflect.DeepEqual(merged, want) {
+		t.Errorf("merged = %v, want %v", merged, want)
+	}
+
+	patch = map[string]any{"team": "red"}
+	if err := validateMetadata(defs, patch, mergeMetadata(defs, current, patch), false); err == nil {
+		t.Error("setting a deleted attribute succeeded, want error")
+	}
+}
+
+func TestMetadataPatchRequiredAttributes(t *testing.T) {
+	defs := []types.UserAttributeDefinition{
+		{Name: "nickname", Type: types.AttributeTypeString},
+		{Name: "costCenter", Type: types.AttributeTypeString, Required: true, AdminOnly: true},
+		{Name: "phone", Type: types.AttributeTypeString, Required: true},
+	}
+	current := map[string]any{"nickname": "bob", "phone": "555"}
+
+	tests := []struct {
+		name    string
+		patch   map[string]any
+		admin   bool
+		wantErr bool
+	}{
+		{"user edit without admin-only required attribute", map[string]any{"nickname": "rob"}, false, false},
+		{"admin edit without admin-only required attribute", map[string]any{"nickname": "rob"}, true, true},
+		{"admin sets it", map[string]any{"costCenter": "cc-1"}, true, false},
+		{"user removes required attribute", map[string]any{"phone": nil}, false, true},
+		{"patched value of the wrong type", map[string]any{"nickname": 3.0}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMetadata(defs, tt.patch, mergeMetadata(defs, current, tt.patch), tt.admin)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// A stored value that no longer passes is not checked again.
+	stale := map[string]any{"nickname": 7.0, "phone": "555"}
+	patch := map[string]any{"phone": "556"}
+	if err := validateMetadata(defs, patch, mergeMetadata(defs, stale, patch), false); err != nil {
+		t.Errorf("unrelated stored value blocked the patch: %v", err)
+	}
+}
@@ -1,11 +1,15 @@
 package user
 
 import (
+	"auth-api/configs"
+	"auth-api/services/policy"
 	"auth-api/types"
 	"auth-api/utils"
 	"database/sql"
 	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
 	"time"
 
@@ -13,11 +17,16 @@ import (
 )
 
 type Handler struct {
-	store types.UserStore
+	store    types.UserStore
+	roles    types.RoleStore
+	orgs     types.OrganizationStore
+	audit    types.AuditStore
+	policies *policy.Engine
+	mailer   utils.Mailer
 }
 
-func NewHandler(store types.UserStore) *Handler {
-	return &Handler{store: store}
+func NewHandler(store types.UserStore, roles types.RoleStore, orgs types.OrganizationStore, audit types.AuditStore, policies *policy.Engine, mailer utils.Mailer) *Handler {
+	return &Handler{store: store, roles: roles, orgs: orgs, audit: audit, policies: policies, mailer: mailer}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
@@ -25,12 +34,59 @@ func (h *Handler) RegisterRoutes(router *mux.Router) {
 	router.Handle("/login", utils.RateLimit(10, 1*time.Minute)(http.HandlerFunc(h.handleLogin))).Methods("POST")
 	router.HandleFunc("/refresh", h.handleRefresh).Methods("POST")
 	router.HandleFunc("/logout", h.handleLogout).Methods("POST")
+	router.HandleFunc("/refresh/logout", h.handleLogout).Methods("POST")
+	router.HandleFunc("/verify-email", h.handleVerifyEmail).Methods("POST")
+	router.Handle("/verify-email/resend", utils.RateLimit(3, 10*time.Minute)(http.HandlerFunc(h.handleResendVerification))).Methods("POST")
+	router.Handle("/password/forgot", utils.RateLimit(3, 10*time.Minute)(http.HandlerFunc(h.handleForgotPassword))).Methods("POST")
+	router.Handle("/password/reset", utils.RateLimit(10, 10*time.Minute)(http.HandlerFunc(h.handleResetPassword))).Methods("POST")
+	router.Handle("/unlock-account", utils.RateLimit(10, 10*time.Minute)(http.HandlerFunc(h.handleUnlockAccount))).Methods("POST")
 
 	router.Handle("/me",
-		utils.AuthMiddleware(http.HandlerFunc(h.handleMe)),
+		utils.PasswordChangeAuthMiddleware(http.HandlerFunc(h.handleMe)),
 	).Methods("GET")
-	router.Handle("/change-password", utils.AuthMiddleware(http.HandlerFunc(h.handleChangePassword))).Methods("POST")
-	router.Handle("/users", utils.AuthMiddleware(http.HandlerFunc(h.handleListUsers))).Methods("GET")
+	router.Handle("/me", utils.AuthMiddleware(utils.RejectDelegatedAccess(utils.RequireVerifiedEmail(http.HandlerFunc(h.handleUpdateProfile))))).Methods("PATCH")
+	router.Handle("/me", utils.AuthMiddleware(utils.RejectDelegatedAccess(http.HandlerFunc(h.handleDeleteAccount)))).Methods("DELETE")
+	router.Handle("/me/export", utils.AuthMiddleware(utils.RejectDelegatedAccess(http.HandlerFunc(h.handleExportMe)))).Methods("GET")
+	router.Handle("/me/exports/{exportId}", utils.AuthMiddleware(utils.RejectDelegatedAccess(http.HandlerFunc(h.handleGetMyExport)))).Methods("GET")
+	router.Handle("/me/email", utils.AuthMiddleware(utils.RejectDelegatedAccess(http.HandlerFunc(h.handleRequestEmailChange)))).Methods("POST")
+	router.HandleFunc("/me/email/confirm", h.handleConfirmEmailChange).Methods("POST")
+	router.HandleFunc("/me/email/cancel", h.handleCancelEmailChange).Methods("POST")
+	router.Handle("/me/organizations", utils.AuthMiddleware(http.HandlerFunc(h.handleListMyOrganizations))).Methods("GET")
+	router.Handle("/me/active-organization", utils.AuthMiddleware(utils.RejectDelegatedAccess(http.HandlerFunc(h.handleSetActiveOrganization)))).Methods("PUT")
+	router.Handle("/me/sessions", utils.AuthMiddleware(http.HandlerFunc(h.handleListSessions))).Methods("GET")
+	router.Handle("/me/sessions/revoke-others", utils.AuthMiddleware(utils.RejectDelegatedAccess(http.HandlerFunc(h.handleRevokeOtherSessions)))).Methods("POST")
+	router.Handle("/me/sessions/{sessionId}", utils.AuthMiddleware(utils.RejectDelegatedAccess(http.HandlerFunc(h.handleRevokeSession)))).Methods("DELETE")
+	router.Handle("/me/login-history", utils.AuthMiddleware(http.HandlerFunc(h.handleMyLoginHistory))).Methods("GET")
+	router.Handle("/me/tokens", utils.AuthMiddleware(http.HandlerFunc(h.handleListPersonalAccessTokens))).Methods("GET")
+	router.Handle("/me/tokens", utils.AuthMiddleware(utils.RejectDelegatedAccess(http.HandlerFunc(h.handleCreatePersonalAccessToken)))).Methods("POST")
+	router.Handle("/me/tokens/{tokenId}", utils.AuthMiddleware(utils.RejectDelegatedAccess(http.HandlerFunc(h.handleRevokePersonalAccessToken)))).Methods("DELETE")
+	router.Handle("/change-password", utils.PasswordChangeAuthMiddleware(utils.RejectDelegatedAccess(utils.RequireVerifiedEmail(http.HandlerFunc(h.handleChangePassword))))).Methods("POST")
+
+	router.Handle("/users", h.withPermission(utils.PermUsersRead, h.handleListUsersCompat)).Methods("GET")
+
+	router.Handle("/admin/users", h.withPermission(utils.PermUsersRead, h.handleListUsers)).Methods("GET")
+	router.Handle("/admin/users", h.withPermission(utils.PermUsersWrite, h.handleAdminCreateUser)).Methods("POST")
+	router.Handle("/admin/users/{id}", h.withPermission(utils.PermUsersRead, h.handleAdminGetUser)).Methods("GET")
+	router.Handle("/admin/users/{id}", h.withPermission(utils.PermUsersWrite, h.handleAdminUpdateUser)).Methods("PATCH")
+	router.Handle("/admin/users/{id}", h.withPermission(utils.PermUsersDelete, h.handleAdminDeleteUser)).Methods("DELETE")
+	router.Handle("/admin/users/{id}/reset-password", h.withPermission(utils.PermUsersWrite, h.handleAdminResetPassword)).Methods("POST")
+	router.Handle("/admin/users/{id}/status", h.withPermission(utils.PermUsersWrite, h.handleAdminUpdateUserStatus)).Methods("PUT")
+	router.Handle("/admin/users/{id}/logout", h.withPermission(utils.PermUsersWrite, h.handleAdminForceLogout)).Methods("POST")
+	router.Handle("/admin/users/{id}/impersonate", h.withPermission(utils.PermUsersImpersonate, utils.RejectDelegatedAccess(http.HandlerFunc(h.handleAdminImpersonateUser)).ServeHTTP)).Methods("POST")
+	router.Handle("/admin/users/{id}/login-history", h.withPermission(utils.PermUsersRead, h.handleAdminLoginHistory)).Methods("GET")
+	router.Handle("/admin/users/{id}/lockouts", h.withPermission(utils.PermUsersRead, h.handleAdminGetUserLockouts)).Methods("GET")
+	router.Handle("/admin/users/{id}/lockouts", h.withPermission(utils.PermUsersWrite, h.handleAdminClearUserLockouts)).Methods("DELETE")
+	router.Handle("/admin/lockouts", h.withPermission(utils.PermUsersRead, h.handleAdminListLockouts)).Methods("GET")
+	router.Handle("/admin/lockouts/{lockoutId}", h.withPermission(utils.PermUsersWrite, h.handleAdminDeleteLockout)).Methods("DELETE")
+	router.Handle("/admin/users/{id}/export", h.withPermission(utils.PermUsersExport, h.handleAdminExportUser)).Methods("GET")
+	router.Handle("/admin/exports/{exportId}", h.withPermission(utils.PermUsersExport, h.handleAdminGetExport)).Methods("GET")
+	router.Handle("/admin/user-attributes", h.withPermission(utils.PermUserAttributes, h.handleListUserAttributes)).Methods("GET")
+	router.Handle("/admin/user-attributes/{name}", h.withPermission(utils.PermUserAttributes, h.handlePutUserAttribute)).Methods("PUT")
+	router.Handle("/admin/user-attributes/{name}", h.withPermission(utils.PermUserAttributes, h.handleDeleteUserAttribute)).Methods("DELETE")
+}
+
+func (h *Handler) withPermission(perm string, fn http.HandlerFunc) http.Handler {
+	return utils.AuthMiddleware(policy.Require(h.policies, perm, h.policyInput)(fn))
 }
 
 func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
@@ -72,35 +128,29 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
+	user.ID = userID
 
-	accessToken, err := utils.GenerateAccessToken(userID)
-	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
-		return
-	}
+	// The device used to sign up is the first known one for new-device alerts.
+	h.recordSuccessfulLogin(r, &user, payload.Username, types.LoginMethodRegistration)
 
-	refreshToken, err := utils.GenerateRefreshToken(userID)
-	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
-		return
-	}
+	h.sendVerificationEmail(&user)
 
-	claims, err := utils.ParseToken(refreshToken)
-	if err != nil || claims.TokenType != "refresh" || claims.ExpiresAt == nil {
-		utils.WriteError(w, http.StatusInternalServerError, errors.New("failed to persist refresh token"))
+	if configs.Envs.EmailVerificationMode == configs.EmailVerificationBlock {
+		utils.WriteJSON(w, http.StatusCreated, map[string]any{
+			"message": "registered successfully, please verify your email before logging in",
+		})
 		return
 	}
 
-	if err := h.store.SaveRefreshToken(userID, refreshToken, claims.ExpiresAt.Time); err != nil {
+	accessToken, refreshToken, err := h.startSession(r, &user, "")
+	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusCreated, map[string]any{
-		"message":      "registered successfully",
-		"accessToken":  accessToken,
-		"refreshToken": refreshToken,
-	})
+	writeTokenResponse(w, http.StatusCreated, map[string]any{
+		"message": "registered successfully",
+	}, accessToken, refreshToken)
 }
 
 func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
@@ -114,49 +164,92 @@ func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Every way out of a login after this point is recorded in the history.
+	fail := func(u *types.User, outcome string) {
+		h.recordLoginHistory(r, u, payload.Identifier, types.LoginMethodPassword, outcome)
+	}
+
+	identifier := normalizeIdentifier(payload.Identifier)
+	if outcome, ok := h.checkLoginLockout(w, types.LockoutKindIdentifier, identifier); !ok {
+		fail(nil, outcome)
+		return
+	}
+
 	u, err := h.store.GetUserByEmail(payload.Identifier)
 	if errors.Is(err, sql.ErrNoRows) {
 		u, err = h.store.GetUserByUsername(payload.Identifier)
 	}
 
+	if errors.Is(err, sql.ErrNoRows) {
+		h.recordLoginFailure(r, nil, identifier)
+		fail(nil, types.LoginOutcomeInvalidCredentials)
+		utils.WriteError(w, http.StatusUnauthorized, errors.New("invalid credentials"))
+		return
+	}
 	if err != nil {
+		fail(nil, types.LoginOutcomeError)
 		utils.WriteError(w, http.StatusUnauthorized, errors.New("invalid credentials"))
 		return
 	}
 
+	if outcome, ok := h.checkLoginLockout(w, types.LockoutKindAccount, strconv.Itoa(u.ID)); !ok {
+		fail(u, outcome)
+		return
+	}
+
 	if !utils.CheckPassword(u.Password, payload.Password) {
+		h.recordLoginFailure(r, u, identifier)
+		fail(u, types.LoginOutcomeInvalidCredentials)
 		utils.WriteError(w, http.StatusUnauthorized, errors.New("invalid credentials"))
 		return
 	}
+	h.clearLoginFailures(u, identifier)
 
-	accessToken, err := utils.GenerateAccessToken(u.ID)
-	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
+	if configs.Envs.EmailVerificationMode == configs.EmailVerificationBlock && !u.IsEmailVerified() {
+		fail(u, types.LoginOutcomeEmailNotVerified)
+		utils.WriteError(w, http.StatusForbidden, errors.New("email not verified"))
 		return
 	}
 
-	refreshToken, err := utils.GenerateRefreshToken(u.ID)
-	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
+	if !checkAccountStatus(w, u) {
+		fail(u, "account_"+u.CurrentStatus())
 		return
 	}
 
-	claims, err := utils.ParseToken(refreshToken)
-	if err != nil || claims.TokenType != "refresh" || claims.ExpiresAt == nil {
-		utils.WriteError(w, http.StatusInternalServerError, errors.New("failed to persist refresh token"))
-		return
+	// Logging in during the deletion grace period restores the account.
+	restored := false
+	if u.IsDeleted() {
+		if u.PurgeAfter == nil || !time.Now().UTC().Before(*u.PurgeAfter) {
+			fail(u, types.LoginOutcomeInvalidCredentials)
+			utils.WriteError(w, http.StatusUnauthorized, errors.New("invalid credentials"))
+			return
+		}
+		if err := h.store.RestoreUser(u.ID); err != nil {
+			fail(u, types.LoginOutcomeError)
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		u.DeletedAt, u.PurgeAfter = nil, nil
+		restored = true
 	}
 
-	if err := h.store.SaveRefreshToken(u.ID, refreshToken, claims.ExpiresAt.Time); err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
+	accessToken, refreshToken, err := h.startSession(r, u, payload.DeviceName)
+	if err != nil {
+		if errors.Is(err, errSessionLimitReached) {
+			fail(u, types.LoginOutcomeSessionLimit)
+		} else {
+			fail(u, types.LoginOutcomeError)
+		}
+		writeStartSessionError(w, err)
 		return
 	}
+	h.recordSuccessfulLogin(r, u, payload.Identifier, types.LoginMethodPassword)
 
-	utils.WriteJSON(w, http.StatusOK, map[string]any{
-		"message":      "login successfully",
-		"accessToken":  accessToken,
-		"refreshToken": refreshToken,
-	})
+	writeTokenResponse(w, http.StatusOK, map[string]any{
+		"message":                "login successfully",
+		"accountRestored":        restored,
+		"passwordChangeRequired": u.MustChangePassword,
+	}, accessToken, refreshToken)
 }
 
 func (h *Handler) handleMe(w http.ResponseWriter, r *http.Request) {
@@ -176,27 +269,16 @@ func (h *Handler) handleMe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, map[string]any{
-		"id":        u.ID,
-		"username":  u.Username,
-		"email":     u.Email,
-		"role":      u.Role,
-		"createdAt": u.CreatedAt,
-	})
+	utils.WriteJSON(w, http.StatusOK, userResponse(u))
 }
 
 func (h *Handler) handleRefresh(w http.ResponseWriter, r *http.Request) {
-	var payload types.RefreshPayload
-	if err := utils.ParseJSON(r, &payload); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
-		return
-	}
-	if err := utils.Validate.Struct(payload); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
+	refreshToken, ok := readRefreshToken(w, r)
+	if !ok {
 		return
 	}
 
-	claims, err := utils.ParseToken(payload.RefreshToken)
+	claims, err := utils.ParseToken(refreshToken)
 	if err != nil {
 		utils.WriteError(w, http.StatusUnauthorized, errors.New("invalid token"))
 		return
@@ -207,7 +289,7 @@ func (h *Handler) handleRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	valid, err := h.store.IsRefreshTokenValid(payload.RefreshToken)
+	valid, err := h.store.IsRefreshTokenValid(refreshToken)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
@@ -223,48 +305,65 @@ func (h *Handler) handleRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newAccessToken, err := utils.GenerateAccessToken(userID)
+	u, err := h.store.GetUserByID(userID)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusUnauthorized, errors.New("invalid token"))
+			return
+		}
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
+	if u.IsDeleted() {
+		utils.WriteError(w, http.StatusUnauthorized, errors.New("invalid token"))
+		return
+	}
+	if !checkAccountStatus(w, u) {
+		return
+	}
 
-	newRefreshToken, err := utils.GenerateRefreshToken(userID)
+	sessionID, err := h.store.GetRefreshTokenSessionID(refreshToken)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	newClaims, err := utils.ParseToken(newRefreshToken)
-	if err != nil || newClaims.TokenType != "refresh" || newClaims.ExpiresAt == nil {
-		utils.WriteError(w, http.StatusInternalServerError, errors.New("failed to persist refresh token"))
-		return
+	var sessionExpiresAt time.Time
+	if sessionID != 0 {
+		var ok bool
+		if sessionExpiresAt, ok = h.continueSession(w, r, u.ID, sessionID); !ok {
+			return
+		}
 	}
 
-	_ = h.store.RevokeRefreshToken(payload.RefreshToken)
-	if err := h.store.SaveRefreshToken(userID, newRefreshToken, newClaims.ExpiresAt.Time); err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
+	_ = h.store.RevokeRefreshToken(refreshToken)
+
+	var newAccessToken, newRefreshToken string
+	if sessionID == 0 {
+		// Refresh tokens issued before sessions existed move into a new one.
+		newAccessToken, newRefreshToken, err = h.startSession(r, u, "")
+	} else {
+		newAccessToken, newRefreshToken, err = h.issueTokenPair(u, sessionID, sessionExpiresAt)
+	}
+	if err != nil {
+		writeStartSessionError(w, err)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, map[string]any{
-		"accessToken":  newAccessToken,
-		"refreshToken": newRefreshToken,
-	})
+	writeTokenResponse(w, http.StatusOK, map[string]any{}, newAccessToken, newRefreshToken)
 }
 
 func (h *Handler) handleLogout(w http.ResponseWriter, r *http.Request) {
-	var payload types.RefreshPayload
-	if err := utils.ParseJSON(r, &payload); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
-		return
-	}
-	if err := utils.Validate.Struct(payload); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
+	refreshToken, ok := readRefreshToken(w, r)
+	if !ok {
 		return
 	}
 
-	_ = h.store.RevokeRefreshToken(payload.RefreshToken)
+	_ = h.store.RevokeSessionByRefreshToken(refreshToken)
+
+	if configs.Envs.CookieMode {
+		utils.ClearAuthCookies(w)
+	}
 
 	utils.WriteJSON(w, http.StatusOK, map[string]any{
 		"message": "logged out",
@@ -314,7 +413,7 @@ func (h *Handler) handleChangePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.store.RevokeAllRefreshTokensForUser(userID); err != nil {
+	if err := h.forceLogout(userID); err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
@@ -324,40 +423,333 @@ func (h *Handler) handleChangePassword(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleListUsersCompat serves GET /users, which only answers with the
+// paged envelope of /admin/users when limit, offset or cursor is given.
+func (h *Handler) handleListUsersCompat(w http.ResponseWriter, r *http.Request) {
+	h.listUsers(w, r, pagingRequested(r))
+}
+
 func (h *Handler) handleListUsers(w http.ResponseWriter, r *http.Request) {
-	userID, ok := utils.GetUserIDFromContext(r.Context())
-	if !ok {
-		utils.WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+	h.listUsers(w, r, true)
+}
+
+func (h *Handler) listUsers(w http.ResponseWriter, r *http.Request, paged bool) {
+	params, err := parseUserListParams(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
+	if !paged {
+		params.Limit = 0
+	}
 
-	currentUser, err := h.store.GetUserByID(userID)
+	orgID, allOrgs, err := utils.OrganizationScope(r)
 	if err != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		utils.WriteError(w, http.StatusForbidden, err)
+		return
+	}
+	if !allOrgs {
+		params.OrgID = &orgID
+	}
+
+	result, err := h.store.ListUsers(params)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCursor) {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	users := make([]map[string]any, 0, len(result.Users))
+	for _, u := range result.Users {
+		users = append(users, userResponse(&u))
+	}
+
+	if !paged {
+		utils.WriteJSON(w, http.StatusOK, users)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"users":      users,
+		"total":      result.Total,
+		"limit":      params.Limit,
+		"offset":     params.Offset,
+		"nextCursor": result.NextCursor,
+	})
+}
+
+func (h *Handler) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var payload types.VerifyEmailPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	claims, err := utils.ParseToken(payload.Token)
+	if err != nil || claims.TokenType != "email_verification" {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("invalid or expired verification token"))
+		return
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || userID <= 0 {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("invalid or expired verification token"))
 		return
 	}
 
-	if currentUser.Role != "admin" {
-		utils.WriteError(w, http.StatusForbidden, errors.New("forbidden"))
+	u, err := h.store.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusBadRequest, errors.New("invalid or expired verification token"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if u.Email != claims.Email {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("invalid or expired verification token"))
+		return
+	}
+
+	if err := h.store.MarkEmailVerified(u.ID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "email verified successfully",
+	})
+}
+
+// handleResendVerification always answers 202 so it can't be used to probe
+// which addresses have accounts.
+func (h *Handler) handleResendVerification(w http.ResponseWriter, r *http.Request) {
+	var payload types.ResendVerificationPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	users, err := h.store.ListUsers()
+	if u, err := h.store.GetUserByEmail(payload.Email); err == nil && !u.IsEmailVerified() {
+		h.sendVerificationEmail(u)
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, map[string]any{
+		"message": "if the address belongs to an unverified account, a verification email has been sent",
+	})
+}
+
+// handleForgotPassword always answers 202 so it can't be used to probe
+// which addresses have accounts.
+func (h *Handler) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var payload types.ForgotPasswordPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if u, err := h.store.GetUserByEmail(payload.Email); err == nil {
+		h.sendPasswordResetEmail(u)
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, map[string]any{
+		"message": "if the address belongs to an account, a password reset email has been sent",
+	})
+}
+
+func (h *Handler) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var payload types.ResetPasswordPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	userID, err := h.store.ConsumePasswordReset(utils.HashToken(payload.Token))
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusBadRequest, errors.New("invalid or expired reset token"))
+			return
+		}
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	resp := make([]map[string]any, 0, len(users))
-	for _, u := range users {
-		resp = append(resp, map[string]any{
-			"id":        u.ID,
-			"username":  u.Username,
-			"email":     u.Email,
-			"role":      u.Role,
-			"createdAt": u.CreatedAt,
-		})
+	newHash, err := utils.HashPassword(payload.NewPassword)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.store.UpdatePassword(userID, newHash); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.store.InvalidatePasswordResets(userID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.forceLogout(userID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "password reset successfully, all sessions have been logged out",
+	})
+}
+
+// startSession records a new signed-in device for u and issues its first
+// token pair. deviceName defaults to one derived from the user agent. It
+// returns errSessionLimitReached when MAX_SESSIONS_PER_USER is hit and
+// SESSION_LIMIT_POLICY is "refuse".
+func (h *Handler) startSession(r *http.Request, u *types.User, deviceName string) (string, string, error) {
+	if err := h.enforceSessionLimit(u.ID); err != nil {
+		return "", "", err
+	}
+
+	if deviceName == "" {
+		deviceName = utils.DeviceName(r.UserAgent())
+	}
+
+	now := time.Now().UTC()
+	expiresAt := sessionExpiry(now, now)
+	sessionID, err := h.store.CreateSession(types.Session{
+		UserID:     u.ID,
+		DeviceName: deviceName,
+		UserAgent:  r.UserAgent(),
+		IP:         utils.ClientIP(r),
+		ExpiresAt:  expiresAt,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return h.issueTokenPair(u, sessionID, expiresAt)
+}
+
+// issueTokenPair issues tokens for an existing session. The access token
+// does not outlive the session, which ends at sessionExpiresAt.
+func (h *Handler) issueTokenPair(u *types.User, sessionID int, sessionExpiresAt time.Time) (string, string, error) {
+	opts, err := h.accessTokenOptions(u)
+	if err != nil {
+		return "", "", err
+	}
+	opts.SessionID = sessionID
+	opts.SessionExpiresAt = sessionExpiresAt
+
+	accessToken, err := utils.GenerateAccessToken(u.ID, opts)
+	if err != nil {
+		return "", "", err
 	}
 
-	utils.WriteJSON(w, http.StatusOK, resp)
+	refreshToken, err := utils.GenerateRefreshToken(u.ID)
+	if err != nil {
+		return "", "", err
+	}
+
+	claims, err := utils.ParseToken(refreshToken)
+	if err != nil || claims.TokenType != "refresh" || claims.ExpiresAt == nil {
+		return "", "", errors.New("failed to persist refresh token")
+	}
+
+	if err := h.store.SaveRefreshToken(u.ID, sessionID, refreshToken, claims.ExpiresAt.Time); err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// accessTokenOptions snapshots u's roles, permissions and active
+// organization for embedding in a token.
+func (h *Handler) accessTokenOptions(u *types.User) (utils.AccessTokenOptions, error) {
+	roles, err := h.roles.EffectiveUserRoles(u.ID)
+	if err != nil {
+		return utils.AccessTokenOptions{}, err
+	}
+
+	perms, err := h.roles.UserPermissions(u.ID)
+	if err != nil {
+		return utils.AccessTokenOptions{}, err
+	}
+
+	roleNames := make([]string, 0, len(roles))
+	for _, role := range roles {
+		roleNames = append(roleNames, role.Name)
+	}
+
+	// The org role shows up as "org:<role>" and adds its permissions, which
+	// OrganizationScope limits to the tenant.
+	membership, err := h.activeMembership(u)
+	if err != nil {
+		return utils.AccessTokenOptions{}, err
+	}
+	if membership != nil {
+		roleNames = append(roleNames, "org:"+membership.Role)
+		for _, perm := range utils.OrgRolePermissions[membership.Role] {
+			if !slices.Contains(perms, perm) {
+				perms = append(perms, perm)
+			}
+		}
+	}
+
+	return utils.AccessTokenOptions{
+		EmailVerified:          u.IsEmailVerified(),
+		PasswordChangeRequired: u.MustChangePassword,
+		Roles:                  roleNames,
+		Permissions:            perms,
+		Tenant:                 tenantClaim(membership),
+	}, nil
+}
+
+// checkAccountStatus writes an error response and returns false unless u is
+// allowed to obtain tokens.
+func checkAccountStatus(w http.ResponseWriter, u *types.User) bool {
+	status := u.CurrentStatus()
+	if status == types.UserStatusActive {
+		return true
+	}
+
+	utils.WriteErrorCode(w, http.StatusForbidden, "account_"+status, fmt.Errorf("account is %s", status))
+	return false
+}
+
+func userResponse(u *types.User) map[string]any {
+	return map[string]any{
+		"id":                   u.ID,
+		"username":             u.Username,
+		"email":                u.Email,
+		"role":                 u.Role,
+		"createdAt":            u.CreatedAt,
+		"emailVerified":        u.IsEmailVerified(),
+		"displayName":          u.DisplayName,
+		"locale":               u.Locale,
+		"timezone":             u.Timezone,
+		"avatarUrl":            u.AvatarURL,
+		"metadata":             u.Metadata,
+		"status":               u.CurrentStatus(),
+		"mustChangePassword":   u.MustChangePassword,
+		"activeOrganizationId": u.ActiveOrgID,
+	}
 }
@@ -0,0 +1,39 @@
+package user
+
+import (
+	"auth-api/configs"
+	"auth-api/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestDelegatedTokensCannotExportOrRevoke(t *testing.T) {
+	withEnvs(t, func(c *configs.Config) { c.JWTSecret = "test-secret" })
+
+	h, _, _, _ := newTestHandler()
+	router := mux.NewRouter()
+	h.RegisterRoutes(router)
+
+	token, err := utils.GenerateImpersonationToken(7, 1, time.Minute, utils.AccessTokenOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := []struct{ method, target string }{
+		{"GET", "/me/export"},
+		{"GET", "/me/exports/1"},
+		{"PATCH", "/me"},
+		{"DELETE", "/me/tokens/1"},
+	}
+	for _, route := range routes {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, authorized(httptest.NewRequest(route.method, route.target, nil), token))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s while impersonating = %d, want %d", route.method, route.target, w.Code, http.StatusForbidden)
+		}
+	}
+}
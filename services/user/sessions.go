@@ -0,0 +1,199 @@
+package user
+
+import (
+	"auth-api/configs"
+	"auth-api/utils"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var errSessionLimitReached = errors.New("too many active sessions, sign out of another device first")
+
+func (h *Handler) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserIDFromContext(r.Context())
+	if !ok {
+		utils.WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	sessions, err := h.store.ListSessions(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	current, _ := utils.GetSessionIDFromContext(r.Context())
+	now := time.Now().UTC()
+
+	// Idle sessions are only revoked on their next refresh; hide them here.
+	active := sessions[:0]
+	for _, session := range sessions {
+		if sessionIdle(session.LastUsedAt, now) {
+			continue
+		}
+		session.Current = session.ID == current
+		active = append(active, session)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, active)
+}
+
+// handleRevokeSession signs one device out. Its refresh tokens stop working
+// at once, and so do access tokens issued for the session.
+func (h *Handler) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserIDFromContext(r.Context())
+	if !ok {
+		utils.WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	sessionID, err := strconv.Atoi(mux.Vars(r)["sessionId"])
+	if err != nil || sessionID <= 0 {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("invalid session id"))
+		return
+	}
+
+	if err := h.store.RevokeSession(userID, sessionID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusNotFound, errors.New("session not found"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.recordUserAudit(r, "user.session_revoke", userID, map[string]any{
+		"sessionId": sessionID,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "session revoked",
+	})
+}
+
+// handleRevokeOtherSessions keeps only the session the request was made
+// from. Tokens issued before sessions existed have none, so every session
+// is revoked for them.
+func (h *Handler) handleRevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserIDFromContext(r.Context())
+	if !ok {
+		utils.WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	current, _ := utils.GetSessionIDFromContext(r.Context())
+
+	revoked, err := h.store.RevokeOtherSessions(userID, current)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.recordUserAudit(r, "user.sessions_revoke_others", userID, map[string]any{
+		"keptSessionId": current,
+		"revoked":       revoked,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "other sessions revoked",
+		"revoked": revoked,
+	})
+}
+
+// continueSession checks a session before its refresh token is rotated. A
+// session idle for longer than SESSION_IDLE_TIMEOUT is revoked; otherwise
+// its last use is recorded and its expiry pushed out, but never past
+// SESSION_ABSOLUTE_LIFETIME. It returns the new expiry.
+func (h *Handler) continueSession(w http.ResponseWriter, r *http.Request, userID, sessionID int) (time.Time, bool) {
+	session, err := h.store.GetSession(sessionID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusUnauthorized, errors.New("invalid token"))
+			return time.Time{}, false
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return time.Time{}, false
+	}
+
+	now := time.Now().UTC()
+	if session.UserID != userID || session.RevokedAt != nil || !now.Before(session.ExpiresAt) {
+		utils.WriteErrorCode(w, http.StatusUnauthorized, "session_expired", errors.New("session expired"))
+		return time.Time{}, false
+	}
+
+	if sessionIdle(session.LastUsedAt, now) {
+		if err := h.store.RevokeSession(userID, sessionID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return time.Time{}, false
+		}
+		utils.WriteErrorCode(w, http.StatusUnauthorized, "session_expired", errors.New("session expired due to inactivity"))
+		return time.Time{}, false
+	}
+
+	expiresAt := sessionExpiry(session.CreatedAt, now)
+	err = h.store.TouchSession(sessionID, utils.ClientIP(r), r.UserAgent(), expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteErrorCode(w, http.StatusUnauthorized, "session_expired", errors.New("session expired"))
+			return time.Time{}, false
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return time.Time{}, false
+	}
+
+	return expiresAt, true
+}
+
+// enforceSessionLimit makes room for one more session under
+// MAX_SESSIONS_PER_USER, by evicting the oldest sessions or by refusing.
+func (h *Handler) enforceSessionLimit(userID int) error {
+	limit := configs.Envs.MaxSessionsPerUser
+	if limit <= 0 {
+		return nil
+	}
+
+	if configs.Envs.SessionLimitPolicy == configs.SessionLimitRefuse {
+		n, err := h.store.CountActiveSessions(userID)
+		if err != nil {
+			return err
+		}
+		if n >= limit {
+			return errSessionLimitReached
+		}
+		return nil
+	}
+
+	_, err := h.store.RevokeOldestSessions(userID, limit-1)
+	return err
+}
+
+func writeStartSessionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errSessionLimitReached) {
+		utils.WriteErrorCode(w, http.StatusConflict, "session_limit_reached", err)
+		return
+	}
+	utils.WriteError(w, http.StatusInternalServerError, err)
+}
+
+// sessionExpiry is when a session refreshed at now lapses: one refresh
+// token lifetime later, capped at SESSION_ABSOLUTE_LIFETIME after it was
+// created.
+func sessionExpiry(createdAt, now time.Time) time.Time {
+	expiresAt := now.Add(utils.RefreshTokenTTL)
+	if lifetime := configs.Envs.SessionAbsoluteLifetime; lifetime > 0 {
+		if limit := createdAt.Add(lifetime); limit.Before(expiresAt) {
+			expiresAt = limit
+		}
+	}
+	return expiresAt
+}
+
+func sessionIdle(lastUsedAt, now time.Time) bool {
+	timeout := configs.Envs.SessionIdleTimeout
+	return timeout > 0 && now.Sub(lastUsedAt) > timeout
+}
@@ -0,0 +1,52 @@
+package user
+
+import (
+	"auth-api/configs"
+	"auth-api/utils"
+	"testing"
+	"time"
+)
+
+func TestSessionExpiry(t *testing.T) {
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		lifetime time.Duration
+		now      time.Time
+		want     time.Time
+	}{
+		{"new session", 90 * 24 * time.Hour, created, created.Add(utils.RefreshTokenTTL)},
+		{"refreshed within lifetime", 90 * 24 * time.Hour, created.Add(24 * time.Hour), created.Add(24*time.Hour + utils.RefreshTokenTTL)},
+		{"capped at lifetime", 90 * 24 * time.Hour, created.Add(80 * 24 * time.Hour), created.Add(90 * 24 * time.Hour)},
+		{"lifetime shorter than refresh token", time.Hour, created, created.Add(time.Hour)},
+		{"no absolute lifetime", 0, created.Add(365 * 24 * time.Hour), created.Add(365*24*time.Hour + utils.RefreshTokenTTL)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withEnvs(t, func(c *configs.Config) { c.SessionAbsoluteLifetime = tt.lifetime })
+
+			if got := sessionExpiry(created, tt.now); !got.Equal(tt.want) {
+				t.Errorf("sessionExpiry = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionIdle(t *testing.T) {
+	now := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	withEnvs(t, func(c *configs.Config) { c.SessionIdleTimeout = 24 * time.Hour })
+	if sessionIdle(now.Add(-23*time.Hour), now) {
+		t.Error("session used 23h ago is idle with a 24h timeout")
+	}
+	if !sessionIdle(now.Add(-25*time.Hour), now) {
+		t.Error("session used 25h ago is not idle with a 24h timeout")
+	}
+
+	configs.Envs.SessionIdleTimeout = 0
+	if sessionIdle(now.Add(-365*24*time.Hour), now) {
+		t.Error("session idle with SESSION_IDLE_TIMEOUT disabled")
+	}
+}
@@ -3,7 +3,15 @@ package user
 import (
 	"auth-api/types"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type Store struct {
@@ -14,34 +22,100 @@ func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
+// CreateUser also assigns the RBAC role named by user.Role.
 func (s *Store) CreateUser(user types.User) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	userID, err := insertUser(tx, user)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// CreateUserInOrganization creates the user already a member of orgID, so
+// a failed membership never leaves an account outside the organization of
+// the admin who created it.
+func (s *Store) CreateUserInOrganization(user types.User, orgID int, role string) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	userID, err := insertUser(tx, user)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO memberships (org_id, user_id, role)
+         VALUES ($1, $2, $3)`,
+		orgID,
+		userID,
+		role,
+	); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+func insertUser(tx *sql.Tx, user types.User) (int, error) {
 	var userID int
 
-	err := s.db.QueryRow(
-		"INSERT INTO users (username, email, password, role) VALUES ($1, $2, $3, $4) RETURNING id",
+	err := tx.QueryRow(
+		"INSERT INTO users (username, email, password, role, email_verified_at, must_change_password) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
 		user.Username,
 		user.Email,
 		user.Password,
 		user.Role,
+		user.EmailVerifiedAt,
+		user.MustChangePassword,
 	).Scan(&userID)
 
 	if err != nil {
 		return 0, err
 	}
 
+	if _, err := tx.Exec(
+		`INSERT INTO user_roles (user_id, role_id)
+         SELECT $1, id FROM roles WHERE name = $2
+         ON CONFLICT DO NOTHING`,
+		userID,
+		user.Role,
+	); err != nil {
+		return 0, err
+	}
+
 	return userID, nil
 }
 
-func (s *Store) GetUserByEmail(email string) (*types.User, error) {
-	row := s.db.QueryRow(
-		`SELECT id, username, email, password, role, created_at
-         FROM users
-         WHERE email = $1
-         LIMIT 1`,
-		email,
-	)
+const userColumns = `id, username, email, password, role, created_at, email_verified_at,
+                display_name, locale, timezone, avatar_url, username_changed_at, metadata,
+                deleted_at, purge_after, status, status_reason, status_expires_at, must_change_password,
+                active_org_id`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
 
+func scanUser(row rowScanner) (*types.User, error) {
 	var u types.User
+	var metadata []byte
 	err := row.Scan(
 		&u.ID,
 		&u.Username,
@@ -49,106 +123,505 @@ func (s *Store) GetUserByEmail(email string) (*types.User, error) {
 		&u.Password,
 		&u.Role,
 		&u.CreatedAt,
+		&u.EmailVerifiedAt,
+		&u.DisplayName,
+		&u.Locale,
+		&u.Timezone,
+		&u.AvatarURL,
+		&u.UsernameChangedAt,
+		&metadata,
+		&u.DeletedAt,
+		&u.PurgeAfter,
+		&u.Status,
+		&u.StatusReason,
+		&u.StatusExpiresAt,
+		&u.MustChangePassword,
+		&u.ActiveOrgID,
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := json.Unmarshal(metadata, &u.Metadata); err != nil {
+		return nil, err
+	}
+
 	return &u, nil
 }
 
+func (s *Store) GetUserByEmail(email string) (*types.User, error) {
+	row := s.db.QueryRow(
+		`SELECT `+userColumns+`
+         FROM users
+         WHERE email = $1
+         LIMIT 1`,
+		email,
+	)
+
+	return scanUser(row)
+}
+
 func (s *Store) GetUserByUsername(username string) (*types.User, error) {
 	row := s.db.QueryRow(
-		`SELECT id, username, email, password, role, created_at
+		`SELECT `+userColumns+`
          FROM users
          WHERE username = $1
          LIMIT 1`,
 		username,
 	)
 
-	var u types.User
-	err := row.Scan(
-		&u.ID,
-		&u.Username,
-		&u.Email,
-		&u.Password,
-		&u.Role,
-		&u.CreatedAt,
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	return &u, nil
+	return scanUser(row)
 }
 
 func (s *Store) GetUserByID(id int) (*types.User, error) {
 	row := s.db.QueryRow(
-		`SELECT id, username, email, password, role, created_at
+		`SELECT `+userColumns+`
          FROM users
          WHERE id = $1
          LIMIT 1`,
 		id,
 	)
 
-	var u types.User
+	return scanUser(row)
+}
+
+// userListColumns matches userColumns but never loads password hashes.
+const userListColumns = `id, username, email, '' AS password, role, created_at, email_verified_at,
+                display_name, locale, timezone, avatar_url, username_changed_at, metadata,
+                deleted_at, purge_after, status, status_reason, status_expires_at, must_change_password,
+                active_org_id`
+
+var userSortColumns = map[string]string{
+	types.UserSortID:        "id",
+	types.UserSortUsername:  "username",
+	types.UserSortEmail:     "email",
+	types.UserSortCreatedAt: "created_at",
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// userCursor is the keyset position after the last returned row. It records
+// the sort it was issued for so it can't be replayed against another one.
+type userCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+func encodeUserCursor(c userCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeUserCursor(raw string) (userCursor, error) {
+	var c userCursor
+
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+func (s *Store) ListUsers(params types.UserListParams) (*types.UserListResult, error) {
+	sortKey := params.Sort
+	if sortKey == "" {
+		sortKey = types.UserSortID
+	}
+	sortColumn, ok := userSortColumns[sortKey]
+	if !ok {
+		return nil, fmt.Errorf("invalid sort %q", params.Sort)
+	}
+
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if params.Role != "" {
+		where = append(where, "role = "+arg(params.Role))
+	}
+	if params.Status == types.UserStatusActive {
+		where = append(where, "(status = 'active' OR status_expires_at <= NOW())")
+	} else if params.Status != "" {
+		where = append(where, "status = "+arg(params.Status)+" AND (status_expires_at IS NULL OR status_expires_at > NOW())")
+	}
+	if params.Verified != nil {
+		if *params.Verified {
+			where = append(where, "email_verified_at IS NOT NULL")
+		} else {
+			where = append(where, "email_verified_at IS NULL")
+		}
+	}
+	if params.CreatedAfter != nil {
+		where = append(where, "created_at >= "+arg(*params.CreatedAfter))
+	}
+	if params.CreatedBefore != nil {
+		where = append(where, "created_at < "+arg(*params.CreatedBefore))
+	}
+	if params.Search != "" {
+		p := arg("%" + escapeLike(params.Search) + "%")
+		where = append(where, "(username ILIKE "+p+" OR email ILIKE "+p+")")
+	}
+	if params.OrgID != nil {
+		where = append(where, "id IN (SELECT user_id FROM memberships WHERE org_id = "+arg(*params.OrgID)+")")
+	}
+
+	filter := ""
+	if len(where) > 0 {
+		filter = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM users`+filter, args...).Scan(&total); err != nil {
+		return nil, err
+	}
+
+	cmp, order := ">", "ASC"
+	if params.Desc {
+		cmp, order = "<", "DESC"
+	}
+
+	offset := params.Offset
+	if params.Cursor != "" {
+		c, err := decodeUserCursor(params.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if c.Sort != sortKey || c.Desc != params.Desc {
+			return nil, ErrInvalidCursor
+		}
+
+		var cond string
+		switch sortColumn {
+		case "id":
+			cond = "id " + cmp + " " + arg(c.ID)
+		case "created_at":
+			cond = "(created_at, id) " + cmp + " (" + arg(c.Value) + "::timestamptz, " + arg(c.ID) + ")"
+		default:
+			cond = "(" + sortColumn + ", id) " + cmp + " (" + arg(c.Value) + "::text, " + arg(c.ID) + ")"
+		}
+
+		if filter == "" {
+			filter = " WHERE " + cond
+		} else {
+			filter += " AND " + cond
+		}
+		offset = 0
+	}
+
+	query := `SELECT ` + userListColumns + `
+                FROM users` + filter + `
+               ORDER BY ` + sortColumn + ` ` + order + `, id ` + order
+	if params.Limit > 0 {
+		query += ` LIMIT ` + arg(params.Limit)
+	}
+	query += ` OFFSET ` + arg(offset)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &types.UserListResult{Users: []types.User{}, Total: total}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		result.Users = append(result.Users, *u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if params.Limit > 0 && len(result.Users) == params.Limit {
+		last := result.Users[len(result.Users)-1]
+		c := userCursor{Sort: sortKey, Desc: params.Desc, ID: last.ID}
+		switch sortColumn {
+		case "username":
+			c.Value = last.Username
+		case "email":
+			c.Value = last.Email
+		case "created_at":
+			c.Value = last.CreatedAt.Format(time.RFC3339Nano)
+		}
+		result.NextCursor = encodeUserCursor(c)
+	}
+
+	return result, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func (s *Store) SaveRefreshToken(userID, sessionID int, token string, expiresAt time.Time) error {
+	_, err := s.db.Exec(
+		`INSERT INTO refresh (user_id, session_id, token, expires_at)
+         VALUES ($1, $2, $3, $4)`,
+		userID,
+		sessionID,
+		token,
+		expiresAt,
+	)
+	return err
+}
+
+// GetRefreshTokenSessionID returns 0 for refresh tokens issued before
+// sessions were recorded.
+func (s *Store) GetRefreshTokenSessionID(token string) (int, error) {
+	var sessionID sql.NullInt64
+	err := s.db.QueryRow(
+		`SELECT session_id
+           FROM refresh
+          WHERE token = $1`,
+		token,
+	).Scan(&sessionID)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(sessionID.Int64), nil
+}
+
+const sessionColumns = `id, user_id, device_name, user_agent, ip, expires_at, last_used_at, revoked_at, created_at`
+
+func scanSession(row rowScanner) (*types.Session, error) {
+	var session types.Session
 	err := row.Scan(
-		&u.ID,
-		&u.Username,
-		&u.Email,
-		&u.Password,
-		&u.Role,
-		&u.CreatedAt,
+		&session.ID,
+		&session.UserID,
+		&session.DeviceName,
+		&session.UserAgent,
+		&session.IP,
+		&session.ExpiresAt,
+		&session.LastUsedAt,
+		&session.RevokedAt,
+		&session.CreatedAt,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	return &u, nil
+	return &session, nil
+}
+
+func (s *Store) CreateSession(session types.Session) (int, error) {
+	var id int
+	err := s.db.QueryRow(
+		`INSERT INTO sessions (user_id, device_name, user_agent, ip, expires_at)
+         VALUES ($1, $2, $3, $4, $5)
+         RETURNING id`,
+		session.UserID,
+		session.DeviceName,
+		session.UserAgent,
+		session.IP,
+		session.ExpiresAt,
+	).Scan(&id)
+	return id, err
 }
 
-func (s *Store) ListUsers() ([]types.User, error) {
+func (s *Store) GetSession(id int) (*types.Session, error) {
+	row := s.db.QueryRow(
+		`SELECT `+sessionColumns+`
+           FROM sessions
+          WHERE id = $1`,
+		id,
+	)
+
+	return scanSession(row)
+}
+
+func (s *Store) CountActiveSessions(userID int) (int, error) {
+	var n int
+	err := s.db.QueryRow(
+		`SELECT COUNT(*)
+           FROM sessions
+          WHERE user_id = $1
+            AND revoked_at IS NULL
+            AND expires_at > NOW()`,
+		userID,
+	).Scan(&n)
+	return n, err
+}
+
+// ListSessions returns the user's sessions that are neither revoked nor
+// expired, most recently used first.
+func (s *Store) ListSessions(userID int) ([]types.Session, error) {
 	rows, err := s.db.Query(
-		`SELECT id, username, email, password, role, created_at
-         FROM users
-         ORDER BY id`,
+		`SELECT `+sessionColumns+`
+           FROM sessions
+          WHERE user_id = $1
+            AND revoked_at IS NULL
+            AND expires_at > NOW()
+          ORDER BY last_used_at DESC, id DESC`,
+		userID,
 	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var users []types.User
+	sessions := []types.Session{}
 	for rows.Next() {
-		var u types.User
-		if err := rows.Scan(
-			&u.ID,
-			&u.Username,
-			&u.Email,
-			&u.Password,
-			&u.Role,
-			&u.CreatedAt,
-		); err != nil {
+		session, err := scanSession(rows)
+		if err != nil {
 			return nil, err
 		}
-		users = append(users, u)
+		sessions = append(sessions, *session)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return users, nil
+	return sessions, nil
 }
 
-func (s *Store) SaveRefreshToken(userID int, token string, expiresAt time.Time) error {
-	_, err := s.db.Exec(
-		`INSERT INTO refresh (user_id, token, expires_at)
-         VALUES ($1, $2, $3)`,
-		userID,
-		token,
+// TouchSession records a refresh on the session. It returns sql.ErrNoRows
+// once the session has been revoked, so the refresh can be refused.
+func (s *Store) TouchSession(id int, ip, userAgent string, expiresAt time.Time) error {
+	res, err := s.db.Exec(
+		`UPDATE sessions
+            SET last_used_at = NOW(),
+                ip = $2,
+                user_agent = $3,
+                expires_at = $4
+          WHERE id = $1 AND revoked_at IS NULL`,
+		id,
+		ip,
+		userAgent,
 		expiresAt,
 	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// revokeSessions revokes the sessions matched by where, along with their
+// refresh tokens, and returns how many sessions were still active.
+func (s *Store) revokeSessions(where string, args ...any) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`UPDATE sessions
+            SET revoked_at = NOW()
+          WHERE revoked_at IS NULL AND `+where+`
+          RETURNING id`,
+		args...,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(ids) > 0 {
+		_, err = tx.Exec(
+			`UPDATE refresh
+                SET revoked = TRUE
+              WHERE session_id = ANY($1)
+                AND revoked = FALSE`,
+			pq.Array(ids),
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
+
+func (s *Store) RevokeSession(userID, id int) error {
+	n, err := s.revokeSessions(`user_id = $1 AND id = $2`, userID, id)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RevokeOtherSessions keeps only keepID; pass 0 to revoke every session.
+func (s *Store) RevokeOtherSessions(userID, keepID int) (int, error) {
+	return s.revokeSessions(`user_id = $1 AND id <> $2`, userID, keepID)
+}
+
+// RevokeOldestSessions keeps the user's keep most recently created active
+// sessions and revokes the rest.
+func (s *Store) RevokeOldestSessions(userID, keep int) (int, error) {
+	return s.revokeSessions(
+		`id IN (
+             SELECT id
+               FROM sessions
+              WHERE user_id = $1
+                AND revoked_at IS NULL
+                AND expires_at > NOW()
+              ORDER BY created_at DESC, id DESC
+             OFFSET $2
+         )`,
+		userID,
+		keep,
+	)
+}
+
+// RevokeSessionByRefreshToken ends the session a refresh token belongs to,
+// or just the token itself when it predates sessions.
+func (s *Store) RevokeSessionByRefreshToken(token string) error {
+	sessionID, err := s.GetRefreshTokenSessionID(token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	if sessionID == 0 {
+		return s.RevokeRefreshToken(token)
+	}
+
+	_, err = s.revokeSessions(`id = $1`, sessionID)
 	return err
 }
 
@@ -190,7 +663,8 @@ func (s *Store) IsRefreshTokenValid(token string) (bool, error) {
 func (s *Store) UpdatePassword(userID int, newPasswordHash string) error {
 	_, err := s.db.Exec(
 		`UPDATE users
-           SET password = $1
+           SET password = $1,
+               must_change_password = FALSE
          WHERE id = $2`,
 		newPasswordHash,
 		userID,
@@ -198,7 +672,13 @@ func (s *Store) UpdatePassword(userID int, newPasswordHash string) error {
 	return err
 }
 
+// RevokeAllRefreshTokensForUser also ends every session, including refresh
+// tokens that predate sessions.
 func (s *Store) RevokeAllRefreshTokensForUser(userID int) error {
+	if _, err := s.RevokeOtherSessions(userID, 0); err != nil {
+		return err
+	}
+
 	_, err := s.db.Exec(
 		`UPDATE refresh
            SET revoked = TRUE
@@ -208,3 +688,1323 @@ func (s *Store) RevokeAllRefreshTokensForUser(userID int) error {
 	)
 	return err
 }
+
+func (s *Store) MarkEmailVerified(userID int) error {
+	_, err := s.db.Exec(
+		`UPDATE users
+           SET email_verified_at = NOW()
+         WHERE id = $1
+           AND email_verified_at IS NULL`,
+		userID,
+	)
+	return err
+}
+
+func (s *Store) CreatePasswordReset(userID int, tokenHash string, expiresAt time.Time) error {
+	_, err := s.db.Exec(
+		`INSERT INTO password_resets (user_id, token_hash, expires_at)
+         VALUES ($1, $2, $3)`,
+		userID,
+		tokenHash,
+		expiresAt,
+	)
+	return err
+}
+
+// ConsumePasswordReset marks the token as used and returns its owner. It
+// returns sql.ErrNoRows if the token is unknown, expired or already used.
+func (s *Store) ConsumePasswordReset(tokenHash string) (int, error) {
+	var userID int
+
+	err := s.db.QueryRow(
+		`UPDATE password_resets
+           SET used_at = NOW()
+         WHERE token_hash = $1
+           AND used_at IS NULL
+           AND expires_at > NOW()
+         RETURNING user_id`,
+		tokenHash,
+	).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+func (s *Store) InvalidatePasswordResets(userID int) error {
+	_, err := s.db.Exec(
+		`UPDATE password_resets
+           SET used_at = NOW()
+         WHERE user_id = $1
+           AND used_at IS NULL`,
+		userID,
+	)
+	return err
+}
+
+// CreateEmailChange supersedes any pending change for the same user.
+func (s *Store) CreateEmailChange(change types.EmailChange) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`UPDATE email_changes
+           SET cancelled_at = NOW()
+         WHERE user_id = $1
+           AND confirmed_at IS NULL
+           AND cancelled_at IS NULL`,
+		change.UserID,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO email_changes (user_id, old_email, new_email, confirm_token_hash, cancel_token_hash, expires_at)
+         VALUES ($1, $2, $3, $4, $5, $6)`,
+		change.UserID,
+		change.OldEmail,
+		change.NewEmail,
+		change.ConfirmTokenHash,
+		change.CancelTokenHash,
+		change.ExpiresAt,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const emailChangeColumns = `id, user_id, old_email, new_email, confirm_token_hash, cancel_token_hash,
+                expires_at, confirmed_at, cancelled_at, created_at`
+
+func scanEmailChange(row rowScanner) (*types.EmailChange, error) {
+	var c types.EmailChange
+	err := row.Scan(
+		&c.ID,
+		&c.UserID,
+		&c.OldEmail,
+		&c.NewEmail,
+		&c.ConfirmTokenHash,
+		&c.CancelTokenHash,
+		&c.ExpiresAt,
+		&c.ConfirmedAt,
+		&c.CancelledAt,
+		&c.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (s *Store) GetEmailChangeByConfirmToken(tokenHash string) (*types.EmailChange, error) {
+	row := s.db.QueryRow(
+		`SELECT `+emailChangeColumns+`
+           FROM email_changes
+          WHERE confirm_token_hash = $1`,
+		tokenHash,
+	)
+
+	return scanEmailChange(row)
+}
+
+func (s *Store) GetEmailChangeByCancelToken(tokenHash string) (*types.EmailChange, error) {
+	row := s.db.QueryRow(
+		`SELECT `+emailChangeColumns+`
+           FROM email_changes
+          WHERE cancel_token_hash = $1`,
+		tokenHash,
+	)
+
+	return scanEmailChange(row)
+}
+
+// ConfirmEmailChange swaps the user's address and marks it verified, since
+// following the link proves ownership of the new mailbox.
+func (s *Store) ConfirmEmailChange(change *types.EmailChange) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`UPDATE email_changes
+           SET confirmed_at = NOW()
+         WHERE id = $1
+           AND confirmed_at IS NULL
+           AND cancelled_at IS NULL`,
+		change.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE users
+           SET email = $1,
+               email_verified_at = NOW()
+         WHERE id = $2`,
+		change.NewEmail,
+		change.UserID,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CancelEmailChange cancels a pending change, or rolls back a confirmed one
+// as long as the user's address hasn't been changed again since.
+func (s *Store) CancelEmailChange(change *types.EmailChange) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`UPDATE email_changes
+           SET cancelled_at = NOW()
+         WHERE id = $1
+           AND cancelled_at IS NULL`,
+		change.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if change.ConfirmedAt != nil {
+		if _, err := tx.Exec(
+			`UPDATE users
+               SET email = $1
+             WHERE id = $2
+               AND email = $3`,
+			change.OldEmail,
+			change.UserID,
+			change.NewEmail,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) UpdateProfile(u *types.User) error {
+	metadata, err := json.Marshal(u.Metadata)
+	if err != nil {
+		return err
+	}
+	if u.Metadata == nil {
+		metadata = []byte("{}")
+	}
+
+	_, err = s.db.Exec(
+		`UPDATE users
+           SET username = $1,
+               display_name = $2,
+               locale = $3,
+               timezone = $4,
+               avatar_url = $5,
+               username_changed_at = $6,
+               metadata = $7
+         WHERE id = $8`,
+		u.Username,
+		u.DisplayName,
+		u.Locale,
+		u.Timezone,
+		u.AvatarURL,
+		u.UsernameChangedAt,
+		metadata,
+		u.ID,
+	)
+	return err
+}
+
+func (s *Store) ListUserAttributeDefinitions() ([]types.UserAttributeDefinition, error) {
+	rows, err := s.db.Query(
+		`SELECT name, type, required, max_length, admin_only, description, created_at, updated_at
+           FROM user_attribute_definitions
+          ORDER BY name`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var defs []types.UserAttributeDefinition
+	for rows.Next() {
+		var d types.UserAttributeDefinition
+		if err := rows.Scan(
+			&d.Name,
+			&d.Type,
+			&d.Required,
+			&d.MaxLength,
+			&d.AdminOnly,
+			&d.Description,
+			&d.CreatedAt,
+			&d.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		defs = append(defs, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return defs, nil
+}
+
+func (s *Store) UpsertUserAttributeDefinition(def types.UserAttributeDefinition) error {
+	_, err := s.db.Exec(
+		`INSERT INTO user_attribute_definitions (name, type, required, max_length, admin_only, description)
+         VALUES ($1, $2, $3, $4, $5, $6)
+         ON CONFLICT (name) DO UPDATE
+           SET type = EXCLUDED.type,
+               required = EXCLUDED.required,
+               max_length = EXCLUDED.max_length,
+               admin_only = EXCLUDED.admin_only,
+               description = EXCLUDED.description,
+               updated_at = NOW()`,
+		def.Name,
+		def.Type,
+		def.Required,
+		def.MaxLength,
+		def.AdminOnly,
+		def.Description,
+	)
+	return err
+}
+
+func (s *Store) DeleteUserAttributeDefinition(name string) error {
+	res, err := s.db.Exec(
+		`DELETE FROM user_attribute_definitions
+         WHERE name = $1`,
+		name,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Store) SoftDeleteUser(userID int, purgeAfter time.Time) error {
+	_, err := s.db.Exec(
+		`UPDATE users
+           SET deleted_at = NOW(),
+               purge_after = $1
+         WHERE id = $2
+           AND deleted_at IS NULL`,
+		purgeAfter,
+		userID,
+	)
+	return err
+}
+
+func (s *Store) RestoreUser(userID int) error {
+	_, err := s.db.Exec(
+		`UPDATE users
+           SET deleted_at = NULL,
+               purge_after = NULL
+         WHERE id = $1`,
+		userID,
+	)
+	return err
+}
+
+// PurgeDeletedUsers hard-deletes accounts whose grace period has ended.
+func (s *Store) PurgeDeletedUsers() (int64, error) {
+	return s.deleteUsersWhere(`deleted_at IS NOT NULL AND purge_after <= NOW()`)
+}
+
+func (s *Store) DeleteUser(userID int) error {
+	n, err := s.deleteUsersWhere(`id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// deleteUsersWhere removes matching users. Rows they own go with them via
+// ON DELETE CASCADE; audit events that merely mention them are kept but
+// stripped of personal data.
+func (s *Store) deleteUsersWhere(cond string, args ...any) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`UPDATE audit_events
+           SET metadata = '{}'::jsonb,
+               ip = '',
+               user_agent = ''
+         WHERE actor_id IN (SELECT id FROM users WHERE `+cond+`)
+            OR (target_type = 'user' AND target_id IN (SELECT id::text FROM users WHERE `+cond+`))`,
+		args...,
+	); err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec(`DELETE FROM users WHERE `+cond, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
+
+func (s *Store) GetUserDataExport(userID int) (*types.UserDataExport, error) {
+	u, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &types.UserDataExport{
+		GeneratedAt:    time.Now().UTC(),
+		Profile:        u,
+		Sessions:       []types.SessionExport{},
+		Organizations:  []types.OrganizationMembershipExport{},
+		Groups:         []types.GroupMembershipExport{},
+		EmailChanges:   []types.EmailChangeExport{},
+		PasswordResets: []types.PasswordResetExport{},
+		AuditEvents:    []types.AuditEvent{},
+	}
+
+	rows, err := s.db.Query(
+		`SELECT id, revoked, expires_at, created_at
+           FROM refresh
+          WHERE user_id = $1
+          ORDER BY id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e types.SessionExport
+		if err := rows.Scan(&e.ID, &e.Revoked, &e.ExpiresAt, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		export.Sessions = append(export.Sessions, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(
+		`SELECT `+sessionColumns+`
+           FROM sessions
+          WHERE user_id = $1
+          ORDER BY id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	export.DeviceSessions = []types.DeviceSessionExport{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		export.DeviceSessions = append(export.DeviceSessions, types.DeviceSessionExport{
+			ID:         session.ID,
+			DeviceName: session.DeviceName,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			ExpiresAt:  session.ExpiresAt,
+			LastUsedAt: session.LastUsedAt,
+			RevokedAt:  session.RevokedAt,
+			CreatedAt:  session.CreatedAt,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(
+		`SELECT `+personalAccessTokenColumns+`
+           FROM personal_access_tokens
+          WHERE user_id = $1
+          ORDER BY id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	export.PersonalAccessTokens = []types.PersonalAccessTokenExport{}
+	for rows.Next() {
+		t, err := scanPersonalAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		export.PersonalAccessTokens = append(export.PersonalAccessTokens, types.PersonalAccessTokenExport{
+			Name:       t.Name,
+			Prefix:     t.Prefix,
+			Scopes:     t.Scopes,
+			ExpiresAt:  t.ExpiresAt,
+			LastUsedAt: t.LastUsedAt,
+			LastUsedIP: t.LastUsedIP,
+			RevokedAt:  t.RevokedAt,
+			CreatedAt:  t.CreatedAt,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(
+		`SELECT m.org_id, o.slug, o.name, m.role, m.created_at
+           FROM memberships m
+           JOIN organizations o ON o.id = m.org_id
+          WHERE m.user_id = $1
+          ORDER BY m.created_at`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e types.OrganizationMembershipExport
+		if err := rows.Scan(&e.OrganizationID, &e.OrganizationSlug, &e.OrganizationName, &e.Role, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		export.Organizations = append(export.Organizations, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(
+		`SELECT g.id, g.name, gm.created_at
+           FROM group_members gm
+           JOIN groups g ON g.id = gm.group_id
+          WHERE gm.user_id = $1
+          ORDER BY gm.created_at`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e types.GroupMembershipExport
+		if err := rows.Scan(&e.GroupID, &e.GroupName, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		export.Groups = append(export.Groups, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if export.LoginHistory, err = s.queryLoginAttempts(
+		`SELECT `+loginAttemptColumns+`
+           FROM login_attempts
+          WHERE user_id = $1
+          ORDER BY id`,
+		userID,
+	); err != nil {
+		return nil, err
+	}
+
+	if export.Lockouts, err = s.ListLoginLockoutsForUser(userID); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(
+		`SELECT old_email, new_email, confirmed_at, cancelled_at, created_at
+           FROM email_changes
+          WHERE user_id = $1
+          ORDER BY id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e types.EmailChangeExport
+		if err := rows.Scan(&e.OldEmail, &e.NewEmail, &e.ConfirmedAt, &e.CancelledAt, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		export.EmailChanges = append(export.EmailChanges, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(
+		`SELECT expires_at, used_at, created_at
+           FROM password_resets
+          WHERE user_id = $1
+          ORDER BY id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e types.PasswordResetExport
+		if err := rows.Scan(&e.ExpiresAt, &e.UsedAt, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		export.PasswordResets = append(export.PasswordResets, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(
+		`SELECT id, actor_id, action, target_type, target_id, metadata, ip, user_agent, created_at
+           FROM audit_events
+          WHERE actor_id = $1
+             OR (target_type = 'user' AND target_id = $2)
+          ORDER BY id`,
+		userID,
+		strconv.Itoa(userID),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e types.AuditEvent
+		var metadata []byte
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.Action, &e.TargetType, &e.TargetID, &metadata, &e.IP, &e.UserAgent, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(metadata, &e.Metadata); err != nil {
+			return nil, err
+		}
+		export.AuditEvents = append(export.AuditEvents, redactAuditEvent(e, userID))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// redactAuditEvent hides who acted on the user and from where, for events
+// they did not perform themselves, such as admin changes and impersonation.
+func redactAuditEvent(e types.AuditEvent, userID int) types.AuditEvent {
+	if e.ActorID != nil && *e.ActorID == userID {
+		return e
+	}
+	e.ActorID = nil
+	e.IP = ""
+	e.UserAgent = ""
+	return e
+}
+
+// CountUserRecords estimates the size of a user's export so large accounts
+// can be exported in the background.
+func (s *Store) CountUserRecords(userID int) (int, error) {
+	var n int
+
+	err := s.db.QueryRow(
+		`SELECT (SELECT COUNT(*) FROM refresh WHERE user_id = $1)
+              + (SELECT COUNT(*) FROM email_changes WHERE user_id = $1)
+              + (SELECT COUNT(*) FROM password_resets WHERE user_id = $1)
+              + (SELECT COUNT(*) FROM sessions WHERE user_id = $1)
+              + (SELECT COUNT(*) FROM personal_access_tokens WHERE user_id = $1)
+              + (SELECT COUNT(*) FROM memberships WHERE user_id = $1)
+              + (SELECT COUNT(*) FROM group_members WHERE user_id = $1)
+              + (SELECT COUNT(*) FROM login_attempts WHERE user_id = $1)
+              + (SELECT COUNT(*) FROM login_lockouts WHERE user_id = $1)
+              + (SELECT COUNT(*) FROM audit_events WHERE actor_id = $1 OR (target_type = 'user' AND target_id = $1::text))`,
+		userID,
+	).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (s *Store) CreateDataExport(userID, requestedBy int, format string, expiresAt time.Time) (int, error) {
+	var id int
+
+	err := s.db.QueryRow(
+		`INSERT INTO data_exports (user_id, requested_by, format, expires_at)
+         VALUES ($1, $2, $3, $4)
+         RETURNING id`,
+		userID,
+		requestedBy,
+		format,
+		expiresAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (s *Store) CompleteDataExport(id int, data []byte) error {
+	_, err := s.db.Exec(
+		`UPDATE data_exports
+           SET status = 'ready',
+               data = $1,
+               completed_at = NOW()
+         WHERE id = $2`,
+		data,
+		id,
+	)
+	return err
+}
+
+func (s *Store) FailDataExport(id int, reason string) error {
+	_, err := s.db.Exec(
+		`UPDATE data_exports
+           SET status = 'failed',
+               error = $1,
+               completed_at = NOW()
+         WHERE id = $2`,
+		reason,
+		id,
+	)
+	return err
+}
+
+func (s *Store) GetDataExport(id int) (*types.DataExport, error) {
+	var e types.DataExport
+
+	err := s.db.QueryRow(
+		`SELECT id, user_id, requested_by, format, status, data, error, expires_at, completed_at, created_at
+           FROM data_exports
+          WHERE id = $1`,
+		id,
+	).Scan(
+		&e.ID,
+		&e.UserID,
+		&e.RequestedBy,
+		&e.Format,
+		&e.Status,
+		&e.Data,
+		&e.Error,
+		&e.ExpiresAt,
+		&e.CompletedAt,
+		&e.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+func (s *Store) DeleteExpiredDataExports() (int64, error) {
+	res, err := s.db.Exec(
+		`DELETE FROM data_exports
+         WHERE expires_at <= NOW()`,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func (s *Store) UpdateUserStatus(userID int, status, reason string, expiresAt *time.Time) error {
+	res, err := s.db.Exec(
+		`UPDATE users
+           SET status = $1,
+               status_reason = $2,
+               status_expires_at = $3
+         WHERE id = $4`,
+		status,
+		reason,
+		expiresAt,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RevokeAccessTokensForUser invalidates every access token issued to the
+// user up to now; see IsAccessTokenRevoked. The cutoff is taken from the
+// clock that stamps iat, at the same microsecond precision, so a token
+// minted earlier in the same second no longer survives.
+func (s *Store) RevokeAccessTokensForUser(userID int) error {
+	_, err := s.db.Exec(
+		`UPDATE users
+           SET tokens_invalid_before = $2
+         WHERE id = $1`,
+		userID,
+		time.Now().UTC().Truncate(time.Microsecond),
+	)
+	return err
+}
+
+// IsAccessTokenRevoked reports whether a still-unexpired access token must
+// be refused: its user is gone, deleted or not active, its session was
+// revoked or has expired, or the token was issued before the last forced logout. A
+// sessionID of 0 skips the session check.
+func (s *Store) IsAccessTokenRevoked(userID, sessionID int, issuedAt time.Time) (bool, error) {
+	var u types.User
+	var invalidBefore, lastUsedAt *time.Time
+	var sessionActive bool
+
+	err := s.db.QueryRow(
+		`SELECT u.deleted_at, u.status, u.status_expires_at, u.tokens_invalid_before,
+                COALESCE($2 = 0 OR (s.revoked_at IS NULL AND s.expires_at > NOW()), FALSE),
+                s.last_used_at
+           FROM users u
+           LEFT JOIN sessions s ON s.id = $2 AND s.user_id = u.id
+          WHERE u.id = $1`,
+		userID,
+		sessionID,
+	).Scan(&u.DeletedAt, &u.Status, &u.StatusExpiresAt, &invalidBefore, &sessionActive, &lastUsedAt)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if u.IsDeleted() || u.CurrentStatus() != types.UserStatusActive || !sessionActive {
+		return true, nil
+	}
+
+	if invalidBefore != nil && issuedBefore(issuedAt, *invalidBefore) {
+		return true, nil
+	}
+
+	if sessionID != 0 {
+		if lastUsedAt == nil || sessionIdle(*lastUsedAt, time.Now().UTC()) {
+			return true, nil
+		}
+		if err := s.touchSessionUse(sessionID); err != nil {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+// touchSessionUse records that a session's access token was used, so API
+// traffic counts as activity for SESSION_IDLE_TIMEOUT. Writes are limited
+// to one a minute per session.
+func (s *Store) touchSessionUse(id int) error {
+	_, err := s.db.Exec(
+		`UPDATE sessions
+            SET last_used_at = NOW()
+          WHERE id = $1
+            AND last_used_at < NOW() - INTERVAL '1 minute'`,
+		id,
+	)
+	return err
+}
+
+// issuedBefore reports whether a token was issued at or before the cutoff.
+// Tokens minted with a whole-second iat compare as the start of that
+// second, so one from the cutoff's second is refused even if it came after.
+func issuedBefore(issuedAt, invalidBefore time.Time) bool {
+	return !issuedAt.After(invalidBefore)
+}
+
+// UpdateUserAccount clears email_verified_at when the email changes; the
+// new address has not been shown to belong to the user.
+func (s *Store) UpdateUserAccount(userID int, username, email string) error {
+	res, err := s.db.Exec(
+		`UPDATE users
+           SET username = $1,
+               email = $2,
+               email_verified_at = CASE WHEN email = $2 THEN email_verified_at END
+         WHERE id = $3`,
+		username,
+		email,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetTemporaryPassword replaces the password and forces the user to choose
+// a new one on next login.
+func (s *Store) SetTemporaryPassword(userID int, newPasswordHash string) error {
+	res, err := s.db.Exec(
+		`UPDATE users
+           SET password = $1,
+               must_change_password = TRUE
+         WHERE id = $2`,
+		newPasswordHash,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+const personalAccessTokenColumns = `id, user_id, name, token_prefix, scopes, expires_at, last_used_at, last_used_ip, revoked_at, created_at`
+
+func scanPersonalAccessToken(row rowScanner) (*types.PersonalAccessToken, error) {
+	var t types.PersonalAccessToken
+	err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Name,
+		&t.Prefix,
+		pq.Array(&t.Scopes),
+		&t.ExpiresAt,
+		&t.LastUsedAt,
+		&t.LastUsedIP,
+		&t.RevokedAt,
+		&t.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func (s *Store) CreatePersonalAccessToken(token types.PersonalAccessToken, tokenHash string) (int, error) {
+	var id int
+	err := s.db.QueryRow(
+		`INSERT INTO personal_access_tokens (user_id, name, token_prefix, token_hash, scopes, expires_at)
+         VALUES ($1, $2, $3, $4, $5, $6)
+         RETURNING id`,
+		token.UserID,
+		token.Name,
+		token.Prefix,
+		tokenHash,
+		pq.Array(token.Scopes),
+		token.ExpiresAt,
+	).Scan(&id)
+	return id, err
+}
+
+// ListPersonalAccessTokens returns the user's tokens that have not been
+// revoked, including expired ones so they can be cleaned up.
+func (s *Store) ListPersonalAccessTokens(userID int) ([]types.PersonalAccessToken, error) {
+	rows, err := s.db.Query(
+		`SELECT `+personalAccessTokenColumns+`
+           FROM personal_access_tokens
+          WHERE user_id = $1 AND revoked_at IS NULL
+          ORDER BY id DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []types.PersonalAccessToken{}
+	for rows.Next() {
+		t, err := scanPersonalAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// GetPersonalAccessTokenByHash only finds tokens that are neither revoked
+// nor expired.
+func (s *Store) GetPersonalAccessTokenByHash(tokenHash string) (*types.PersonalAccessToken, error) {
+	row := s.db.QueryRow(
+		`SELECT `+personalAccessTokenColumns+`
+           FROM personal_access_tokens
+          WHERE token_hash = $1
+            AND revoked_at IS NULL
+            AND (expires_at IS NULL OR expires_at > NOW())`,
+		tokenHash,
+	)
+
+	return scanPersonalAccessToken(row)
+}
+
+// TouchPersonalAccessToken records usage at most once a minute per token so
+// busy scripts do not turn every request into a write.
+func (s *Store) TouchPersonalAccessToken(id int, ip string) error {
+	_, err := s.db.Exec(
+		`UPDATE personal_access_tokens
+           SET last_used_at = NOW(),
+               last_used_ip = $2
+         WHERE id = $1
+           AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute' OR last_used_ip <> $2)`,
+		id,
+		ip,
+	)
+	return err
+}
+
+func (s *Store) RevokePersonalAccessToken(userID, id int) error {
+	res, err := s.db.Exec(
+		`UPDATE personal_access_tokens
+           SET revoked_at = NOW()
+         WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`,
+		id,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Store) RevokePersonalAccessTokensForUser(userID int) error {
+	_, err := s.db.Exec(
+		`UPDATE personal_access_tokens
+           SET revoked_at = NOW()
+         WHERE user_id = $1 AND revoked_at IS NULL`,
+		userID,
+	)
+	return err
+}
+
+const lockoutColumns = `id, kind, key, user_id, failed_count, last_failed_at, last_failed_ip, retry_after, locked_until, created_at`
+
+func scanLoginLockout(row rowScanner) (*types.LoginLockout, error) {
+	var l types.LoginLockout
+	err := row.Scan(
+		&l.ID,
+		&l.Kind,
+		&l.Key,
+		&l.UserID,
+		&l.FailedCount,
+		&l.LastFailedAt,
+		&l.LastFailedIP,
+		&l.RetryAfter,
+		&l.LockedUntil,
+		&l.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (s *Store) queryLoginLockouts(query string, args ...any) ([]types.LoginLockout, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lockouts := []types.LoginLockout{}
+	for rows.Next() {
+		l, err := scanLoginLockout(rows)
+		if err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, *l)
+	}
+
+	return lockouts, rows.Err()
+}
+
+func (s *Store) GetLoginLockout(kind, key string) (*types.LoginLockout, error) {
+	row := s.db.QueryRow(
+		`SELECT `+lockoutColumns+`
+           FROM login_lockouts
+          WHERE kind = $1 AND key = $2`,
+		kind,
+		key,
+	)
+
+	return scanLoginLockout(row)
+}
+
+// RecordLoginFailure counts one more failure and returns the new state. The
+// count starts over when the previous failure is older than window or a
+// lockout has run out.
+func (s *Store) RecordLoginFailure(kind, key string, userID *int, ip string, window time.Duration) (*types.LoginLockout, error) {
+	row := s.db.QueryRow(
+		`INSERT INTO login_lockouts (kind, key, user_id, failed_count, last_failed_ip)
+         VALUES ($1, $2, $3, 1, $4)
+         ON CONFLICT (kind, key) DO UPDATE
+            SET failed_count = CASE
+                    WHEN login_lockouts.last_failed_at < $5 OR login_lockouts.locked_until <= NOW() THEN 1
+                    ELSE login_lockouts.failed_count + 1
+                END,
+                locked_until = CASE
+                    WHEN login_lockouts.locked_until <= NOW() THEN NULL
+                    ELSE login_lockouts.locked_until
+                END,
+                unlock_token_hash = CASE
+                    WHEN login_lockouts.locked_until <= NOW() THEN NULL
+                    ELSE login_lockouts.unlock_token_hash
+                END,
+                user_id = COALESCE(EXCLUDED.user_id, login_lockouts.user_id),
+                last_failed_at = NOW(),
+                last_failed_ip = EXCLUDED.last_failed_ip
+         RETURNING `+lockoutColumns,
+		kind,
+		key,
+		userID,
+		ip,
+		time.Now().UTC().Add(-window),
+	)
+
+	return scanLoginLockout(row)
+}
+
+// SetLoginLockoutDeadlines stores the backoff and lockout deadlines. An
+// empty unlockTokenHash keeps the current unlock link, if any.
+func (s *Store) SetLoginLockoutDeadlines(id int, retryAfter, lockedUntil *time.Time, unlockTokenHash string) error {
+	_, err := s.db.Exec(
+		`UPDATE login_lockouts
+           SET retry_after = $2,
+               locked_until = $3,
+               unlock_token_hash = COALESCE(NULLIF($4, ''), unlock_token_hash)
+         WHERE id = $1`,
+		id,
+		retryAfter,
+		lockedUntil,
+		unlockTokenHash,
+	)
+	return err
+}
+
+func (s *Store) ClearLoginLockout(kind, key string) error {
+	_, err := s.db.Exec(
+		`DELETE FROM login_lockouts WHERE kind = $1 AND key = $2`,
+		kind,
+		key,
+	)
+	return err
+}
+
+// ConsumeUnlockToken ends the lockout the token was mailed for and returns
+// the account's owner. It returns sql.ErrNoRows if the token is unknown or
+// the lockout is already over.
+func (s *Store) ConsumeUnlockToken(tokenHash string) (int, error) {
+	var userID int
+
+	err := s.db.QueryRow(
+		`DELETE FROM login_lockouts
+         WHERE unlock_token_hash = $1
+           AND locked_until > NOW()
+           AND user_id IS NOT NULL
+         RETURNING user_id`,
+		tokenHash,
+	).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// ClearLoginLockoutsForUser removes the account's counter and those of the
+// identifiers that last resolved to it.
+func (s *Store) ClearLoginLockoutsForUser(userID int) (int, error) {
+	res, err := s.db.Exec(`DELETE FROM login_lockouts WHERE user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *Store) ListLoginLockouts(lockedOnly bool) ([]types.LoginLockout, error) {
+	return s.queryLoginLockouts(
+		`SELECT `+lockoutColumns+`
+           FROM login_lockouts
+          WHERE NOT $1 OR locked_until > NOW()
+          ORDER BY last_failed_at DESC
+          LIMIT 500`,
+		lockedOnly,
+	)
+}
+
+func (s *Store) ListLoginLockoutsForUser(userID int) ([]types.LoginLockout, error) {
+	return s.queryLoginLockouts(
+		`SELECT `+lockoutColumns+`
+           FROM login_lockouts
+          WHERE user_id = $1
+          ORDER BY last_failed_at DESC`,
+		userID,
+	)
+}
+
+func (s *Store) DeleteLoginLockout(id int) (*types.LoginLockout, error) {
+	row := s.db.QueryRow(
+		`DELETE FROM login_lockouts
+         WHERE id = $1
+         RETURNING `+lockoutColumns,
+		id,
+	)
+
+	return scanLoginLockout(row)
+}
+
+// DeleteStaleLoginLockouts drops counters whose last failure is older than
+// window, unless a lockout is still running.
+func (s *Store) DeleteStaleLoginLockouts(window time.Duration) (int64, error) {
+	res, err := s.db.Exec(
+		`DELETE FROM login_lockouts
+         WHERE last_failed_at < $1
+           AND (locked_until IS NULL OR locked_until <= NOW())`,
+		time.Now().UTC().Add(-window),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *Store) RecordLoginAttempt(attempt types.LoginAttempt) error {
+	_, err := s.db.Exec(
+		`INSERT INTO login_attempts (user_id, identifier, method, outcome, ip, network, user_agent, device_name, new_device)
+         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		attempt.UserID,
+		attempt.Identifier,
+		attempt.Method,
+		attempt.Outcome,
+		attempt.IP,
+		attempt.Network,
+		attempt.UserAgent,
+		attempt.DeviceName,
+		attempt.NewDevice,
+	)
+	return err
+}
+
+// GetLoginFamiliarity compares deviceName and network with the user's
+// earlier successful logins that are still in the history.
+func (s *Store) GetLoginFamiliarity(userID int, deviceName, network string) (*types.LoginFamiliarity, error) {
+	var f types.LoginFamiliarity
+	err := s.db.QueryRow(
+		`SELECT COUNT(*) > 0,
+                COALESCE(BOOL_OR(device_name = $2), FALSE),
+                COALESCE(BOOL_OR(network = $3), FALSE)
+           FROM login_attempts
+          WHERE user_id = $1
+            AND outcome = $4`,
+		userID,
+		deviceName,
+		network,
+		types.LoginOutcomeSuccess,
+	).Scan(&f.HasHistory, &f.KnownDevice, &f.KnownNetwork)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+const loginAttemptColumns = `id, user_id, identifier, method, outcome, ip, network, user_agent, device_name, new_device, created_at`
+
+func (s *Store) ListLoginAttempts(userID, limit, offset int) ([]types.LoginAttempt, error) {
+	return s.queryLoginAttempts(
+		`SELECT `+loginAttemptColumns+`
+           FROM login_attempts
+          WHERE user_id = $1
+          ORDER BY created_at DESC, id DESC
+          LIMIT $2 OFFSET $3`,
+		userID,
+		limit,
+		offset,
+	)
+}
+
+func (s *Store) queryLoginAttempts(query string, args ...any) ([]types.LoginAttempt, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []types.LoginAttempt{}
+	for rows.Next() {
+		var a types.LoginAttempt
+		err := rows.Scan(
+			&a.ID,
+			&a.UserID,
+			&a.Identifier,
+			&a.Method,
+			&a.Outcome,
+			&a.IP,
+			&a.Network,
+			&a.UserAgent,
+			&a.DeviceName,
+			&a.NewDevice,
+			&a.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+
+	return attempts, rows.Err()
+}
+
+func (s *Store) DeleteOldLoginAttempts(retention time.Duration) (int64, error) {
+	res, err := s.db.Exec(
+		`DELETE FROM login_attempts WHERE created_at < $1`,
+		time.Now().UTC().Add(-retention),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
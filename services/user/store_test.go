@@ -0,0 +1,52 @@
+package user
+
+import (
+	"auth-api/types"
+	"testing"
+	"time"
+)
+
+func TestRedactAuditEvent(t *testing.T) {
+	self, admin := 7, 1
+
+	own := redactAuditEvent(types.AuditEvent{ActorID: &self, IP: "198.51.100.1", UserAgent: "curl"}, self)
+	if own.ActorID == nil || own.IP == "" || own.UserAgent == "" {
+		t.Errorf("own event was redacted: %+v", own)
+	}
+
+	for _, actor := range []*int{&admin, nil} {
+		e := redactAuditEvent(types.AuditEvent{ActorID: actor, IP: "203.0.113.9", UserAgent: "admin-browser", Action: "user.update"}, self)
+		if e.ActorID != nil || e.IP != "" || e.UserAgent != "" {
+			t.Errorf("event by %v kept actor details: %+v", actor, e)
+		}
+		if e.Action != "user.update" {
+			t.Errorf("action = %q, want it kept", e.Action)
+		}
+	}
+}
+
+func TestIssuedBefore(t *testing.T) {
+	cutoff := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		issuedAt      time.Time
+		invalidBefore time.Time
+		want          bool
+	}{
+		{"earlier second", cutoff.Add(-time.Second), cutoff, true},
+		{"earlier in the same second", cutoff.Add(300 * time.Millisecond), cutoff.Add(700 * time.Millisecond), true},
+		{"at the cutoff", cutoff, cutoff, true},
+		{"later in the same second", cutoff.Add(700 * time.Millisecond), cutoff.Add(300 * time.Millisecond), false},
+		{"whole-second iat in the cutoff second", cutoff, cutoff.Add(700 * time.Millisecond), true},
+		{"later", cutoff.Add(time.Second), cutoff, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := issuedBefore(tt.issuedAt, tt.invalidBefore); got != tt.want {
+				t.Errorf("issuedBefore = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package user
+
+import (
+	"auth-api/types"
+	"auth-api/utils"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// patDisplayPrefixLen is how much of a token is kept in clear so users can
+// tell their tokens apart, e.g. "pat_Xy3kq9Ab".
+const patDisplayPrefixLen = 12
+
+func (h *Handler) handleListPersonalAccessTokens(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserIDFromContext(r.Context())
+	if !ok {
+		utils.WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	tokens, err := h.store.ListPersonalAccessTokens(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, tokens)
+}
+
+// handleCreatePersonalAccessToken returns the token exactly once; only its
+// hash is stored. Scopes may not exceed the caller's current permissions.
+func (h *Handler) handleCreatePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserIDFromContext(r.Context())
+	if !ok {
+		utils.WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	var payload types.CreatePersonalAccessTokenPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now().UTC()) {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("expiresAt must be in the future"))
+		return
+	}
+
+	u, err := h.store.GetUserByID(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	opts, err := h.accessTokenOptions(u)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	scopes := []string{}
+	for _, scope := range payload.Scopes {
+		if !slices.Contains(opts.Permissions, scope) {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("scope %q is not granted to you", scope))
+			return
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	slices.Sort(scopes)
+
+	secret, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	rawToken := utils.PersonalAccessTokenPrefix + secret
+
+	token := types.PersonalAccessToken{
+		UserID:    userID,
+		Name:      payload.Name,
+		Prefix:    rawToken[:patDisplayPrefixLen],
+		Scopes:    scopes,
+		ExpiresAt: payload.ExpiresAt,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	token.ID, err = h.store.CreatePersonalAccessToken(token, utils.HashToken(rawToken))
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.recordUserAudit(r, "user.token_create", userID, map[string]any{
+		"tokenId": token.ID,
+		"name":    token.Name,
+		"scopes":  token.Scopes,
+	})
+
+	utils.WriteJSON(w, http.StatusCreated, map[string]any{
+		"token":               rawToken,
+		"personalAccessToken": token,
+	})
+}
+
+func (h *Handler) handleRevokePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserIDFromContext(r.Context())
+	if !ok {
+		utils.WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	tokenID, err := strconv.Atoi(mux.Vars(r)["tokenId"])
+	if err != nil || tokenID <= 0 {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("invalid token id"))
+		return
+	}
+
+	if err := h.store.RevokePersonalAccessToken(userID, tokenID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteError(w, http.StatusNotFound, errors.New("token not found"))
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.recordUserAudit(r, "user.token_revoke", userID, map[string]any{
+		"tokenId": tokenID,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": "token revoked",
+	})
+}
+
+// ResolvePersonalAccessToken implements utils.PersonalAccessTokenResolver.
+// Permissions are re-resolved on every request and narrowed to the token's
+// scopes, so removing a role takes effect immediately.
+func (h *Handler) ResolvePersonalAccessToken(rawToken, ip string) (*utils.CustomClaims, error) {
+	token, err := h.store.GetPersonalAccessTokenByHash(utils.HashToken(rawToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.ErrInvalidPersonalAccessToken
+		}
+		return nil, err
+	}
+
+	u, err := h.store.GetUserByID(token.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.ErrInvalidPersonalAccessToken
+		}
+		return nil, err
+	}
+
+	if u.IsDeleted() || u.CurrentStatus() != types.UserStatusActive {
+		return nil, utils.ErrInvalidPersonalAccessToken
+	}
+
+	opts, err := h.accessTokenOptions(u)
+	if err != nil {
+		return nil, err
+	}
+
+	granted := []string{}
+	for _, scope := range token.Scopes {
+		if slices.Contains(opts.Permissions, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	opts.Permissions = granted
+
+	if err := h.store.TouchPersonalAccessToken(token.ID, ip); err != nil {
+		log.Printf("personal access token %d: failed to record usage: %v", token.ID, err)
+	}
+
+	return utils.NewPersonalAccessTokenClaims(u.ID, token.ID, token.CreatedAt, opts), nil
+}
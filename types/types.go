@@ -9,21 +9,623 @@ type User struct {
 	Password  string    `json:"-"`
 	Role      string    `json:"role"`
 	CreatedAt time.Time `json:"createdAt"`
+
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+
+	DisplayName       string         `json:"displayName"`
+	Locale            string         `json:"locale"`
+	Timezone          string         `json:"timezone"`
+	AvatarURL         string         `json:"avatarUrl"`
+	UsernameChangedAt *time.Time     `json:"usernameChangedAt"`
+	Metadata          map[string]any `json:"metadata"`
+
+	DeletedAt  *time.Time `json:"deletedAt"`
+	PurgeAfter *time.Time `json:"purgeAfter"`
+
+	Status          string     `json:"status"`
+	StatusReason    string     `json:"statusReason"`
+	StatusExpiresAt *time.Time `json:"statusExpiresAt"`
+
+	MustChangePassword bool `json:"mustChangePassword"`
+
+	ActiveOrgID *int `json:"activeOrganizationId"`
 }
 
+const (
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+	UserStatusDisabled  = "disabled"
+	UserStatusLocked    = "locked"
+)
+
+func (u *User) IsDeleted() bool {
+	return u.DeletedAt != nil
+}
+
+// CurrentStatus treats a status whose expiry has passed as active again.
+func (u *User) CurrentStatus() string {
+	if u.Status == "" {
+		return UserStatusActive
+	}
+	if u.StatusExpiresAt != nil && time.Now().UTC().After(*u.StatusExpiresAt) {
+		return UserStatusActive
+	}
+	return u.Status
+}
+
+func (u *User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// UserListParams filters and pages ListUsers. When Cursor is set, Offset is
+// ignored and results continue after the cursor's position. A Limit of 0
+// returns every matching user.
+type UserListParams struct {
+	Limit         int
+	Offset        int
+	Cursor        string
+	Role          string
+	Status        string
+	Verified      *bool
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Search        string
+	Sort          string
+	Desc          bool
+	OrgID         *int
+}
+
+type UserListResult struct {
+	Users      []User
+	Total      int
+	NextCursor string
+}
+
+const (
+	UserSortID        = "id"
+	UserSortUsername  = "username"
+	UserSortEmail     = "email"
+	UserSortCreatedAt = "createdAt"
+)
+
 type UserStore interface {
 	CreateUser(User) (int, error)
+	CreateUserInOrganization(user User, orgID int, role string) (int, error)
 	GetUserByEmail(email string) (*User, error)
 	GetUserByUsername(username string) (*User, error)
 	GetUserByID(id int) (*User, error)
-	ListUsers() ([]User, error)
+	ListUsers(params UserListParams) (*UserListResult, error)
 
-	SaveRefreshToken(userID int, token string, expiresAt time.Time) error
+	SaveRefreshToken(userID, sessionID int, token string, expiresAt time.Time) error
 	RevokeRefreshToken(token string) error
 	IsRefreshTokenValid(token string) (bool, error)
+	GetRefreshTokenSessionID(token string) (int, error)
+
+	CreateSession(session Session) (int, error)
+	GetSession(id int) (*Session, error)
+	ListSessions(userID int) ([]Session, error)
+	CountActiveSessions(userID int) (int, error)
+	RevokeOldestSessions(userID, keep int) (int, error)
+	TouchSession(id int, ip, userAgent string, expiresAt time.Time) error
+	RevokeSession(userID, id int) error
+	RevokeOtherSessions(userID, keepID int) (int, error)
+	RevokeSessionByRefreshToken(token string) error
 
 	UpdatePassword(userID int, newPasswordHash string) error
 	RevokeAllRefreshTokensForUser(userID int) error
+
+	MarkEmailVerified(userID int) error
+
+	CreatePasswordReset(userID int, tokenHash string, expiresAt time.Time) error
+	ConsumePasswordReset(tokenHash string) (int, error)
+	InvalidatePasswordResets(userID int) error
+
+	CreateEmailChange(change EmailChange) error
+	GetEmailChangeByConfirmToken(tokenHash string) (*EmailChange, error)
+	GetEmailChangeByCancelToken(tokenHash string) (*EmailChange, error)
+	ConfirmEmailChange(change *EmailChange) error
+	CancelEmailChange(change *EmailChange) error
+
+	UpdateProfile(u *User) error
+	ListUserAttributeDefinitions() ([]UserAttributeDefinition, error)
+	UpsertUserAttributeDefinition(def UserAttributeDefinition) error
+	DeleteUserAttributeDefinition(name string) error
+
+	SoftDeleteUser(userID int, purgeAfter time.Time) error
+	RestoreUser(userID int) error
+	PurgeDeletedUsers() (int64, error)
+
+	GetUserDataExport(userID int) (*UserDataExport, error)
+	CountUserRecords(userID int) (int, error)
+	CreateDataExport(userID, requestedBy int, format string, expiresAt time.Time) (int, error)
+	CompleteDataExport(id int, data []byte) error
+	FailDataExport(id int, reason string) error
+	GetDataExport(id int) (*DataExport, error)
+	DeleteExpiredDataExports() (int64, error)
+
+	UpdateUserStatus(userID int, status, reason string, expiresAt *time.Time) error
+	RevokeAccessTokensForUser(userID int) error
+	IsAccessTokenRevoked(userID, sessionID int, issuedAt time.Time) (bool, error)
+
+	UpdateUserAccount(userID int, username, email string) error
+	SetTemporaryPassword(userID int, newPasswordHash string) error
+	DeleteUser(userID int) error
+
+	CreatePersonalAccessToken(token PersonalAccessToken, tokenHash string) (int, error)
+	ListPersonalAccessTokens(userID int) ([]PersonalAccessToken, error)
+	GetPersonalAccessTokenByHash(tokenHash string) (*PersonalAccessToken, error)
+	TouchPersonalAccessToken(id int, ip string) error
+	RevokePersonalAccessToken(userID, id int) error
+	RevokePersonalAccessTokensForUser(userID int) error
+
+	GetLoginLockout(kind, key string) (*LoginLockout, error)
+	RecordLoginFailure(kind, key string, userID *int, ip string, window time.Duration) (*LoginLockout, error)
+	SetLoginLockoutDeadlines(id int, retryAfter, lockedUntil *time.Time, unlockTokenHash string) error
+	ClearLoginLockout(kind, key string) error
+	ConsumeUnlockToken(tokenHash string) (int, error)
+	ClearLoginLockoutsForUser(userID int) (int, error)
+	ListLoginLockouts(lockedOnly bool) ([]LoginLockout, error)
+	ListLoginLockoutsForUser(userID int) ([]LoginLockout, error)
+	DeleteLoginLockout(id int) (*LoginLockout, error)
+	DeleteStaleLoginLockouts(window time.Duration) (int64, error)
+
+	RecordLoginAttempt(attempt LoginAttempt) error
+	GetLoginFamiliarity(userID int, deviceName, network string) (*LoginFamiliarity, error)
+	ListLoginAttempts(userID, limit, offset int) ([]LoginAttempt, error)
+	DeleteOldLoginAttempts(retention time.Duration) (int64, error)
+}
+
+const (
+	LoginMethodPassword     = "password"
+	LoginMethodRegistration = "registration"
+)
+
+// Login outcomes. A login refused because of the account's status is
+// recorded as "account_<status>", e.g. "account_suspended".
+const (
+	LoginOutcomeSuccess            = "success"
+	LoginOutcomeInvalidCredentials = "invalid_credentials"
+	LoginOutcomeThrottled          = "throttled"
+	LoginOutcomeLocked             = "locked"
+	LoginOutcomeEmailNotVerified   = "email_not_verified"
+	LoginOutcomeSessionLimit       = "session_limit_reached"
+	LoginOutcomeError              = "error"
+)
+
+// LoginAttempt is one entry of a user's sign-in history. Attempts with an
+// identifier that matches no account have no UserID. Network is the IP's
+// /24 (IPv4) or /48 (IPv6) prefix.
+type LoginAttempt struct {
+	ID         int       `json:"id"`
+	UserID     *int      `json:"-"`
+	Identifier string    `json:"identifier"`
+	Method     string    `json:"method"`
+	Outcome    string    `json:"outcome"`
+	IP         string    `json:"ip"`
+	Network    string    `json:"-"`
+	UserAgent  string    `json:"userAgent"`
+	DeviceName string    `json:"deviceName"`
+	NewDevice  bool      `json:"newDevice"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// LoginFamiliarity says whether a device and network showed up in a user's
+// earlier successful logins.
+type LoginFamiliarity struct {
+	HasHistory   bool
+	KnownDevice  bool
+	KnownNetwork bool
+}
+
+const (
+	LockoutKindAccount    = "account"
+	LockoutKindIdentifier = "identifier"
+)
+
+// LoginLockout counts recent failed logins against one account, or against
+// one identifier as typed at login whether or not it matches an account.
+// RetryAfter is the backoff delay; LockedUntil is set once the failures
+// reach LOGIN_LOCKOUT_THRESHOLD.
+type LoginLockout struct {
+	ID           int        `json:"id"`
+	Kind         string     `json:"kind"`
+	Key          string     `json:"key"`
+	UserID       *int       `json:"userId"`
+	FailedCount  int        `json:"failedCount"`
+	LastFailedAt time.Time  `json:"lastFailedAt"`
+	LastFailedIP string     `json:"lastFailedIp"`
+	RetryAfter   *time.Time `json:"retryAfter"`
+	LockedUntil  *time.Time `json:"lockedUntil"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+// Locked reports whether the lockout is in force at now.
+func (l *LoginLockout) Locked(now time.Time) bool {
+	return l.LockedUntil != nil && l.LockedUntil.After(now)
+}
+
+// Session is one signed-in device. Its refresh tokens rotate, but the
+// session keeps its id until it is revoked or expires.
+type Session struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	DeviceName string     `json:"deviceName"`
+	UserAgent  string     `json:"userAgent"`
+	IP         string     `json:"ip"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt time.Time  `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"-"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	Current    bool       `json:"current"`
+}
+
+// PersonalAccessToken is a long-lived credential for scripts. It acts with
+// the intersection of Scopes and the owner's current permissions.
+type PersonalAccessToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	LastUsedIP string     `json:"lastUsedIp"`
+	RevokedAt  *time.Time `json:"-"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+type Role struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	System      bool      `json:"system"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type Permission struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type RoleStore interface {
+	ListRoles() ([]Role, error)
+	GetRoleByID(id int) (*Role, error)
+	CreateRole(role Role) (int, error)
+	UpdateRole(role Role) error
+	DeleteRole(id int) error
+	ListPermissions() ([]Permission, error)
+
+	GetUserRoles(userID int) ([]Role, error)
+	EffectiveUserRoles(userID int) ([]Role, error)
+	AssignRole(userID, roleID int) error
+	UnassignRole(userID, roleID int) error
+	UserPermissions(userID int) ([]string, error)
+
+	ListGroups() ([]Group, error)
+	GetGroupByID(id int) (*Group, error)
+	CreateGroup(group Group) (int, error)
+	UpdateGroup(group Group) error
+	DeleteGroup(id int) error
+	GetGroupMembers(groupID int) (*GroupMembers, error)
+	AddGroupUser(groupID, userID int) error
+	RemoveGroupUser(groupID, userID int) error
+	AddSubgroup(parentID, childID int) error
+	RemoveSubgroup(parentID, childID int) error
+	AssignGroupRole(groupID, roleID int) error
+	UnassignGroupRole(groupID, roleID int) error
+	GetUserGroups(userID int) ([]UserGroup, error)
+
+	RoleUserIDs(roleID int) ([]int, error)
+	GroupUserIDs(groupID int) ([]int, error)
+}
+
+// Group bundles users and other groups so roles can be granted once.
+// Members of a subgroup inherit every role of the groups containing it.
+type Group struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Roles       []string  `json:"roles"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type GroupUser struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+// GroupMembers lists only direct members; nested members are reached
+// through Groups.
+type GroupMembers struct {
+	Users  []GroupUser `json:"users"`
+	Groups []Group     `json:"groups"`
+}
+
+// UserGroup is one of the user's effective groups. Direct is false for
+// groups reached through nesting.
+type UserGroup struct {
+	Group
+	Direct bool `json:"direct"`
+}
+
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+type Organization struct {
+	ID        int       `json:"id"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Membership joins a user to an organization with an org-scoped role. The
+// organization and user fields are filled in for listings.
+type Membership struct {
+	OrganizationID   int       `json:"organizationId"`
+	OrganizationSlug string    `json:"organizationSlug"`
+	OrganizationName string    `json:"organizationName"`
+	UserID           int       `json:"userId"`
+	Username         string    `json:"username"`
+	Email            string    `json:"email"`
+	Role             string    `json:"role"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+type OrganizationInvitation struct {
+	ID             int        `json:"id"`
+	OrganizationID int        `json:"organizationId"`
+	Email          string     `json:"email"`
+	Role           string     `json:"role"`
+	InvitedBy      *int       `json:"invitedBy"`
+	ExpiresAt      time.Time  `json:"expiresAt"`
+	AcceptedAt     *time.Time `json:"acceptedAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+type OrganizationStore interface {
+	CreateOrganization(org Organization, ownerID int) (int, error)
+	GetOrganizationByID(id int) (*Organization, error)
+	ListOrganizations() ([]Organization, error)
+	DeleteOrganization(id int) error
+
+	ListMemberships(userID int) ([]Membership, error)
+	ListMembers(orgID int) ([]Membership, error)
+	GetMembership(orgID, userID int) (*Membership, error)
+	AddMember(orgID, userID int, role string) error
+	UpdateMemberRole(orgID, userID int, role string) error
+	RemoveMember(orgID, userID int) error
+	CountOwners(orgID int) (int, error)
+	SetActiveOrganization(userID int, orgID *int) error
+
+	CreateInvitation(inv OrganizationInvitation, tokenHash string) (int, error)
+	ListInvitations(orgID int) ([]OrganizationInvitation, error)
+	GetInvitationByToken(tokenHash string) (*OrganizationInvitation, error)
+	AcceptInvitation(inv *OrganizationInvitation, userID int) error
+	DeleteInvitation(orgID, id int) error
+}
+
+// ServiceAccount is a non-human principal for machine clients. It belongs
+// to an organization, or to the platform when OrganizationID is nil, and
+// authenticates with API keys instead of a password.
+type ServiceAccount struct {
+	ID             int        `json:"id"`
+	Name           string     `json:"name"`
+	Description    string     `json:"description"`
+	OrganizationID *int       `json:"organizationId"`
+	CreatedBy      *int       `json:"createdBy"`
+	Scopes         []string   `json:"scopes"`
+	IPAllowlist    []string   `json:"ipAllowlist"`
+	DisabledAt     *time.Time `json:"disabledAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+type ServiceAccountKey struct {
+	ID               int        `json:"id"`
+	ServiceAccountID int        `json:"serviceAccountId"`
+	Name             string     `json:"name"`
+	Prefix           string     `json:"prefix"`
+	ExpiresAt        *time.Time `json:"expiresAt"`
+	LastUsedAt       *time.Time `json:"lastUsedAt"`
+	LastUsedIP       string     `json:"lastUsedIp"`
+	RevokedAt        *time.Time `json:"-"`
+	CreatedAt        time.Time  `json:"createdAt"`
+}
+
+type ServiceAccountStore interface {
+	CreateServiceAccount(account ServiceAccount) (int, error)
+	GetServiceAccountByID(id int) (*ServiceAccount, error)
+	ListServiceAccounts(orgID *int) ([]ServiceAccount, error)
+	UpdateServiceAccount(account ServiceAccount) error
+	DeleteServiceAccount(id int) error
+
+	CreateServiceAccountKey(key ServiceAccountKey, keyHash string) (int, error)
+	ListServiceAccountKeys(accountID int) ([]ServiceAccountKey, error)
+	GetServiceAccountKeyByHash(keyHash string) (*ServiceAccountKey, error)
+	RotateServiceAccountKey(accountID, keyID int, replacement ServiceAccountKey, keyHash string, overlapUntil time.Time) (*ServiceAccountKey, error)
+	TouchServiceAccountKey(id int, ip string) error
+	RevokeServiceAccountKey(accountID, keyID int) error
+}
+
+// BFFSession holds a browser's tokens on the server. The browser only has
+// an opaque cookie, whose hash is the lookup key.
+type BFFSession struct {
+	ID              int
+	UserID          int
+	AccessToken     string
+	RefreshToken    string
+	AccessExpiresAt time.Time
+	ExpiresAt       time.Time
+	LastUsedAt      time.Time
+	CreatedAt       time.Time
+}
+
+type BFFSessionStore interface {
+	CreateBFFSession(session BFFSession, sessionHash string) (int, error)
+	GetBFFSessionByHash(sessionHash string) (*BFFSession, error)
+	UpdateBFFSessionLocked(id int, update func(session *BFFSession) error) (*BFFSession, error)
+	TouchBFFSession(id int, expiresAt time.Time) error
+	DeleteBFFSession(id int) error
+	DeleteExpiredBFFSessions() (int64, error)
+}
+
+type AuditStore interface {
+	RecordEvent(event AuditEvent) error
+	ListEvents(filter AuditEventFilter) ([]AuditEvent, error)
+}
+
+type AuditEvent struct {
+	ID         int            `json:"id"`
+	ActorID    *int           `json:"actorId"`
+	Action     string         `json:"action"`
+	TargetType string         `json:"targetType"`
+	TargetID   string         `json:"targetId"`
+	Metadata   map[string]any `json:"metadata"`
+	IP         string         `json:"ip"`
+	UserAgent  string         `json:"userAgent"`
+	OrgID      *int           `json:"organizationId"`
+	CreatedAt  time.Time      `json:"createdAt"`
+}
+
+type AuditEventFilter struct {
+	ActorID    *int
+	TargetType string
+	TargetID   string
+	Action     string
+	OrgID      *int
+	Limit      int
+	Offset     int
+}
+
+// UserAttributeDefinition describes one key allowed in User.Metadata.
+// AdminOnly attributes can only be changed through the admin API, and only
+// they are exposed to access policies.
+type UserAttributeDefinition struct {
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	Required    bool      `json:"required"`
+	MaxLength   *int      `json:"maxLength"`
+	AdminOnly   bool      `json:"adminOnly"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+const (
+	AttributeTypeString  = "string"
+	AttributeTypeNumber  = "number"
+	AttributeTypeBoolean = "boolean"
+)
+
+// UserDataExport is the subject-access bundle returned by /me/export. It
+// never contains password hashes or token values.
+type UserDataExport struct {
+	GeneratedAt          time.Time                      `json:"generatedAt"`
+	Profile              *User                          `json:"profile"`
+	Sessions             []SessionExport                `json:"sessions"`
+	DeviceSessions       []DeviceSessionExport          `json:"deviceSessions"`
+	PersonalAccessTokens []PersonalAccessTokenExport    `json:"personalAccessTokens"`
+	Organizations        []OrganizationMembershipExport `json:"organizations"`
+	Groups               []GroupMembershipExport        `json:"groups"`
+	LoginHistory         []LoginAttempt                 `json:"loginHistory"`
+	Lockouts             []LoginLockout                 `json:"lockouts"`
+	EmailChanges         []EmailChangeExport            `json:"emailChanges"`
+	PasswordResets       []PasswordResetExport          `json:"passwordResets"`
+	AuditEvents          []AuditEvent                   `json:"auditEvents"`
+}
+
+type SessionExport struct {
+	ID        int       `json:"id"`
+	Revoked   bool      `json:"revoked"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type DeviceSessionExport struct {
+	ID         int        `json:"id"`
+	DeviceName string     `json:"deviceName"`
+	UserAgent  string     `json:"userAgent"`
+	IP         string     `json:"ip"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt time.Time  `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+type PersonalAccessTokenExport struct {
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	LastUsedIP string     `json:"lastUsedIp"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+type OrganizationMembershipExport struct {
+	OrganizationID   int       `json:"organizationId"`
+	OrganizationSlug string    `json:"organizationSlug"`
+	OrganizationName string    `json:"organizationName"`
+	Role             string    `json:"role"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+type GroupMembershipExport struct {
+	GroupID   int       `json:"groupId"`
+	GroupName string    `json:"groupName"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type EmailChangeExport struct {
+	OldEmail    string     `json:"oldEmail"`
+	NewEmail    string     `json:"newEmail"`
+	ConfirmedAt *time.Time `json:"confirmedAt"`
+	CancelledAt *time.Time `json:"cancelledAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+type PasswordResetExport struct {
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+type DataExport struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"userId"`
+	RequestedBy *int       `json:"requestedBy"`
+	Format      string     `json:"format"`
+	Status      string     `json:"status"`
+	Data        []byte     `json:"-"`
+	Error       string     `json:"error,omitempty"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	CompletedAt *time.Time `json:"completedAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+const (
+	DataExportPending = "pending"
+	DataExportReady   = "ready"
+	DataExportFailed  = "failed"
+)
+
+type EmailChange struct {
+	ID               int
+	UserID           int
+	OldEmail         string
+	NewEmail         string
+	ConfirmTokenHash string
+	CancelTokenHash  string
+	ExpiresAt        time.Time
+	ConfirmedAt      *time.Time
+	CancelledAt      *time.Time
+	CreatedAt        time.Time
 }
 
 type RegisterUserPayload struct {
@@ -32,9 +634,16 @@ type RegisterUserPayload struct {
 	Password string `json:"password" validate:"required,min=8,max=130"`
 }
 
+// LoginPayload names the new session after DeviceName, or after the user
+// agent when it is empty.
 type LoginPayload struct {
 	Identifier string `json:"identifier" validate:"required"`
 	Password   string `json:"password" validate:"required"`
+	DeviceName string `json:"deviceName" validate:"max=100"`
+}
+
+type UnlockAccountPayload struct {
+	Token string `json:"token" validate:"required"`
 }
 
 type RefreshPayload struct {
@@ -45,3 +654,169 @@ type ChangePasswordPayload struct {
 	CurrentPassword string `json:"currentPassword" validate:"required"`
 	NewPassword     string `json:"newPassword" validate:"required,min=8,max=130"`
 }
+
+type VerifyEmailPayload struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ResendVerificationPayload struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ForgotPasswordPayload struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordPayload struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"newPassword" validate:"required,min=8,max=130"`
+}
+
+type ChangeEmailPayload struct {
+	NewEmail        string `json:"newEmail" validate:"required,email"`
+	CurrentPassword string `json:"currentPassword" validate:"required"`
+}
+
+type EmailChangeTokenPayload struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// UpdateProfilePayload uses pointers so omitted fields are left untouched.
+// Metadata keys are merged; a null value removes the key.
+type UpdateProfilePayload struct {
+	Username    *string        `json:"username" validate:"omitempty,min=3,max=30"`
+	DisplayName *string        `json:"displayName" validate:"omitempty,max=100"`
+	Locale      *string        `json:"locale"`
+	Timezone    *string        `json:"timezone" validate:"omitempty,timezone"`
+	AvatarURL   *string        `json:"avatarUrl" validate:"omitempty,max=2048"`
+	Metadata    map[string]any `json:"metadata"`
+}
+
+type UserAttributeDefinitionPayload struct {
+	Type        string `json:"type" validate:"required,oneof=string number boolean"`
+	Required    bool   `json:"required"`
+	MaxLength   *int   `json:"maxLength" validate:"omitempty,min=1"`
+	AdminOnly   bool   `json:"adminOnly"`
+	Description string `json:"description" validate:"max=500"`
+}
+
+type DeleteAccountPayload struct {
+	CurrentPassword string `json:"currentPassword" validate:"required"`
+}
+
+type UpdateUserStatusPayload struct {
+	Status    string     `json:"status" validate:"required,oneof=active suspended disabled locked"`
+	Reason    string     `json:"reason" validate:"max=500"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// AdminCreateUserPayload and AdminUpdateUserPayload have no role field:
+// roles are granted through /admin/users/{id}/roles, which requires
+// roles:manage.
+type AdminCreateUserPayload struct {
+	Username           string `json:"username" validate:"required,min=3,max=30"`
+	Email              string `json:"email" validate:"required,email"`
+	Password           string `json:"password" validate:"required,min=8,max=130"`
+	EmailVerified      bool   `json:"emailVerified"`
+	MustChangePassword bool   `json:"mustChangePassword"`
+}
+
+type AdminUpdateUserPayload struct {
+	Username *string        `json:"username" validate:"omitempty,min=3,max=30"`
+	Email    *string        `json:"email" validate:"omitempty,email"`
+	Metadata map[string]any `json:"metadata"`
+}
+
+// AdminResetPasswordPayload generates a temporary password when
+// NewPassword is empty.
+type AdminResetPasswordPayload struct {
+	NewPassword string `json:"newPassword" validate:"omitempty,min=8,max=130"`
+}
+
+// ImpersonateUserPayload requires a reason so the audit trail explains
+// why an admin acted as the user.
+type ImpersonateUserPayload struct {
+	Reason string `json:"reason" validate:"required,min=3,max=500"`
+}
+
+type CreateRolePayload struct {
+	Name        string   `json:"name" validate:"required,min=2,max=50"`
+	Description string   `json:"description" validate:"max=500"`
+	Permissions []string `json:"permissions"`
+}
+
+type UpdateRolePayload struct {
+	Description *string  `json:"description" validate:"omitempty,max=500"`
+	Permissions []string `json:"permissions"`
+}
+
+type CreatePersonalAccessTokenPayload struct {
+	Name      string     `json:"name" validate:"required,min=1,max=100"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type CreateGroupPayload struct {
+	Name        string `json:"name" validate:"required,min=2,max=50"`
+	Description string `json:"description" validate:"max=500"`
+}
+
+type UpdateGroupPayload struct {
+	Description *string `json:"description" validate:"omitempty,max=500"`
+}
+
+// CreateOrganizationPayload makes OwnerID the first owner, or the caller
+// when it is omitted.
+type CreateOrganizationPayload struct {
+	Name    string `json:"name" validate:"required,min=2,max=100"`
+	Slug    string `json:"slug" validate:"required,min=2,max=50"`
+	OwnerID int    `json:"ownerId" validate:"omitempty,min=1"`
+}
+
+type UpdateMemberRolePayload struct {
+	Role string `json:"role" validate:"required,oneof=owner admin member"`
+}
+
+type InviteMemberPayload struct {
+	Email string `json:"email" validate:"required,email"`
+	Role  string `json:"role" validate:"omitempty,oneof=owner admin member"`
+}
+
+type AcceptInvitationPayload struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// SetActiveOrganizationPayload clears the active organization when
+// OrganizationID is null.
+type SetActiveOrganizationPayload struct {
+	OrganizationID *int `json:"organizationId"`
+}
+
+type CreateServiceAccountPayload struct {
+	Name           string   `json:"name" validate:"required,min=2,max=100"`
+	Description    string   `json:"description" validate:"max=500"`
+	OrganizationID *int     `json:"organizationId" validate:"omitempty,min=1"`
+	Scopes         []string `json:"scopes"`
+	IPAllowlist    []string `json:"ipAllowlist"`
+}
+
+// UpdateServiceAccountPayload leaves nil fields unchanged.
+type UpdateServiceAccountPayload struct {
+	Description *string   `json:"description" validate:"omitempty,max=500"`
+	Scopes      *[]string `json:"scopes"`
+	IPAllowlist *[]string `json:"ipAllowlist"`
+	Disabled    *bool     `json:"disabled"`
+}
+
+type CreateServiceAccountKeyPayload struct {
+	Name      string     `json:"name" validate:"required,max=100"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// RotateServiceAccountKeyPayload keeps the old key working for
+// OverlapSeconds, or API_KEY_ROTATION_OVERLAP when omitted, so clients can
+// switch over without downtime.
+type RotateServiceAccountKeyPayload struct {
+	OverlapSeconds *int       `json:"overlapSeconds" validate:"omitempty,min=0,max=2592000"`
+	ExpiresAt      *time.Time `json:"expiresAt"`
+}
@@ -1,20 +1,110 @@
 package utils
 
 import (
+	"auth-api/configs"
 	"context"
 	"errors"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type contextKey string
 
-const contextKeyUserID contextKey = "userID"
+const (
+	contextKeyUserID contextKey = "userID"
+	contextKeyClaims contextKey = "claims"
+)
+
+// TokenRevocationChecker lets AuthMiddleware refuse access tokens that were
+// revoked before they expired, e.g. after an admin suspends the user or the
+// user signs their session out. sessionID is 0 for tokens without one.
+type TokenRevocationChecker interface {
+	IsAccessTokenRevoked(userID, sessionID int, issuedAt time.Time) (bool, error)
+}
+
+var revocationChecker TokenRevocationChecker
+
+func SetTokenRevocationChecker(c TokenRevocationChecker) {
+	revocationChecker = c
+}
+
+// Personal access tokens are opaque "pat_..." strings rather than JWTs.
+const (
+	PersonalAccessTokenPrefix = "pat_"
+	PersonalAccessTokenType   = "pat"
+)
+
+var ErrInvalidPersonalAccessToken = errors.New("invalid or expired token")
+
+// PersonalAccessTokenResolver turns a raw personal access token into the
+// claims it acts with, or ErrInvalidPersonalAccessToken.
+type PersonalAccessTokenResolver interface {
+	ResolvePersonalAccessToken(rawToken, ip string) (*CustomClaims, error)
+}
+
+var patResolver PersonalAccessTokenResolver
+
+// ImpersonationAuditor is told about every request made with a token that
+// carries an act claim.
+type ImpersonationAuditor interface {
+	AuditImpersonatedRequest(r *http.Request)
+}
+
+var impersonationAuditor ImpersonationAuditor
 
+func SetImpersonationAuditor(a ImpersonationAuditor) {
+	impersonationAuditor = a
+}
+
+func SetPersonalAccessTokenResolver(r PersonalAccessTokenResolver) {
+	patResolver = r
+}
+
+// API keys authenticate service accounts through the X-API-Key header.
+const (
+	APIKeyHeader            = "X-API-Key"
+	APIKeyPrefix            = "sak_"
+	ServiceAccountTokenType = "service_account"
+)
+
+var (
+	ErrInvalidAPIKey      = errors.New("invalid or expired api key")
+	ErrAPIKeyIPNotAllowed = errors.New("api key not allowed from this address")
+)
+
+// APIKeyResolver turns a raw API key into the claims of its service
+// account, or ErrInvalidAPIKey / ErrAPIKeyIPNotAllowed.
+type APIKeyResolver interface {
+	ResolveAPIKey(rawKey, ip string) (*CustomClaims, error)
+}
+
+var apiKeyResolver APIKeyResolver
+
+func SetAPIKeyResolver(r APIKeyResolver) {
+	apiKeyResolver = r
+}
+
+// AuthMiddleware refuses tokens of users who must change their password;
+// use PasswordChangeAuthMiddleware for the routes that let them do so.
 func AuthMiddleware(next http.Handler) http.Handler {
+	return authenticate(next, false)
+}
+
+func PasswordChangeAuthMiddleware(next http.Handler) http.Handler {
+	return authenticate(next, true)
+}
+
+func authenticate(next http.Handler, allowPasswordChange bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" && r.Header.Get(APIKeyHeader) != "" {
+			authenticateAPIKey(w, r, next, r.Header.Get(APIKeyHeader))
+			return
+		}
+
 		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
 			WriteError(w, http.StatusUnauthorized, errors.New("missing or invalid authorization header"))
 			return
@@ -22,25 +112,185 @@ func AuthMiddleware(next http.Handler) http.Handler {
 
 		rawToken := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
 
-		claims, err := ParseToken(rawToken)
+		if strings.HasPrefix(rawToken, PersonalAccessTokenPrefix) {
+			authenticatePersonalAccessToken(w, r, next, rawToken, allowPasswordChange)
+			return
+		}
+
+		claims, userID, err := ValidateAccessToken(rawToken)
 		if err != nil {
-			WriteError(w, http.StatusUnauthorized, errors.New("invalid or expired token"))
+			if IsAccessTokenError(err) {
+				WriteError(w, http.StatusUnauthorized, err)
+				return
+			}
+			WriteError(w, http.StatusInternalServerError, err)
 			return
 		}
 
-		if claims.TokenType != "access" {
-			WriteError(w, http.StatusUnauthorized, errors.New("invalid token type"))
+		if len(claims.Audience) > 0 && !slices.Contains(claims.Audience, configs.Envs.TokenAudience) {
+			WriteError(w, http.StatusUnauthorized, errors.New("token not intended for this service"))
 			return
 		}
 
-		userID, err := strconv.Atoi(claims.Subject)
-		if err != nil || userID <= 0 {
-			WriteError(w, http.StatusUnauthorized, errors.New("invalid token subject"))
+		serveAuthenticated(w, r, next, userID, claims, allowPasswordChange)
+	})
+}
+
+var (
+	ErrInvalidAccessToken = errors.New("invalid or expired token")
+	ErrInvalidTokenType   = errors.New("invalid token type")
+	ErrInvalidSubject     = errors.New("invalid token subject")
+	ErrTokenRevoked       = errors.New("token has been revoked")
+)
+
+// IsAccessTokenError tells rejected tokens apart from failures to check
+// them.
+func IsAccessTokenError(err error) bool {
+	return errors.Is(err, ErrInvalidAccessToken) ||
+		errors.Is(err, ErrInvalidTokenType) ||
+		errors.Is(err, ErrInvalidSubject) ||
+		errors.Is(err, ErrTokenRevoked)
+}
+
+// ValidateAccessToken parses a signed access token and refuses it once
+// revoked. It does not look at the audience; callers decide which
+// audiences they accept.
+func ValidateAccessToken(rawToken string) (*CustomClaims, int, error) {
+	claims, err := ParseToken(rawToken)
+	if err != nil {
+		return nil, 0, ErrInvalidAccessToken
+	}
+
+	if claims.TokenType != "access" {
+		return nil, 0, ErrInvalidTokenType
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || userID <= 0 {
+		return nil, 0, ErrInvalidSubject
+	}
+
+	if revocationChecker != nil && claims.IssuedAt != nil {
+		sessionID, _ := strconv.Atoi(claims.SessionID)
+		revoked, err := revocationChecker.IsAccessTokenRevoked(userID, sessionID, claims.IssuedAt.Time)
+		if err != nil {
+			return nil, 0, err
+		}
+		if revoked {
+			return nil, 0, ErrTokenRevoked
+		}
+	}
+
+	return claims, userID, nil
+}
+
+// authenticatePersonalAccessToken skips the JWT revocation check; the
+// resolver itself refuses tokens of deleted or inactive accounts.
+func authenticatePersonalAccessToken(w http.ResponseWriter, r *http.Request, next http.Handler, rawToken string, allowPasswordChange bool) {
+	if patResolver == nil {
+		WriteError(w, http.StatusUnauthorized, errors.New("invalid or expired token"))
+		return
+	}
+
+	claims, err := patResolver.ResolvePersonalAccessToken(rawToken, ClientIP(r))
+	if err != nil {
+		if errors.Is(err, ErrInvalidPersonalAccessToken) {
+			WriteError(w, http.StatusUnauthorized, err)
 			return
 		}
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || userID <= 0 {
+		WriteError(w, http.StatusUnauthorized, errors.New("invalid token subject"))
+		return
+	}
+
+	serveAuthenticated(w, r, next, userID, claims, allowPasswordChange)
+}
+
+// authenticateAPIKey stores only the claims in the context: a service
+// account is not a user, so handlers that need GetUserIDFromContext refuse
+// it on their own.
+func authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, rawKey string) {
+	if apiKeyResolver == nil || !strings.HasPrefix(rawKey, APIKeyPrefix) {
+		WriteError(w, http.StatusUnauthorized, ErrInvalidAPIKey)
+		return
+	}
+
+	claims, err := apiKeyResolver.ResolveAPIKey(strings.TrimSpace(rawKey), ClientIP(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidAPIKey):
+			WriteError(w, http.StatusUnauthorized, err)
+		case errors.Is(err, ErrAPIKeyIPNotAllowed):
+			WriteError(w, http.StatusForbidden, err)
+		default:
+			WriteError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), contextKeyClaims, claims)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func serveAuthenticated(w http.ResponseWriter, r *http.Request, next http.Handler, userID int, claims *CustomClaims, allowPasswordChange bool) {
+	if claims.PasswordChangeRequired && !allowPasswordChange {
+		WriteErrorCode(w, http.StatusForbidden, "password_change_required", errors.New("password change required"))
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), contextKeyUserID, userID)
+	ctx = context.WithValue(ctx, contextKeyClaims, claims)
+	r = r.WithContext(ctx)
 
-		ctx := context.WithValue(r.Context(), contextKeyUserID, userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
+	if claims.Impersonation && impersonationAuditor != nil {
+		impersonationAuditor.AuditImpersonatedRequest(r)
+	}
+
+	next.ServeHTTP(w, r)
+}
+
+// RejectDelegatedAccess must be wrapped by AuthMiddleware. It keeps scripts
+// and impersonating admins from changing credentials or minting further
+// tokens.
+func RejectDelegatedAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if claims, ok := GetClaimsFromContext(r.Context()); ok {
+			if claims.TokenType == PersonalAccessTokenType {
+				WriteError(w, http.StatusForbidden, errors.New("not allowed with a personal access token"))
+				return
+			}
+			if claims.Impersonation {
+				WriteError(w, http.StatusForbidden, errors.New("not allowed while impersonating"))
+				return
+			}
+			if claims.Act != nil {
+				WriteError(w, http.StatusForbidden, errors.New("not allowed with a delegated token"))
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireVerifiedEmail must be wrapped by AuthMiddleware. It only rejects
+// requests when EMAIL_VERIFICATION_MODE is "restrict".
+func RequireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if configs.Envs.EmailVerificationMode == configs.EmailVerificationRestrict {
+			claims, ok := GetClaimsFromContext(r.Context())
+			if !ok || !claims.EmailVerified {
+				WriteError(w, http.StatusForbidden, errors.New("email not verified"))
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
 	})
 }
 
@@ -57,3 +307,78 @@ func GetUserIDFromContext(ctx context.Context) (int, bool) {
 
 	return userID, true
 }
+
+// GetServiceAccountIDFromContext returns the service account behind an
+// API key request.
+func GetServiceAccountIDFromContext(ctx context.Context) (int, bool) {
+	claims, ok := GetClaimsFromContext(ctx)
+	if !ok || claims.TokenType != ServiceAccountTokenType {
+		return 0, false
+	}
+
+	accountID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return 0, false
+	}
+	return accountID, true
+}
+
+// GetActorIDFromContext returns the admin behind an impersonation token.
+// Services that later joined the chain through token exchange are nested
+// above the admin, so the admin is the innermost actor. Delegated tokens
+// without impersonation have no admin behind them.
+func GetActorIDFromContext(ctx context.Context) (int, bool) {
+	claims, ok := GetClaimsFromContext(ctx)
+	if !ok || !claims.Impersonation || claims.Act == nil {
+		return 0, false
+	}
+
+	act := claims.Act
+	for act.Act != nil {
+		act = act.Act
+	}
+
+	actorID, err := strconv.Atoi(act.Subject)
+	if err != nil {
+		return 0, false
+	}
+	return actorID, true
+}
+
+// GetSessionIDFromContext returns the session the caller's access token
+// was issued for.
+func GetSessionIDFromContext(ctx context.Context) (int, bool) {
+	claims, ok := GetClaimsFromContext(ctx)
+	if !ok || claims.SessionID == "" {
+		return 0, false
+	}
+
+	sessionID, err := strconv.Atoi(claims.SessionID)
+	if err != nil {
+		return 0, false
+	}
+	return sessionID, true
+}
+
+// GetTenantFromContext returns the caller's active organization id taken
+// from the tenant claim.
+func GetTenantFromContext(ctx context.Context) (int, bool) {
+	claims, ok := GetClaimsFromContext(ctx)
+	if !ok || claims.Tenant == "" {
+		return 0, false
+	}
+
+	orgID, err := strconv.Atoi(claims.Tenant)
+	if err != nil {
+		return 0, false
+	}
+	return orgID, true
+}
+
+// GetClaimsFromContext returns the verified access token claims stored by
+// AuthMiddleware, so handlers can authorize on roles, scopes and tenant
+// without a database round-trip.
+func GetClaimsFromContext(ctx context.Context) (*CustomClaims, bool) {
+	claims, ok := ctx.Value(contextKeyClaims).(*CustomClaims)
+	return claims, ok && claims != nil
+}
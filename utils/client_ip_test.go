@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	if err := SetTrustedProxies([]string{"10.0.0.0/8", " 192.0.2.1 "}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetTrustedProxies(nil) })
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		realIP       string
+		want         string
+	}{
+		{"direct client", "203.0.113.7:4000", nil, "", "203.0.113.7"},
+		{"untrusted peer sends XFF", "203.0.113.7:4000", []string{"198.51.100.1"}, "", "203.0.113.7"},
+		{"untrusted peer sends X-Real-IP", "203.0.113.7:4000", nil, "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:4000", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"spoofed entry left of client", "10.1.2.3:4000", []string{"1.1.1.1, 198.51.100.1"}, "", "198.51.100.1"},
+		{"chain of trusted proxies", "10.1.2.3:4000", []string{"1.1.1.1, 198.51.100.1, 192.0.2.1, 10.9.9.9"}, "", "198.51.100.1"},
+		{"repeated headers", "10.1.2.3:4000", []string{"1.1.1.1", "198.51.100.1"}, "", "198.51.100.1"},
+		{"only trusted hops", "10.1.2.3:4000", []string{"10.4.4.4"}, "", "10.4.4.4"},
+		{"malformed hop", "10.1.2.3:4000", []string{"198.51.100.1, not-an-ip"}, "", "10.1.2.3"},
+		{"trusted X-Real-IP", "10.1.2.3:4000", nil, "198.51.100.1", "198.51.100.1"},
+		{"mapped IPv4 proxy", "[::ffff:10.1.2.3]:4000", []string{"198.51.100.1"}, "", "198.51.100.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			if got := ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetTrustedProxiesRejectsInvalidEntries(t *testing.T) {
+	t.Cleanup(func() { SetTrustedProxies(nil) })
+
+	for _, entry := range []string{"10.0.0.0/33", "proxy.internal"} {
+		if err := SetTrustedProxies([]string{entry}); err == nil {
+			t.Errorf("SetTrustedProxies(%q) succeeded, want error", entry)
+		}
+	}
+}
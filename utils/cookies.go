@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"auth-api/configs"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// CSRFHeader must echo the CSRF cookie on requests authenticated by the
+// refresh cookie (double-submit).
+const CSRFHeader = "X-CSRF-Token"
+
+// SetRefreshCookie stores the refresh token where scripts cannot read it.
+// The cookie is only sent to REFRESH_COOKIE_PATH.
+func SetRefreshCookie(w http.ResponseWriter, token string, expiresAt time.Time) {
+	http.SetCookie(w, newCookie(configs.Envs.RefreshCookieName, token, configs.Envs.RefreshCookiePath, expiresAt, true))
+}
+
+// SetCSRFCookie issues a fresh CSRF token. The cookie is readable by the
+// page so it can be copied into the CSRF header after a reload.
+func SetCSRFCookie(w http.ResponseWriter, expiresAt time.Time) (string, error) {
+	token, err := GenerateOpaqueToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, newCookie(configs.Envs.CSRFCookieName, token, "/", expiresAt, false))
+	return token, nil
+}
+
+func ClearAuthCookies(w http.ResponseWriter) {
+	expired := time.Unix(0, 0)
+	http.SetCookie(w, newCookie(configs.Envs.RefreshCookieName, "", configs.Envs.RefreshCookiePath, expired, true))
+	http.SetCookie(w, newCookie(configs.Envs.CSRFCookieName, "", "/", expired, false))
+}
+
+// RefreshTokenFromCookie returns the refresh cookie in cookie mode.
+func RefreshTokenFromCookie(r *http.Request) (string, bool) {
+	if !configs.Envs.CookieMode {
+		return "", false
+	}
+
+	cookie, err := r.Cookie(configs.Envs.RefreshCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// SetBFFSessionCookie gives the browser its BFF session id. It is a session
+// cookie; the server side expires after BFF_SESSION_TTL without use.
+func SetBFFSessionCookie(w http.ResponseWriter, sessionToken string) {
+	http.SetCookie(w, newCookie(configs.Envs.BFFCookieName, sessionToken, "/bff", time.Time{}, true))
+}
+
+func ClearBFFCookies(w http.ResponseWriter) {
+	expired := time.Unix(0, 0)
+	http.SetCookie(w, newCookie(configs.Envs.BFFCookieName, "", "/bff", expired, true))
+	http.SetCookie(w, newCookie(configs.Envs.CSRFCookieName, "", "/", expired, false))
+}
+
+// BFFSessionFromCookie returns the BFF session cookie when BFF mode is on.
+func BFFSessionFromCookie(r *http.Request) (string, bool) {
+	if !configs.Envs.BFFEnabled {
+		return "", false
+	}
+
+	cookie, err := r.Cookie(configs.Envs.BFFCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+func newCookie(name, value, path string, expiresAt time.Time, httpOnly bool) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   configs.Envs.CookieDomain,
+		Expires:  expiresAt,
+		Secure:   configs.Envs.CookieSecure,
+		HttpOnly: httpOnly,
+		SameSite: cookieSameSite(),
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	return cookie
+}
+
+func cookieSameSite() http.SameSite {
+	switch strings.ToLower(configs.Envs.CookieSameSite) {
+	case "none":
+		return http.SameSiteNoneMode
+	case "lax":
+		return http.SameSiteLaxMode
+	default:
+		return http.SameSiteStrictMode
+	}
+}
+
+// CSRFProtect guards state-changing requests that carry the refresh cookie
+// or the BFF session cookie, since browsers attach them on their own. The Origin must be one the CORS
+// middleware would allow (or the API's own host when none are configured),
+// and the CSRF header must match the CSRF cookie. Requests authenticated
+// only by an Authorization header are left alone.
+func CSRFProtect(next http.Handler) http.Handler {
+	allowed := parseAllowedOrigins(configs.Envs.CORSAllowedOrigins)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hasCredentialCookie(r) || isSafeMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !isTrustedOrigin(r, allowed) {
+			WriteErrorCode(w, http.StatusForbidden, "csrf_failed", errors.New("origin not allowed"))
+			return
+		}
+
+		cookie, err := r.Cookie(configs.Envs.CSRFCookieName)
+		header := r.Header.Get(CSRFHeader)
+		if err != nil || cookie.Value == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+			WriteErrorCode(w, http.StatusForbidden, "csrf_failed", errors.New("missing or invalid csrf token"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func hasCredentialCookie(r *http.Request) bool {
+	if _, ok := RefreshTokenFromCookie(r); ok {
+		return true
+	}
+	_, ok := BFFSessionFromCookie(r)
+	return ok
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// isTrustedOrigin falls back to Referer when Origin is missing. Browsers
+// always send Origin on cross-site POSTs, so a request with neither header
+// is accepted.
+func isTrustedOrigin(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		ref, err := url.Parse(r.Referer())
+		if err != nil || ref.Host == "" {
+			return true
+		}
+		origin = ref.Scheme + "://" + ref.Host
+	}
+
+	if len(allowed) > 0 {
+		return isOriginAllowed(origin, allowed)
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
@@ -0,0 +1,55 @@
+package utils
+
+import "strings"
+
+// DeviceName turns a User-Agent header into a short label such as
+// "Firefox on Windows" for session listings. It only knows the common
+// browsers and platforms; anything else falls back to the raw product.
+func DeviceName(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	browser := ""
+	switch {
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"), strings.Contains(userAgent, "CriOS/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	}
+
+	platform := ""
+	switch {
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"):
+		platform = "iOS"
+	case strings.Contains(userAgent, "Android"):
+		platform = "Android"
+	case strings.Contains(userAgent, "Windows"):
+		platform = "Windows"
+	case strings.Contains(userAgent, "Mac OS X"), strings.Contains(userAgent, "Macintosh"):
+		platform = "macOS"
+	case strings.Contains(userAgent, "CrOS"):
+		platform = "ChromeOS"
+	case strings.Contains(userAgent, "Linux"):
+		platform = "Linux"
+	}
+
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	}
+
+	product, _, _ := strings.Cut(userAgent, " ")
+	product, _, _ = strings.Cut(product, "/")
+	return product
+}
@@ -4,29 +4,174 @@ import (
 	"auth-api/configs"
 	"errors"
 	"fmt"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// Token timestamps carry microseconds, the precision Postgres stores, so
+// an access token can be ordered against a forced logout in the same
+// second; see user.Store.RevokeAccessTokensForUser.
+func init() {
+	jwt.TimePrecision = time.Microsecond
+}
+
 type CustomClaims struct {
-	TokenType string `json:"typ"`
+	TokenType              string      `json:"typ"`
+	Email                  string      `json:"email,omitempty"`
+	EmailVerified          bool        `json:"email_verified,omitempty"`
+	PasswordChangeRequired bool        `json:"pcr,omitempty"`
+	Roles                  []string    `json:"roles,omitempty"`
+	Scope                  string      `json:"scope,omitempty"`
+	Tenant                 string      `json:"tenant,omitempty"`
+	SessionID              string      `json:"sid,omitempty"`
+	Act                    *ActorClaim `json:"act,omitempty"`
+	Impersonation          bool        `json:"imp,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateAccessToken(userID int) (string, error) {
-	return generateToken(userID, time.Duration(12)*time.Hour, "access")
+// ActorClaim names the party acting on behalf of the token subject (RFC
+// 8693 section 4.1). A nested Act records an earlier actor in the chain.
+type ActorClaim struct {
+	Subject string      `json:"sub"`
+	Act     *ActorClaim `json:"act,omitempty"`
 }
 
-func GenerateRefreshToken(userID int) (string, error) {
-	return generateToken(userID, time.Duration(43200)*time.Minute, "refresh")
+// Scopes splits the space-delimited scope claim (RFC 8693 section 4.2).
+func (c *CustomClaims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+func (c *CustomClaims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes(), scope)
+}
+
+// AccessTokenOptions carries the per-user state embedded in access tokens.
+// Roles and permissions are a snapshot taken at issuance, so changes reach
+// the token on the next refresh.
+type AccessTokenOptions struct {
+	EmailVerified          bool
+	PasswordChangeRequired bool
+	Roles                  []string
+	Permissions            []string
+	Tenant                 string
+	SessionID              int
+	// SessionExpiresAt caps the token's lifetime at its session's, when set.
+	SessionExpiresAt time.Time
+}
+
+func GenerateAccessToken(userID int, opts AccessTokenOptions) (string, error) {
+	claims := newClaims(userID, time.Duration(12)*time.Hour, "access")
+	if !opts.SessionExpiresAt.IsZero() && opts.SessionExpiresAt.Before(claims.ExpiresAt.Time) {
+		claims.ExpiresAt = jwt.NewNumericDate(opts.SessionExpiresAt)
+	}
+	claims.applyOptions(opts)
+	return signClaims(claims)
 }
 
-func generateToken(userID int, ttl time.Duration, tokenType string) (string, error) {
+// GenerateImpersonationToken issues a short-lived access token for userID
+// that names actorID in its act claim. No refresh token goes with it.
+func GenerateImpersonationToken(userID, actorID int, ttl time.Duration, opts AccessTokenOptions) (string, error) {
+	claims := newClaims(userID, ttl, "access")
+	opts.SessionID = 0
+	claims.applyOptions(opts)
+	claims.Act = &ActorClaim{Subject: strconv.Itoa(actorID)}
+	claims.Impersonation = true
+	return signClaims(claims)
+}
+
+// GenerateExchangedToken issues the token returned by an RFC 8693 token
+// exchange: it keeps the subject's identity, tenant and session but only
+// the given scopes, is restricted to audience, and records act as the
+// actor chain. A token exchanged from an impersonation token remains one.
+func GenerateExchangedToken(subject *CustomClaims, act *ActorClaim, audience, scopes []string, expiresAt time.Time) (string, error) {
 	now := time.Now().UTC()
 
 	claims := CustomClaims{
+		TokenType:     "access",
+		EmailVerified: subject.EmailVerified,
+		Scope:         strings.Join(scopes, " "),
+		Tenant:        subject.Tenant,
+		SessionID:     subject.SessionID,
+		Act:           act,
+		Impersonation: subject.Impersonation,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject.Subject,
+			Audience:  audience,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	return signClaims(claims)
+}
+
+// NewPersonalAccessTokenClaims builds the claims a personal access token
+// acts with. They are never signed; the token itself is looked up on every
+// request. The owner's roles are left out: policies match on roles, and a
+// token must not reach past the scopes it was created with.
+func NewPersonalAccessTokenClaims(userID, tokenID int, createdAt time.Time, opts AccessTokenOptions) *CustomClaims {
+	claims := &CustomClaims{
+		TokenType: PersonalAccessTokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       strconv.Itoa(tokenID),
+			Subject:  strconv.Itoa(userID),
+			IssuedAt: jwt.NewNumericDate(createdAt),
+		},
+	}
+	claims.applyOptions(opts)
+	claims.Roles = nil
+	return claims
+}
+
+// NewServiceAccountClaims builds the claims an API key acts with. Like
+// personal access tokens they are never signed, and the subject is the
+// service account id rather than a user id.
+func NewServiceAccountClaims(accountID, keyID int, createdAt time.Time, scopes []string, tenant string) *CustomClaims {
+	return &CustomClaims{
+		TokenType: ServiceAccountTokenType,
+		Scope:     strings.Join(scopes, " "),
+		Tenant:    tenant,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       strconv.Itoa(keyID),
+			Subject:  strconv.Itoa(accountID),
+			IssuedAt: jwt.NewNumericDate(createdAt),
+		},
+	}
+}
+
+func (c *CustomClaims) applyOptions(opts AccessTokenOptions) {
+	c.EmailVerified = opts.EmailVerified
+	c.PasswordChangeRequired = opts.PasswordChangeRequired
+	c.Roles = opts.Roles
+	c.Scope = strings.Join(opts.Permissions, " ")
+	c.Tenant = opts.Tenant
+	if opts.SessionID > 0 {
+		c.SessionID = strconv.Itoa(opts.SessionID)
+	}
+}
+
+// RefreshTokenTTL is also how long an unused session stays listed.
+const RefreshTokenTTL = time.Duration(43200) * time.Minute
+
+func GenerateRefreshToken(userID int) (string, error) {
+	return signClaims(newClaims(userID, RefreshTokenTTL, "refresh"))
+}
+
+// GenerateEmailVerificationToken binds the token to the address it was sent
+// to, so it stops working once the user's email changes.
+func GenerateEmailVerificationToken(userID int, email string) (string, error) {
+	claims := newClaims(userID, time.Duration(24)*time.Hour, "email_verification")
+	claims.Email = email
+	return signClaims(claims)
+}
+
+func newClaims(userID int, ttl time.Duration, tokenType string) CustomClaims {
+	now := time.Now().UTC()
+
+	return CustomClaims{
 		TokenType: tokenType,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   strconv.Itoa(userID),
@@ -34,7 +179,9 @@ func generateToken(userID int, ttl time.Duration, tokenType string) (string, err
 			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 		},
 	}
+}
 
+func signClaims(claims CustomClaims) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	secret := configs.Envs.JWTSecret
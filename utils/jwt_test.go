@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"auth-api/configs"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestPersonalAccessTokenClaimsOmitRoles(t *testing.T) {
+	claims := NewPersonalAccessTokenClaims(7, 3, time.Now(), AccessTokenOptions{
+		Roles:       []string{"admin", "support"},
+		Permissions: []string{"users:read"},
+	})
+
+	if len(claims.Roles) != 0 {
+		t.Errorf("Roles = %v, want none", claims.Roles)
+	}
+	if claims.Scope != "users:read" {
+		t.Errorf("Scope = %q, want %q", claims.Scope, "users:read")
+	}
+	if claims.HasScope("users:write") {
+		t.Error("token has a scope it was not created with")
+	}
+}
+
+func TestExchangedTokenKeepsSessionAndImpersonation(t *testing.T) {
+	secret := configs.Envs.JWTSecret
+	configs.Envs.JWTSecret = "test-secret"
+	t.Cleanup(func() { configs.Envs.JWTSecret = secret })
+
+	subject := &CustomClaims{
+		TokenType:     "access",
+		SessionID:     "42",
+		Act:           &ActorClaim{Subject: "1"},
+		Impersonation: true,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: "7",
+		},
+	}
+	act := &ActorClaim{Subject: "99", Act: subject.Act}
+
+	raw, err := GenerateExchangedToken(subject, act, []string{"billing"}, []string{"users:read"}, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseToken(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.SessionID != "42" {
+		t.Errorf("SessionID = %q, want %q", claims.SessionID, "42")
+	}
+	if !claims.Impersonation {
+		t.Error("exchanged impersonation token lost the imp claim")
+	}
+
+	ctx := context.WithValue(context.Background(), contextKeyClaims, claims)
+	if adminID, ok := GetActorIDFromContext(ctx); !ok || adminID != 1 {
+		t.Errorf("GetActorIDFromContext = %d, %v, want the impersonating admin 1", adminID, ok)
+	}
+}
+
+func TestDelegatedTokenHasNoImpersonatingAdmin(t *testing.T) {
+	claims := &CustomClaims{TokenType: "access", Act: &ActorClaim{Subject: "99"}}
+	ctx := context.WithValue(context.Background(), contextKeyClaims, claims)
+
+	if actorID, ok := GetActorIDFromContext(ctx); ok {
+		t.Errorf("GetActorIDFromContext = %d, want none for a delegated token", actorID)
+	}
+}
+
+func TestAccessTokenCappedAtSessionExpiry(t *testing.T) {
+	secret := configs.Envs.JWTSecret
+	configs.Envs.JWTSecret = "test-secret"
+	t.Cleanup(func() { configs.Envs.JWTSecret = secret })
+
+	sessionEnd := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name    string
+		expires time.Time
+		want    func(time.Time) bool
+	}{
+		{"session ends first", sessionEnd, func(exp time.Time) bool { return exp.Equal(sessionEnd) }},
+		{"session outlives token", time.Now().Add(48 * time.Hour), func(exp time.Time) bool {
+			return exp.Before(time.Now().Add(12*time.Hour + time.Minute))
+		}},
+		{"no session", time.Time{}, func(exp time.Time) bool { return exp.After(time.Now().Add(11 * time.Hour)) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := GenerateAccessToken(7, AccessTokenOptions{SessionID: 1, SessionExpiresAt: tt.expires})
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := ParseToken(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.want(claims.ExpiresAt.Time) {
+				t.Errorf("exp = %v", claims.ExpiresAt.Time)
+			}
+		})
+	}
+}
+
+func TestAccessTokenIssuedAtKeepsSubSecondPrecision(t *testing.T) {
+	secret := configs.Envs.JWTSecret
+	configs.Envs.JWTSecret = "test-secret"
+	t.Cleanup(func() { configs.Envs.JWTSecret = secret })
+
+	before := time.Now().Truncate(time.Microsecond)
+	raw, err := GenerateAccessToken(7, AccessTokenOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseToken(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if iat := claims.IssuedAt.Time; iat.Before(before) || iat.Nanosecond()%int(time.Microsecond) != 0 {
+		t.Errorf("iat = %s, want microseconds no earlier than %s", iat.Format(time.RFC3339Nano), before.Format(time.RFC3339Nano))
+	}
+}
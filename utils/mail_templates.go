@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates/mail
+var mailTemplates embed.FS
+
+const defaultMailLocale = "en"
+
+// RenderMail builds a message from templates/mail/<name>.<locale>.txt.tmpl
+// and the optional matching .html.tmpl. The text template must define a
+// "subject" block. Locales fall back from "pt-BR" to "pt" to the default.
+func RenderMail(name, locale string, data any) (MailMessage, error) {
+	for _, loc := range mailLocaleCandidates(locale) {
+		msg, err := renderMailLocale(name, loc, data)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		return msg, err
+	}
+
+	return MailMessage{}, fmt.Errorf("mail template %q not found", name)
+}
+
+func renderMailLocale(name, locale string, data any) (MailMessage, error) {
+	base := "templates/mail/" + name + "." + locale
+
+	textSrc, err := mailTemplates.ReadFile(base + ".txt.tmpl")
+	if err != nil {
+		return MailMessage{}, err
+	}
+
+	textTmpl, err := texttemplate.New(name).Parse(string(textSrc))
+	if err != nil {
+		return MailMessage{}, err
+	}
+
+	var subject, text bytes.Buffer
+	if err := textTmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return MailMessage{}, err
+	}
+	if err := textTmpl.Execute(&text, data); err != nil {
+		return MailMessage{}, err
+	}
+
+	msg := MailMessage{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimLeft(text.String(), "\n"),
+	}
+
+	htmlSrc, err := mailTemplates.ReadFile(base + ".html.tmpl")
+	if errors.Is(err, fs.ErrNotExist) {
+		return msg, nil
+	}
+	if err != nil {
+		return MailMessage{}, err
+	}
+
+	htmlTmpl, err := htmltemplate.New(name).Parse(string(htmlSrc))
+	if err != nil {
+		return MailMessage{}, err
+	}
+
+	var html bytes.Buffer
+	if err := htmlTmpl.Execute(&html, data); err != nil {
+		return MailMessage{}, err
+	}
+	msg.HTML = html.String()
+
+	return msg, nil
+}
+
+func mailLocaleCandidates(locale string) []string {
+	locale = strings.ReplaceAll(strings.TrimSpace(locale), "_", "-")
+
+	var out []string
+	if locale != "" {
+		out = append(out, locale)
+		if i := strings.Index(locale, "-"); i > 0 {
+			out = append(out, locale[:i])
+		}
+	}
+	return append(out, defaultMailLocale)
+}
@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"auth-api/configs"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"strings"
+	"time"
+)
+
+type MailMessage struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type Mailer interface {
+	Send(msg MailMessage) error
+}
+
+// NewMailer picks a backend based on MAIL_DRIVER.
+func NewMailer(cfg configs.Config) (Mailer, error) {
+	switch cfg.MailDriver {
+	case "", "log":
+		return NewLogMailer(cfg.MailFrom), nil
+	case "smtp":
+		if cfg.SMTPHost == "" {
+			return nil, fmt.Errorf("SMTP_HOST is not configured")
+		}
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom), nil
+	case "file":
+		return NewFileMailer(cfg.MailDropDir, cfg.MailFrom)
+	case "memory":
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", cfg.MailDriver)
+	}
+}
+
+// LogMailer writes outgoing mail to the server log instead of delivering it.
+type LogMailer struct {
+	From string
+}
+
+func NewLogMailer(from string) *LogMailer {
+	return &LogMailer{From: from}
+}
+
+func (m *LogMailer) Send(msg MailMessage) error {
+	log.Printf("mail from=%s to=%s subject=%q\n%s", m.From, msg.To, msg.Subject, msg.Text)
+	return nil
+}
+
+// buildMIMEMessage renders msg as an RFC 5322 message, using
+// multipart/alternative when both a text and an HTML body are present.
+func buildMIMEMessage(from string, msg MailMessage) ([]byte, error) {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if msg.HTML == "" {
+		if err := writeMIMEPart(&buf, "text/plain", msg.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	boundary, err := randomBoundary()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+
+	parts := []struct{ contentType, body string }{
+		{"text/plain", msg.Text},
+		{"text/html", msg.HTML},
+	}
+	for _, p := range parts {
+		if p.body == "" {
+			continue
+		}
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		if err := writeMIMEPart(&buf, p.contentType, p.body); err != nil {
+			return nil, err
+		}
+		buf.WriteString("\r\n")
+	}
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+
+	return buf.Bytes(), nil
+}
+
+func writeMIMEPart(buf *bytes.Buffer, contentType, body string) error {
+	fmt.Fprintf(buf, "Content-Type: %s; charset=utf-8\r\n", contentType)
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(buf)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func randomBoundary() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// FileMailer drops every message into a maildir (dir/tmp, dir/new, dir/cur)
+// so local mail clients or a quick `ls` can inspect what would have been sent.
+type FileMailer struct {
+	Dir  string
+	From string
+
+	seq atomic.Uint64
+}
+
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	return &FileMailer{Dir: dir, From: from}, nil
+}
+
+func (m *FileMailer) Send(msg MailMessage) error {
+	body, err := buildMIMEMessage(m.From, msg)
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%d_%d.%s.eml", time.Now().UnixNano(), os.Getpid(), m.seq.Add(1), hostname)
+
+	tmpPath := filepath.Join(m.Dir, "tmp", name)
+	if err := os.WriteFile(tmpPath, body, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, filepath.Join(m.Dir, "new", name))
+}
@@ -0,0 +1,47 @@
+package utils
+
+import "sync"
+
+// MemoryMailer keeps sent messages in memory so tests can assert on them.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []MailMessage
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(msg MailMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func (m *MemoryMailer) Messages() []MailMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]MailMessage, len(m.messages))
+	copy(out, m.messages)
+	return out
+}
+
+func (m *MemoryMailer) Last() (MailMessage, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.messages) == 0 {
+		return MailMessage{}, false
+	}
+	return m.messages[len(m.messages)-1], true
+}
+
+func (m *MemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = nil
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"net"
+	"net/smtp"
+)
+
+// SMTPMailer delivers mail through an SMTP relay. net/smtp upgrades the
+// connection with STARTTLS whenever the server advertises it.
+type SMTPMailer struct {
+	Addr string
+	Auth smtp.Auth
+	From string
+}
+
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTPMailer{
+		Addr: net.JoinHostPort(host, port),
+		Auth: auth,
+		From: from,
+	}
+}
+
+func (m *SMTPMailer) Send(msg MailMessage) error {
+	body, err := buildMIMEMessage(m.From, msg)
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{msg.To}, body)
+}
@@ -1,9 +1,7 @@
 package utils
 
 import (
-	"net"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
 )
@@ -56,7 +54,7 @@ func RateLimit(maxRequests int, window time.Duration) func(http.Handler) http.Ha
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ip := clientIPFromRequest(r)
+			ip := ClientIP(r)
 			key := ip + "|" + r.URL.Path
 
 			if !rl.allow(key) {
@@ -68,22 +66,3 @@ func RateLimit(maxRequests int, window time.Duration) func(http.Handler) http.Ha
 		})
 	}
 }
-
-func clientIPFromRequest(r *http.Request) string {
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		if len(parts) > 0 {
-			return strings.TrimSpace(parts[0])
-		}
-	}
-
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return strings.TrimSpace(xri)
-	}
-
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
-	}
-	return host
-}
@@ -21,6 +21,12 @@ func WriteError(w http.ResponseWriter, status int, err error) {
 	_ = WriteJSON(w, status, map[string]string{"error": err.Error()})
 }
 
+// WriteErrorCode adds a machine-readable code for errors clients are
+// expected to branch on.
+func WriteErrorCode(w http.ResponseWriter, status int, code string, err error) {
+	_ = WriteJSON(w, status, map[string]string{"error": err.Error(), "code": code})
+}
+
 func ParseJSON(r *http.Request, v any) error {
 	if r.Body == nil {
 		return errors.New("missing request body")